@@ -0,0 +1,652 @@
+package gomplate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// archive formats supported by --input-dir and --output-dir
+const (
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+	archiveZip   = "zip"
+)
+
+// archiveFormat - the format of the archive at the given path, judging by its
+// extension, if it's an archive
+func archiveFormat(p string) (string, bool) {
+	name := strings.ToLower(p)
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar, true
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz, true
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip, true
+	}
+	return "", false
+}
+
+// isArchive - whether the path names an archive, rather than a directory
+func isArchive(base afero.Fs, p string) bool {
+	if _, ok := archiveFormat(p); !ok {
+		return false
+	}
+	fi, err := base.Stat(p)
+	return err != nil || !fi.IsDir()
+}
+
+// archiveModTime - the modification time of every entry in an output archive,
+// so that rendering the same files always produces an identical archive
+var archiveModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// archiveFs - a filesystem which holds the contents of an archive in memory,
+// at the archive's path (so "bundle.tar.gz/a.tmpl" is a.tmpl in
+// bundle.tar.gz). All other paths are in the base filesystem.
+type archiveFs struct {
+	base afero.Fs
+	// name - the archive's path, as given, and abs - its absolute path
+	name string
+	abs  string
+	// files - the archive's contents
+	files afero.Fs
+}
+
+var _ afero.Fs = (*archiveFs)(nil)
+
+// openArchiveFs - a filesystem with the contents of the archive at p (read
+// from base) at its path. A missing archive is empty, unless it must exist.
+// The contents are read-only unless writable is set.
+func openArchiveFs(base afero.Fs, p string, mustExist, writable bool) (*archiveFs, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return nil, err
+	}
+	files := afero.NewMemMapFs()
+	// the root is the directory output directories are created like, when
+	// rendering from an archive
+	if err = files.Chmod(string(filepath.Separator), os.ModeDir|0755); err != nil {
+		return nil, err
+	}
+	b, err := afero.ReadFile(base, p)
+	switch {
+	case os.IsNotExist(err) && !mustExist:
+	case err != nil:
+		return nil, err
+	default:
+		if err = readArchive(files, p, b); err != nil {
+			return nil, errors.Wrapf(err, "failed to read archive %s", p)
+		}
+	}
+	a := &archiveFs{base: base, name: p, abs: abs, files: files}
+	if !writable {
+		a.files = afero.NewReadOnlyFs(files)
+	}
+	return a, nil
+}
+
+// readArchive - extract the archive's contents (named p, with content b) into
+// the filesystem
+func readArchive(files afero.Fs, p string, b []byte) error {
+	format, _ := archiveFormat(p)
+	if format == archiveZip {
+		return readZip(files, b)
+	}
+	var r io.Reader = bytes.NewReader(b)
+	if format == archiveTarGz {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		// nolint: errcheck
+		defer gz.Close()
+		r = gz
+	}
+	return readTar(files, r)
+}
+
+// archiveLink - a link in an archive, to the entry named target (relative to
+// the archive's root)
+type archiveLink struct {
+	name, target string
+}
+
+func readTar(files afero.Fs, r io.Reader) error {
+	tr := tar.NewReader(r)
+	links := []archiveLink{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return resolveArchiveLinks(files, links)
+		}
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = addArchiveDir(files, hdr.Name, mode, hdr.ModTime)
+		case tar.TypeReg, tar.TypeRegA:
+			err = addArchiveFile(files, hdr.Name, mode, hdr.ModTime, tr)
+		case tar.TypeSymlink:
+			var l archiveLink
+			l, err = symlinkTarget(hdr.Name, hdr.Linkname)
+			links = append(links, l)
+		case tar.TypeLink:
+			// hard links name their target relative to the archive's root
+			links = append(links, archiveLink{
+				name:   path.Clean(strings.TrimPrefix(hdr.Name, "/")),
+				target: path.Clean(strings.TrimPrefix(hdr.Linkname, "/")),
+			})
+		default:
+			err = errors.Errorf("archive entry %q is a special file (type %q), which isn't supported", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func readZip(files afero.Fs, b []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return err
+	}
+	links := []archiveLink{}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = addArchiveDir(files, f.Name, mode.Perm(), f.Modified)
+		case mode.IsRegular():
+			err = addArchiveFileFrom(files, f, mode.Perm())
+		case mode&os.ModeSymlink != 0:
+			// a symlink's content is its target
+			var l archiveLink
+			l, err = zipSymlink(f)
+			links = append(links, l)
+		default:
+			err = errors.Errorf("archive entry %q is a special file (%s), which isn't supported", f.Name, mode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return resolveArchiveLinks(files, links)
+}
+
+func zipSymlink(f *zip.File) (archiveLink, error) {
+	r, err := f.Open()
+	if err != nil {
+		return archiveLink{}, err
+	}
+	// nolint: errcheck
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return archiveLink{}, err
+	}
+	return symlinkTarget(f.Name, string(b))
+}
+
+// symlinkTarget - the link from the symlink entry name to linkname, which is
+// relative to the directory the entry is in. Links can't point outside of the
+// archive.
+func symlinkTarget(name, linkname string) (archiveLink, error) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	target := path.Clean(path.Join(path.Dir(name), linkname))
+	if path.IsAbs(linkname) || target == ".." || strings.HasPrefix(target, "../") {
+		return archiveLink{}, errors.Errorf("archive entry %q links to %q, which is outside of the archive", name, linkname)
+	}
+	return archiveLink{name: name, target: target}, nil
+}
+
+// resolveArchiveLinks - replace links with copies of what they link to, since
+// the in-memory filesystem has no links. Links to links are followed, but
+// links which can't be resolved (to missing entries, or in a cycle) are an
+// error.
+func resolveArchiveLinks(files afero.Fs, links []archiveLink) error {
+	for len(links) > 0 {
+		pending := []archiveLink{}
+		for _, l := range links {
+			p, err := archiveEntryPath(l.target)
+			if err != nil {
+				return err
+			}
+			if l.target == "." || strings.HasPrefix(path.Clean(l.name)+"/", l.target+"/") {
+				return errors.Errorf("archive entry %q links to %q, which contains it", l.name, l.target)
+			}
+			// the target may be a link that isn't resolved yet, or a
+			// directory with links in it that aren't
+			if _, err = files.Stat(p); os.IsNotExist(err) || linksWithin(links, l.target) {
+				pending = append(pending, l)
+				continue
+			}
+			if err = copyArchiveEntry(files, p, l.name); err != nil {
+				return errors.Wrapf(err, "failed to resolve archive entry %q", l.name)
+			}
+		}
+		if len(pending) == len(links) {
+			l := pending[0]
+			p, _ := archiveEntryPath(l.target)
+			if _, err := files.Stat(p); err == nil {
+				return errors.Errorf("archive entry %q links to %q, which has links in it that can't be resolved", l.name, l.target)
+			}
+			return errors.Errorf("archive entry %q links to %q, which isn't in the archive", l.name, l.target)
+		}
+		links = pending
+	}
+	return nil
+}
+
+// linksWithin - whether any of the links are in the directory dir
+func linksWithin(links []archiveLink, dir string) bool {
+	for _, l := range links {
+		if strings.HasPrefix(path.Clean(l.name), dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// copyArchiveEntry - copy the file or directory at p (recursively) to the
+// entry name
+func copyArchiveEntry(files afero.Fs, p, name string) error {
+	fi, err := files.Stat(p)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		f, err := files.Open(p)
+		if err != nil {
+			return err
+		}
+		// nolint: errcheck
+		defer f.Close()
+		return addArchiveFile(files, name, fi.Mode().Perm(), fi.ModTime(), f)
+	}
+	if err = addArchiveDir(files, name, fi.Mode().Perm(), fi.ModTime()); err != nil {
+		return err
+	}
+	children, err := afero.ReadDir(files, p)
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		err = copyArchiveEntry(files, filepath.Join(p, c.Name()), path.Join(name, c.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addArchiveFileFrom(files afero.Fs, f *zip.File, mode os.FileMode) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	// nolint: errcheck
+	defer r.Close()
+	return addArchiveFile(files, f.Name, mode, f.Modified, r)
+}
+
+// archiveEntryPath - the path of an archive entry in the filesystem. Entries
+// can't be outside of the archive's root.
+func archiveEntryPath(name string) (string, error) {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", errors.Errorf("invalid archive entry %q - entries can't be outside of the archive", name)
+		}
+	}
+	return filepath.FromSlash(path.Clean("/" + name)), nil
+}
+
+func addArchiveDir(files afero.Fs, name string, mode os.FileMode, modTime time.Time) error {
+	p, err := archiveEntryPath(name)
+	if err != nil {
+		return err
+	}
+	if err = mkdirAll(files, p, mode); err != nil {
+		return err
+	}
+	if err = files.Chmod(p, os.ModeDir|mode); err != nil {
+		return err
+	}
+	return files.Chtimes(p, modTime, modTime)
+}
+
+func addArchiveFile(files afero.Fs, name string, mode os.FileMode, modTime time.Time, r io.Reader) error {
+	p, err := archiveEntryPath(name)
+	if err != nil {
+		return err
+	}
+	if err = mkdirAll(files, filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := files.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err = files.Chmod(p, mode); err != nil {
+		return err
+	}
+	return files.Chtimes(p, modTime, modTime)
+}
+
+// mkdirAll - like MkdirAll, but every missing directory gets the mode, not
+// just the last (MemMapFs creates missing parents without permissions)
+func mkdirAll(files afero.Fs, p string, perm os.FileMode) error {
+	if _, err := files.Stat(p); err == nil {
+		return nil
+	}
+	if parent := filepath.Dir(p); parent != p {
+		if err := mkdirAll(files, parent, perm); err != nil {
+			return err
+		}
+	}
+	err := files.Mkdir(p, perm)
+	if err != nil && os.IsExist(err) {
+		return nil
+	}
+	return err
+}
+
+// writeArchive - write the archive's contents to w, in the archive's format.
+// Entries are written in order of their paths, all with archiveModTime.
+func (a *archiveFs) writeArchive(w io.Writer) error {
+	entries := []string{}
+	err := afero.Walk(a.files, string(filepath.Separator), func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != string(filepath.Separator) {
+			entries = append(entries, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(entries)
+
+	format, _ := archiveFormat(a.name)
+	if format == archiveZip {
+		return a.writeZip(w, entries)
+	}
+	if format == archiveTarGz {
+		gz := gzip.NewWriter(w)
+		if err = a.writeTar(gz, entries); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+	return a.writeTar(w, entries)
+}
+
+func (a *archiveFs) writeTar(w io.Writer, entries []string) error {
+	tw := tar.NewWriter(w)
+	for _, p := range entries {
+		fi, err := a.files.Stat(p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = archiveEntryName(p, fi.IsDir())
+		hdr.ModTime = archiveModTime
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			if err = a.copyEntry(tw, p); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+func (a *archiveFs) writeZip(w io.Writer, entries []string) error {
+	zw := zip.NewWriter(w)
+	for _, p := range entries {
+		fi, err := a.files.Stat(p)
+		if err != nil {
+			return err
+		}
+		hdr, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		hdr.Name = archiveEntryName(p, fi.IsDir())
+		hdr.Modified = archiveModTime
+		if !fi.IsDir() {
+			hdr.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			if err = a.copyEntry(fw, p); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+func (a *archiveFs) copyEntry(w io.Writer, p string) error {
+	f, err := a.files.Open(p)
+	if err != nil {
+		return err
+	}
+	// nolint: errcheck
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// archiveEntryName - the name of the entry for the path, relative to the
+// archive's root. Directory names end with a slash.
+func archiveEntryName(p string, dir bool) string {
+	name := strings.TrimPrefix(filepath.ToSlash(p), "/")
+	if dir {
+		name += "/"
+	}
+	return name
+}
+
+// route - the filesystem the named file is in, and its name there
+func (a *archiveFs) route(name string) (afero.Fs, string) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return a.base, name
+	}
+	if abs == a.abs {
+		return a.files, string(filepath.Separator)
+	}
+	if strings.HasPrefix(abs, a.abs+string(filepath.Separator)) {
+		return a.files, abs[len(a.abs):]
+	}
+	return a.base, name
+}
+
+// archiveFile - a file in the archive, named by its path outside of it
+type archiveFile struct {
+	afero.File
+	name string
+}
+
+func (f *archiveFile) Name() string {
+	return f.name
+}
+
+// archiveRootInfo - the archive's root directory, named like the archive
+type archiveRootInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *archiveRootInfo) Name() string {
+	return fi.name
+}
+
+func (a *archiveFs) wrapFile(fsys afero.Fs, name string, f afero.File, err error) (afero.File, error) {
+	if err != nil || fsys != a.files {
+		return f, err
+	}
+	return &archiveFile{File: f, name: name}, nil
+}
+
+// Name - the name of the filesystem
+func (a *archiveFs) Name() string {
+	return "archiveFs"
+}
+
+// Create -
+func (a *archiveFs) Create(name string) (afero.File, error) {
+	fsys, p := a.route(name)
+	f, err := fsys.Create(p)
+	return a.wrapFile(fsys, name, f, err)
+}
+
+// Mkdir -
+func (a *archiveFs) Mkdir(name string, perm os.FileMode) error {
+	fsys, p := a.route(name)
+	return fsys.Mkdir(p, perm)
+}
+
+// MkdirAll -
+func (a *archiveFs) MkdirAll(name string, perm os.FileMode) error {
+	fsys, p := a.route(name)
+	if fsys == a.files {
+		return mkdirAll(fsys, p, perm)
+	}
+	return fsys.MkdirAll(p, perm)
+}
+
+// Open -
+func (a *archiveFs) Open(name string) (afero.File, error) {
+	fsys, p := a.route(name)
+	f, err := fsys.Open(p)
+	return a.wrapFile(fsys, name, f, err)
+}
+
+// OpenFile -
+func (a *archiveFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	fsys, p := a.route(name)
+	f, err := fsys.OpenFile(p, flag, perm)
+	return a.wrapFile(fsys, name, f, err)
+}
+
+// Remove -
+func (a *archiveFs) Remove(name string) error {
+	fsys, p := a.route(name)
+	return fsys.Remove(p)
+}
+
+// RemoveAll -
+func (a *archiveFs) RemoveAll(name string) error {
+	fsys, p := a.route(name)
+	return fsys.RemoveAll(p)
+}
+
+// Rename - files can't be moved in to or out of the archive
+func (a *archiveFs) Rename(oldname, newname string) error {
+	oldfs, oldp := a.route(oldname)
+	newfs, newp := a.route(newname)
+	if oldfs != newfs {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errors.New("can't move files in to or out of an archive")}
+	}
+	return oldfs.Rename(oldp, newp)
+}
+
+// Stat -
+func (a *archiveFs) Stat(name string) (os.FileInfo, error) {
+	fsys, p := a.route(name)
+	fi, err := fsys.Stat(p)
+	if err == nil && fsys == a.files && p == string(filepath.Separator) {
+		fi = &archiveRootInfo{FileInfo: fi, name: filepath.Base(a.name)}
+	}
+	return fi, err
+}
+
+// Chmod -
+func (a *archiveFs) Chmod(name string, mode os.FileMode) error {
+	fsys, p := a.route(name)
+	return fsys.Chmod(p, mode)
+}
+
+// Chtimes -
+func (a *archiveFs) Chtimes(name string, atime, mtime time.Time) error {
+	fsys, p := a.route(name)
+	return fsys.Chtimes(p, atime, mtime)
+}
+
+// useArchives - when --input-dir or --output-dir name archives (rather than
+// directories), read their contents into memory, and return a filesystem
+// routing their paths there, on top of base, for the rest of the run. The
+// returned function writes the output archive, if there is one and write is
+// set.
+func useArchives(base afero.Fs, o *Config) (fs afero.Fs, finish func(write bool) error, err error) {
+	done := func(bool) error { return nil }
+	if o.InputDir == "" {
+		return base, done, nil
+	}
+	inArchive := isArchive(base, o.InputDir)
+	outArchive := isArchive(base, o.OutputDir)
+	if (inArchive || outArchive) && o.Watch {
+		return nil, nil, errors.New("--watch can't be used with archives")
+	}
+	// hooks need real files to run on
+	if outArchive && len(o.PostRender) > 0 {
+		return nil, nil, errors.New("--post-render can't be used with an output archive")
+	}
+	fs = base
+	if inArchive {
+		fs, err = openArchiveFs(base, o.InputDir, true, false)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if !outArchive {
+		return fs, done, nil
+	}
+	out, err := openArchiveFs(fs, o.OutputDir, false, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, func(write bool) error {
+		if !write {
+			return nil
+		}
+		f, err := createOutFile(base, o.OutputDir, 0644, false, false)
+		if err != nil {
+			return err
+		}
+		return closeTarget(f, out.writeArchive(f))
+	}, nil
+}
@@ -0,0 +1,300 @@
+package gomplate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+type testEntry struct {
+	name    string
+	mode    os.FileMode
+	content string
+	// link - the target of a link: a symlink when the mode has
+	// os.ModeSymlink, otherwise a hard link
+	link string
+}
+
+func testTarGz(t *testing.T, entries ...testEntry) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: int64(e.mode.Perm()), ModTime: time.Unix(0, 0), Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		switch {
+		case e.mode.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Size = 0
+		case e.mode&os.ModeSymlink != 0:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.link
+		case e.link != "":
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = e.link
+		case e.mode&os.ModeNamedPipe != 0:
+			hdr.Typeflag = tar.TypeFifo
+		}
+		assert.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func readTestZip(t *testing.T, b []byte) []testEntry {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	assert.NoError(t, err)
+	entries := []testEntry{}
+	for _, f := range zr.File {
+		e := testEntry{name: f.Name, mode: f.Mode()}
+		if !f.Mode().IsDir() {
+			r, err := f.Open()
+			assert.NoError(t, err)
+			c, _ := ioutil.ReadAll(r)
+			e.content = string(c)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestArchiveFormat(t *testing.T) {
+	for in, expected := range map[string]string{
+		"a.tar": archiveTar, "a.tar.gz": archiveTarGz, "A.TGZ": archiveTarGz,
+		"a.zip": archiveZip, "a": "", "a.gz": "",
+	} {
+		f, ok := archiveFormat(in)
+		assert.Equal(t, expected, f, in)
+		assert.Equal(t, expected != "", ok, in)
+	}
+
+	_, err := archiveEntryPath("../x")
+	assert.Error(t, err)
+	p, err := archiveEntryPath("./a//b/")
+	assert.NoError(t, err)
+	assert.Equal(t, string(os.PathSeparator)+"a"+string(os.PathSeparator)+"b", p)
+}
+
+func TestArchiveFs(t *testing.T) {
+	base := afero.NewMemMapFs()
+	_ = afero.WriteFile(base, "outside.txt", []byte("outside"), 0644)
+	_ = afero.WriteFile(base, "in.tar.gz", testTarGz(t,
+		testEntry{name: "dir/", mode: os.ModeDir | 0750},
+		testEntry{name: "dir/a.txt", mode: 0600, content: "a"},
+	), 0644)
+
+	a, err := openArchiveFs(base, "in.tar.gz", true, false)
+	assert.NoError(t, err)
+
+	fi, err := a.Stat("in.tar.gz")
+	assert.NoError(t, err)
+	assert.True(t, fi.IsDir())
+	assert.Equal(t, "in.tar.gz", fi.Name())
+
+	fi, err = a.Stat("in.tar.gz/dir")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), fi.Mode().Perm())
+
+	f, err := a.Open("in.tar.gz/dir/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "in.tar.gz/dir/a.txt", f.Name())
+	b, _ := ioutil.ReadAll(f)
+	assert.Equal(t, "a", string(b))
+	fi, _ = f.Stat()
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	b, err = afero.ReadFile(a, "outside.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "outside", string(b))
+
+	// input archives are read-only
+	assert.Error(t, afero.WriteFile(a, "in.tar.gz/b.txt", []byte("b"), 0644))
+
+	_, err = openArchiveFs(base, "missing.tar", true, false)
+	assert.Error(t, err)
+	_ = afero.WriteFile(base, "bad.zip", []byte("not a zip"), 0644)
+	_, err = openArchiveFs(base, "bad.zip", true, false)
+	assert.Error(t, err)
+
+	out, err := openArchiveFs(base, "out.zip", false, true)
+	assert.NoError(t, err)
+	assert.NoError(t, out.MkdirAll("out.zip/x", 0755))
+	assert.NoError(t, afero.WriteFile(out, "out.zip/x/b.txt", []byte("b"), 0640))
+	assert.Error(t, out.Rename("out.zip/x/b.txt", "b.txt"))
+	buf := &bytes.Buffer{}
+	assert.NoError(t, out.writeArchive(buf))
+	assert.Equal(t, []testEntry{
+		{name: "x/", mode: os.ModeDir | 0755},
+		{name: "x/b.txt", mode: 0640, content: "b"},
+	}, readTestZip(t, buf.Bytes()))
+	// entries all have the same fixed modification time, so the archive is
+	// the same every time
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	for _, f := range zr.File {
+		assert.True(t, archiveModTime.Equal(f.Modified), f.Name)
+	}
+	assert.NoError(t, out.Chtimes("out.zip/x/b.txt", time.Now(), time.Now()))
+	again := &bytes.Buffer{}
+	assert.NoError(t, out.writeArchive(again))
+	assert.Equal(t, buf.Bytes(), again.Bytes())
+	exists, _ := afero.Exists(base, "x/b.txt")
+	assert.False(t, exists)
+}
+
+func TestArchiveLinks(t *testing.T) {
+	base := afero.NewMemMapFs()
+	// links can come before what they link to, and link to other links
+	_ = afero.WriteFile(base, "in.tar.gz", testTarGz(t,
+		testEntry{name: "latest", mode: os.ModeSymlink, link: "v1"},
+		testEntry{name: "v1/", mode: os.ModeDir | 0755},
+		testEntry{name: "v1/a.txt", mode: 0600, content: "a"},
+		testEntry{name: "v1/b.txt", mode: os.ModeSymlink, link: "../c.txt"},
+		testEntry{name: "c.txt", mode: 0644, link: "v1/a.txt"},
+	), 0644)
+
+	a, err := openArchiveFs(base, "in.tar.gz", true, false)
+	assert.NoError(t, err)
+	for _, p := range []string{"v1/a.txt", "v1/b.txt", "c.txt", "latest/a.txt", "latest/b.txt"} {
+		b, err := afero.ReadFile(a, "in.tar.gz/"+p)
+		assert.NoError(t, err, p)
+		assert.Equal(t, "a", string(b), p)
+		fi, err := a.Stat("in.tar.gz/" + p)
+		assert.NoError(t, err, p)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm(), p)
+	}
+
+	// the same goes for zip archives, where a symlink's content is its target
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	hdr := &zip.FileHeader{Name: "link.txt"}
+	hdr.SetMode(os.ModeSymlink | 0777)
+	w, _ := zw.CreateHeader(hdr)
+	_, _ = w.Write([]byte("a.txt"))
+	w, _ = zw.Create("a.txt")
+	_, _ = w.Write([]byte("zipped"))
+	assert.NoError(t, zw.Close())
+	_ = afero.WriteFile(base, "in.zip", buf.Bytes(), 0644)
+	a, err = openArchiveFs(base, "in.zip", true, false)
+	assert.NoError(t, err)
+	b, err := afero.ReadFile(a, "in.zip/link.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "zipped", string(b))
+
+	testdata := []struct {
+		entries []testEntry
+		err     string
+	}{
+		{[]testEntry{{name: "l", mode: os.ModeSymlink, link: "missing"}}, `archive entry "l" links to "missing", which isn't in the archive`},
+		{[]testEntry{{name: "x", mode: os.ModeSymlink, link: "y"}, {name: "y", mode: os.ModeSymlink, link: "x"}}, `archive entry "x" links to "y", which isn't in the archive`},
+		{[]testEntry{{name: "d/l", mode: os.ModeSymlink, link: "../../etc/passwd"}}, `archive entry "d/l" links to "../../etc/passwd", which is outside of the archive`},
+		{[]testEntry{{name: "l", mode: os.ModeSymlink, link: "/etc/passwd"}}, `which is outside of the archive`},
+		{[]testEntry{{name: "d/", mode: os.ModeDir | 0755}, {name: "d/l", mode: os.ModeSymlink, link: "."}}, `archive entry "d/l" links to "d", which contains it`},
+		{[]testEntry{{name: "fifo", mode: os.ModeNamedPipe | 0644}}, `archive entry "fifo" is a special file`},
+	}
+	for _, d := range testdata {
+		_ = afero.WriteFile(base, "bad.tar.gz", testTarGz(t, d.entries...), 0644)
+		_, err = openArchiveFs(base, "bad.tar.gz", true, false)
+		if assert.Error(t, err, d.err) {
+			assert.Contains(t, err.Error(), d.err)
+		}
+	}
+}
+
+func TestRunTemplatesArchives(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	memfs := fs
+
+	_ = afero.WriteFile(fs, "bundle.tar.gz", testTarGz(t,
+		testEntry{name: "bundle/.gomplateignore", mode: 0644, content: "*.bak\n"},
+		testEntry{name: "bundle/a.txt", mode: 0600, content: `{{ "a" | strings.ToUpper }}`},
+		testEntry{name: "bundle/sub/b.sh", mode: 0755, content: `echo {{ (ds "config").b }}`},
+		testEntry{name: "bundle/c.bak", mode: 0644, content: "ignored"},
+	), 0644)
+	_ = afero.WriteFile(fs, "/config.json", []byte(`{"b": "b"}`), 0644)
+
+	// an existing output archive keeps the files which aren't rendered
+	old := &bytes.Buffer{}
+	zw := zip.NewWriter(old)
+	w, _ := zw.Create("old.txt")
+	_, _ = w.Write([]byte("old"))
+	_ = zw.Close()
+	_ = afero.WriteFile(fs, "out.zip", old.Bytes(), 0644)
+
+	err := RunTemplates(&Config{
+		InputDir:    "bundle.tar.gz",
+		OutputDir:   "out.zip",
+		DataSources: []string{"config=file:///config.json"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, memfs, fs, "the package filesystem should be left alone")
+
+	b, err := afero.ReadFile(fs, "out.zip")
+	assert.NoError(t, err)
+	assert.Equal(t, []testEntry{
+		{name: "bundle/", mode: os.ModeDir | 0755},
+		{name: "bundle/.gomplateignore", mode: 0644, content: "*.bak\n"},
+		{name: "bundle/a.txt", mode: 0600, content: "A"},
+		{name: "bundle/sub/", mode: os.ModeDir | 0755},
+		{name: "bundle/sub/b.sh", mode: 0755, content: "echo b"},
+		{name: "old.txt", mode: 0666, content: "old"},
+	}, readTestZip(t, b))
+
+	// nothing is unpacked
+	exists, _ := afero.Exists(fs, "bundle.tar.gz/bundle")
+	assert.False(t, exists)
+
+	// nothing is written when rendering fails
+	_ = afero.WriteFile(fs, "out.zip", old.Bytes(), 0644)
+	err = RunTemplates(&Config{InputDir: "bundle.tar.gz", OutputDir: "out.zip"})
+	assert.Error(t, err)
+	b, _ = afero.ReadFile(fs, "out.zip")
+	assert.Equal(t, old.Bytes(), b)
+
+	err = RunTemplates(&Config{InputDir: "bundle.tar.gz", OutputDir: "out", Watch: true})
+	assert.EqualError(t, err, "--watch can't be used with archives")
+
+	err = RunTemplates(&Config{InputDir: "bundle.tar.gz", OutputDir: "out.zip", PostRender: []string{"*=true"}})
+	assert.EqualError(t, err, "--post-render can't be used with an output archive")
+}
+
+func TestUseArchives(t *testing.T) {
+	base := afero.NewMemMapFs()
+	_ = afero.WriteFile(base, "in.tar.gz", testTarGz(t,
+		testEntry{name: "a.txt", mode: 0644, content: "a"},
+	), 0644)
+	origfs := fs
+
+	afs, finish, err := useArchives(base, &Config{InputDir: "in.tar.gz", OutputDir: "out.zip"})
+	assert.NoError(t, err)
+	assert.Equal(t, origfs, fs, "the package filesystem should be left alone")
+
+	b, err := afero.ReadFile(afs, "in.tar.gz/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(b))
+	assert.NoError(t, afero.WriteFile(afs, "out.zip/a.txt", []byte("A"), 0644))
+	exists, _ := afero.Exists(base, "out.zip")
+	assert.False(t, exists)
+
+	assert.NoError(t, finish(true))
+	b, err = afero.ReadFile(base, "out.zip")
+	assert.NoError(t, err)
+	assert.Equal(t, []testEntry{{name: "a.txt", mode: 0644, content: "A"}}, readTestZip(t, b))
+
+	// without archives, the base filesystem is used as it is
+	afs, _, err = useArchives(base, &Config{InputDir: "in", OutputDir: "out"})
+	assert.NoError(t, err)
+	assert.Equal(t, base, afs)
+}
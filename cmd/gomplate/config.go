@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+
+	"github.com/hairyhenderson/gomplate"
+	"github.com/hairyhenderson/gomplate/env"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+const defaultConfigFile = ".gomplate.yaml"
+
+// defaultIncrementalManifest - the manifest used by --incremental when no file
+// is given
+const defaultIncrementalManifest = ".gomplate-cache.json"
+
+// for overriding in tests
+var fs = afero.NewOsFs()
+
+// loadConfig - build the final config from (in increasing order of
+// precedence) environment variables, the config file, and commandline flags
+func loadConfig(cmd *cobra.Command, args []string) (*gomplate.Config, error) {
+	cfg := envConfig()
+
+	fileConfig, err := readConfigFile(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if fileConfig != nil {
+		cfg = cfg.MergeFrom(fileConfig, configFile)
+	}
+
+	cfg = cfg.MergeFrom(cobraConfig(cmd, args), "flags")
+	return cfg, nil
+}
+
+// readConfigFile - read the config file named by --config. A missing config
+// file is only an error when --config was given explicitly.
+func readConfigFile(cmd *cobra.Command) (*gomplate.Config, error) {
+	f, err := fs.Open(configFile)
+	if err != nil {
+		if os.IsNotExist(err) && !cmd.Flag("config").Changed {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to open config file %s", configFile)
+	}
+	// nolint: errcheck
+	defer f.Close()
+	return gomplate.ParseConfigFile(f, configFile)
+}
+
+// envConfig - the config values that can be set with environment variables
+func envConfig() *gomplate.Config {
+	cfg := &gomplate.Config{}
+	cfg.MergeFrom(&gomplate.Config{LDelim: env.Getenv("GOMPLATE_LEFT_DELIM")}, "$GOMPLATE_LEFT_DELIM")
+	cfg.MergeFrom(&gomplate.Config{RDelim: env.Getenv("GOMPLATE_RIGHT_DELIM")}, "$GOMPLATE_RIGHT_DELIM")
+	return cfg
+}
+
+// cobraConfig - a config containing only the values set by commandline flags
+// (and the post-exec command), so that unset flags don't override values from
+// the config file
+// nolint: gocyclo
+func cobraConfig(cmd *cobra.Command, args []string) *gomplate.Config {
+	changed := func(name string) bool {
+		f := cmd.Flag(name)
+		return f != nil && f.Changed
+	}
+
+	cfg := &gomplate.Config{}
+	if changed("in") {
+		cfg.Input = opts.Input
+	}
+	if changed("file") {
+		cfg.InputFiles = opts.InputFiles
+	}
+	if changed("input-dir") {
+		cfg.InputDir = opts.InputDir
+	}
+	if changed("exclude") || changed("include") {
+		cfg.ExcludeGlob = processIncludes(includes, opts.ExcludeGlob)
+	}
+	if changed("out") {
+		cfg.OutputFiles = opts.OutputFiles
+	}
+	if changed("output-dir") {
+		cfg.OutputDir = opts.OutputDir
+	}
+	if changed("output-map") {
+		cfg.OutputMap = opts.OutputMap
+	}
+	if changed("chmod") {
+		cfg.OutMode = opts.OutMode
+	}
+	if changed("datasource") {
+		cfg.DataSources = opts.DataSources
+	}
+	if changed("datasource-header") {
+		cfg.DataSourceHeaders = opts.DataSourceHeaders
+	}
+	if changed("context") {
+		cfg.Contexts = opts.Contexts
+	}
+	if changed("plugin") {
+		cfg.Plugins = opts.Plugins
+	}
+	if changed("left-delim") {
+		cfg.LDelim = opts.LDelim
+	}
+	if changed("right-delim") {
+		cfg.RDelim = opts.RDelim
+	}
+	if changed("template") {
+		cfg.Templates = opts.Templates
+	}
+	if changed("post-render") {
+		cfg.PostRender = opts.PostRender
+	}
+	if changed("exec-pipe") {
+		cfg.ExecPipe = opts.ExecPipe
+		cfg.MarkSet("exec_pipe")
+	}
+	if changed("parallelism") {
+		cfg.Parallelism = opts.Parallelism
+	}
+	if changed("diff") {
+		cfg.Diff = opts.Diff
+		cfg.MarkSet("diff")
+	}
+	if changed("check") {
+		cfg.Check = opts.Check
+		cfg.MarkSet("check")
+	}
+	if changed("watch") {
+		cfg.Watch = opts.Watch
+		cfg.MarkSet("watch")
+	}
+	if changed("skip-unchanged") {
+		cfg.SkipUnchanged = opts.SkipUnchanged
+		cfg.MarkSet("skip_unchanged")
+	}
+	if changed("depfile") {
+		cfg.DepFile = opts.DepFile
+	}
+	if changed("incremental") {
+		cfg.Incremental = opts.Incremental
+	}
+	if changed("error-format") {
+		cfg.ErrorFormat = opts.ErrorFormat
+	}
+	if changed("metrics-file") {
+		cfg.MetricsFile = opts.MetricsFile
+	}
+	if changed("foreach") {
+		cfg.ForEach = opts.ForEach
+	}
+	if changed("missing-key") {
+		cfg.MissingKey = opts.MissingKey
+	}
+	if changed("sandbox") {
+		cfg.Sandbox = opts.Sandbox
+	}
+	if len(args) > 0 {
+		cfg.PostExec = args
+	}
+	return cfg
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	cmd, args := parseFlags("-i", "hello")
+	cfg, err := loadConfig(cmd, args)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", cfg.Input)
+
+	_ = afero.WriteFile(fs, defaultConfigFile, []byte(`inputDir: in/
+outputDir: out/
+datasources:
+  data:
+    url: data.json
+`), 0644)
+
+	cmd, args = parseFlags("-d", "foo=foo.json")
+	cfg, err = loadConfig(cmd, args)
+	assert.NoError(t, err)
+	assert.Equal(t, "in/", cfg.InputDir)
+	assert.Equal(t, "out/", cfg.OutputDir)
+	assert.Equal(t, []string{"data=data.json", "foo=foo.json"}, cfg.DataSources)
+
+	cmd, args = parseFlags("-i", "hello", "--", "cat")
+	cfg, err = loadConfig(cmd, args)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", cfg.Input)
+	assert.Equal(t, "", cfg.InputDir)
+	assert.Equal(t, "out/", cfg.OutputDir)
+	assert.Equal(t, []string{"cat"}, cfg.PostExec)
+
+	cmd, args = parseFlags("--post-render", "*.tf=terraform fmt {{ .path }}")
+	cfg, err = loadConfig(cmd, args)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"*.tf=terraform fmt {{ .path }}"}, cfg.PostRender)
+
+	// flags set to false override the config file
+	_ = afero.WriteFile(fs, defaultConfigFile, []byte(`inputDir: in/
+outputDir: out/
+diff: true
+skipUnchanged: true
+`), 0644)
+	cmd, args = parseFlags("--diff=false", "--skip-unchanged=false")
+	cfg, err = loadConfig(cmd, args)
+	assert.NoError(t, err)
+	assert.False(t, cfg.Diff)
+	assert.False(t, cfg.SkipUnchanged)
+
+	cmd, args = parseFlags()
+	cfg, err = loadConfig(cmd, args)
+	assert.NoError(t, err)
+	assert.True(t, cfg.Diff)
+	assert.True(t, cfg.SkipUnchanged)
+
+	cmd, args = parseFlags("--config", "bogus.yaml")
+	_, err = loadConfig(cmd, args)
+	assert.Error(t, err)
+}
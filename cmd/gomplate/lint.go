@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hairyhenderson/gomplate"
+	"github.com/spf13/cobra"
+)
+
+// newLintCmd - the lint subcommand, which checks templates for problems
+// without rendering them
+func newLintCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "lint",
+		Short: "Check templates for problems, without rendering them",
+		Long: `Check templates for problems, without rendering them or reading any datasources.
+
+Templates are parsed with all functions and the configured delimiters, and any
+syntax errors, undefined functions, references to undefined datasources, and
+references to undefined nested templates are reported.`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return notTogether(cmd, "in", "file", "input-dir")
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd, args)
+			if err != nil {
+				return err
+			}
+			if verbose {
+				// nolint: errcheck
+				fmt.Fprintf(os.Stderr, "config is:\n%s\n\n", cfg)
+			}
+
+			problems, err := gomplate.Lint(cfg)
+			if err != nil {
+				return err
+			}
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+			for _, p := range problems {
+				// nolint: errcheck
+				fmt.Fprintln(os.Stdout, p)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("%d problem(s) found", len(problems))
+			}
+			return nil
+		},
+		Args: cobra.NoArgs,
+	}
+}
+
+func initLintFlags(command *cobra.Command) {
+	command.Flags().SortFlags = false
+
+	initDatasourceFlags(command)
+	initInputFlags(command)
+
+	command.Flags().StringArrayVarP(&opts.Templates, "template", "t", []string{}, "Additional template file(s)")
+
+	command.Flags().StringVar(&opts.LDelim, "left-delim", "{{", "override the default left-`delimiter` [$GOMPLATE_LEFT_DELIM]")
+	command.Flags().StringVar(&opts.RDelim, "right-delim", "}}", "override the default right-`delimiter` [$GOMPLATE_RIGHT_DELIM]")
+
+	command.Flags().StringVar(&configFile, "config", defaultConfigFile, "config `file` (overridden by commandline flags)")
+
+	command.Flags().BoolVarP(&verbose, "verbose", "V", false, "output extra information about what gomplate is doing")
+}
@@ -12,17 +12,16 @@ import (
 	"os/signal"
 
 	"github.com/hairyhenderson/gomplate"
-	"github.com/hairyhenderson/gomplate/env"
 	"github.com/hairyhenderson/gomplate/version"
 	"github.com/spf13/cobra"
 )
 
 var (
-	printVer bool
-	verbose  bool
-	execPipe bool
-	opts     gomplate.Config
-	includes []string
+	printVer   bool
+	verbose    bool
+	configFile string
+	opts       gomplate.Config
+	includes   []string
 
 	postRunInput *bytes.Buffer
 )
@@ -31,14 +30,15 @@ func printVersion(name string) {
 	fmt.Printf("%s version %s\n", name, version.Version)
 }
 
-// postRunExec - if templating succeeds, the command following a '--' will be executed
-func postRunExec(cmd *cobra.Command, args []string) error {
-	if len(args) > 0 {
-		name := args[0]
-		args = args[1:]
+// postRunExec - if templating succeeds, the command following a '--' (or set
+// with postExec in the config file) will be executed
+func postRunExec(cfg *gomplate.Config) error {
+	if len(cfg.PostExec) > 0 {
+		name := cfg.PostExec[0]
+		args := cfg.PostExec[1:]
 		// nolint: gosec
 		c := exec.Command(name, args...)
-		if execPipe {
+		if cfg.ExecPipe {
 			c.Stdin = postRunInput
 		} else {
 			c.Stdin = os.Stdin
@@ -89,6 +89,12 @@ func processIncludes(includes, excludes []string) []string {
 	return out
 }
 
+// errReported - an error which has already been reported (with
+// gomplate.WriteErrorReports), so shouldn't be printed again
+type errReported struct {
+	error
+}
+
 func newGomplateCmd() *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:     "gomplate",
@@ -99,32 +105,50 @@ func newGomplateCmd() *cobra.Command {
 				printVersion(cmd.Name())
 				return nil
 			}
+			cfg, err := loadConfig(cmd, args)
+			if err != nil {
+				return err
+			}
+			if err = validateConfig(cfg); err != nil {
+				return err
+			}
 			if verbose {
 				// nolint: errcheck
 				fmt.Fprintf(os.Stderr, "%s version %s, build %s\nconfig is:\n%s\n\n",
 					cmd.Name(), version.Version, version.GitCommit,
-					&opts)
+					cfg)
 			}
 
-			// support --include
-			opts.ExcludeGlob = processIncludes(includes, opts.ExcludeGlob)
-
-			if execPipe {
+			if cfg.ExecPipe {
 				postRunInput = &bytes.Buffer{}
-				opts.Out = postRunInput
+				cfg.Out = postRunInput
 			}
-			err := gomplate.RunTemplates(&opts)
+			err = gomplate.RunTemplates(cfg)
 			cmd.SilenceErrors = true
 			cmd.SilenceUsage = true
 			if verbose {
 				// nolint: errcheck
-				fmt.Fprintf(os.Stderr, "rendered %d template(s) with %d error(s) in %v\n",
+				fmt.Fprintf(os.Stderr, "rendered %d template(s) with %d error(s) in %v",
 					gomplate.Metrics.TemplatesProcessed, gomplate.Metrics.Errors, gomplate.Metrics.TotalRenderDuration)
+				if gomplate.Metrics.TemplatesSkipped > 0 {
+					// nolint: errcheck
+					fmt.Fprintf(os.Stderr, ", skipped %d unchanged template(s)", gomplate.Metrics.TemplatesSkipped)
+				}
+				// nolint: errcheck
+				fmt.Fprintln(os.Stderr)
 			}
-			return err
+			if err != nil {
+				// nolint: errcheck
+				gomplate.WriteErrorReports(os.Stderr, err, cfg.ErrorFormat)
+				return errReported{err}
+			}
+			// nothing was written, so there's nothing to post-process
+			if cfg.Diff || cfg.Check {
+				return nil
+			}
+			return postRunExec(cfg)
 		},
-		PostRunE: postRunExec,
-		Args:     optionalExecArgs,
+		Args: optionalExecArgs,
 	}
 	return rootCmd
 }
@@ -132,44 +156,83 @@ func newGomplateCmd() *cobra.Command {
 func initFlags(command *cobra.Command) {
 	command.Flags().SortFlags = false
 
-	command.Flags().StringArrayVarP(&opts.DataSources, "datasource", "d", nil, "`datasource` in alias=URL form. Specify multiple times to add multiple sources.")
-	command.Flags().StringArrayVarP(&opts.DataSourceHeaders, "datasource-header", "H", nil, "HTTP `header` field in 'alias=Name: value' form to be provided on HTTP-based data sources. Multiples can be set.")
-
-	command.Flags().StringArrayVarP(&opts.Contexts, "context", "c", nil, "pre-load a `datasource` into the context, in alias=URL form. Use the special alias `.` to set the root context.")
-
-	command.Flags().StringArrayVar(&opts.Plugins, "plugin", nil, "plug in an external command as a function in name=path form. Can be specified multiple times")
-
-	command.Flags().StringArrayVarP(&opts.InputFiles, "file", "f", []string{"-"}, "Template `file` to process. Omit to use standard input, or use --in or --input-dir")
-	command.Flags().StringVarP(&opts.Input, "in", "i", "", "Template `string` to process (alternative to --file and --input-dir)")
-	command.Flags().StringVar(&opts.InputDir, "input-dir", "", "`directory` which is examined recursively for templates (alternative to --file and --in)")
-
-	command.Flags().StringArrayVar(&opts.ExcludeGlob, "exclude", []string{}, "glob of files to not parse")
-	command.Flags().StringArrayVar(&includes, "include", []string{}, "glob of files to parse")
+	initDatasourceFlags(command)
+	initInputFlags(command)
 
 	command.Flags().StringArrayVarP(&opts.OutputFiles, "out", "o", []string{"-"}, "output `file` name. Omit to use standard output.")
 	command.Flags().StringArrayVarP(&opts.Templates, "template", "t", []string{}, "Additional template file(s)")
-	command.Flags().StringVar(&opts.OutputDir, "output-dir", ".", "`directory` to store the processed templates. Only used for --input-dir")
+	command.Flags().StringVar(&opts.OutputDir, "output-dir", ".", "`directory` (or .tar, .tar.gz, or .zip archive) to store the processed templates. Only used for --input-dir")
 	command.Flags().StringVar(&opts.OutputMap, "output-map", "", "Template `string` to map the input file to an output path")
+	command.Flags().StringVar(&opts.ForEach, "foreach", "", "render the input once for each item in a `datasource`, in alias or alias:jsonpath form. Requires --output-map")
 	command.Flags().StringVar(&opts.OutMode, "chmod", "", "set the mode for output file(s). Omit to inherit from input file(s)")
+	command.Flags().BoolVar(&opts.SkipUnchanged, "skip-unchanged", false, "don't write output file(s) whose content is unchanged")
+	command.Flags().StringVar(&opts.DepFile, "depfile", "", "write the files each output depends on to this `file`, in Makefile syntax")
+	command.Flags().StringVar(&opts.Incremental, "incremental", "", "skip outputs whose inputs are unchanged since the last run, tracked in this manifest `file`")
+	command.Flags().Lookup("incremental").NoOptDefVal = defaultIncrementalManifest
+
+	command.Flags().BoolVar(&opts.ExecPipe, "exec-pipe", false, "pipe the output to the post-run exec command")
+	command.Flags().StringArrayVar(&opts.PostRender, "post-render", nil, "run a command on each output file matching a glob once it's written, in `glob=command` form, like '*.tf=terraform fmt {{ .path }}'. Can be specified multiple times")
 
-	command.Flags().BoolVar(&execPipe, "exec-pipe", false, "pipe the output to the post-run exec command")
+	command.Flags().StringVar(&opts.LDelim, "left-delim", "{{", "override the default left-`delimiter` [$GOMPLATE_LEFT_DELIM]")
+	command.Flags().StringVar(&opts.RDelim, "right-delim", "}}", "override the default right-`delimiter` [$GOMPLATE_RIGHT_DELIM]")
 
-	ldDefault := env.Getenv("GOMPLATE_LEFT_DELIM", "{{")
-	rdDefault := env.Getenv("GOMPLATE_RIGHT_DELIM", "}}")
-	command.Flags().StringVar(&opts.LDelim, "left-delim", ldDefault, "override the default left-`delimiter` [$GOMPLATE_LEFT_DELIM]")
-	command.Flags().StringVar(&opts.RDelim, "right-delim", rdDefault, "override the default right-`delimiter` [$GOMPLATE_RIGHT_DELIM]")
+	command.Flags().IntVar(&opts.Parallelism, "parallelism", 1, "maximum `number` of templates to render concurrently")
+
+	command.Flags().BoolVar(&opts.Diff, "diff", false, "don't write any output files, instead print a diff of the changes that would be made")
+	command.Flags().BoolVar(&opts.Check, "check", false, "don't write any output files, instead exit with an error if any would change")
+
+	command.Flags().BoolVar(&opts.Watch, "watch", false, "keep running, and re-render templates when input files, nested templates, or file datasources change")
+
+	command.Flags().StringVar(&opts.MetricsFile, "metrics-file", "", "write render metrics to this `file`, in Prometheus text format if it ends with .prom, otherwise as JSON")
+
+	command.Flags().StringVar(&opts.MissingKey, "missing-key", "error", "how references to missing map keys are handled: error, zero, default, or strict")
+
+	command.Flags().StringArrayVar(&opts.Sandbox, "sandbox", nil, "restrict what templates can do, with a `setting`: allow=<namespaces or functions>, fs-root=<dir>, no-network, or default. Can be specified multiple times")
+
+	command.Flags().StringVar(&opts.ErrorFormat, "error-format", "text", "the `format` errors are reported in: text or json")
+
+	command.Flags().StringVar(&configFile, "config", defaultConfigFile, "config `file` (overridden by commandline flags)")
 
 	command.Flags().BoolVarP(&verbose, "verbose", "V", false, "output extra information about what gomplate is doing")
 
 	command.Flags().BoolVarP(&printVer, "version", "v", false, "print the version")
 }
 
+// initDatasourceFlags - flags for datasources and functions, shared with
+// subcommands
+func initDatasourceFlags(command *cobra.Command) {
+	command.Flags().StringArrayVarP(&opts.DataSources, "datasource", "d", nil, "`datasource` in alias=URL form. Specify multiple times to add multiple sources.")
+	command.Flags().StringArrayVarP(&opts.DataSourceHeaders, "datasource-header", "H", nil, "HTTP `header` field in 'alias=Name: value' form to be provided on HTTP-based data sources. Multiples can be set.")
+
+	command.Flags().StringArrayVarP(&opts.Contexts, "context", "c", nil, "pre-load a `datasource` into the context, in alias=URL form. Use the special alias `.` to set the root context.")
+
+	command.Flags().StringArrayVar(&opts.Plugins, "plugin", nil, "plug in an external command as a function in name=path form, with optional settings like name=path?timeout=10s. Can be specified multiple times")
+}
+
+// initInputFlags - flags for input templates, shared with subcommands
+func initInputFlags(command *cobra.Command) {
+	command.Flags().StringArrayVarP(&opts.InputFiles, "file", "f", []string{"-"}, "Template `file` to process. Omit to use standard input, or use --in or --input-dir")
+	command.Flags().StringVarP(&opts.Input, "in", "i", "", "Template `string` to process (alternative to --file and --input-dir)")
+	command.Flags().StringVar(&opts.InputDir, "input-dir", "", "`directory` (or .tar, .tar.gz, or .zip archive) which is examined recursively for templates (alternative to --file and --in)")
+
+	command.Flags().StringArrayVar(&opts.ExcludeGlob, "exclude", []string{}, "glob of files to not parse")
+	command.Flags().StringArrayVar(&includes, "include", []string{}, "glob of files to parse")
+}
+
 func main() {
 	command := newGomplateCmd()
 	initFlags(command)
+	lintCmd := newLintCmd()
+	initLintFlags(lintCmd)
+	command.AddCommand(lintCmd)
+	serveCmd := newServeCmd()
+	initServeFlags(serveCmd)
+	command.AddCommand(serveCmd)
 	if err := command.Execute(); err != nil {
-		// nolint: errcheck
-		fmt.Fprintln(os.Stderr, err)
+		if _, ok := err.(errReported); !ok {
+			// nolint: errcheck
+			fmt.Fprintln(os.Stderr, err)
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hairyhenderson/gomplate"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listenAddr   string
+	serveOptions gomplate.ServerOptions
+)
+
+// how long in-flight requests are given to finish when shutting down
+const shutdownTimeout = 10 * time.Second
+
+// newServeCmd - the serve subcommand, which renders templates sent to it over
+// HTTP
+func newServeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an HTTP API which renders templates",
+		Long: `Serve an HTTP API which renders templates POSTed to /render.
+
+Datasources, contexts, plugins, and nested templates are configured once, at
+startup, and data read from datasources is shared by all requests.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd, args)
+			if err != nil {
+				return err
+			}
+			if verbose {
+				// nolint: errcheck
+				fmt.Fprintf(os.Stderr, "config is:\n%s\n\n", cfg)
+			}
+
+			s, err := gomplate.NewServer(cfg, serveOptions)
+			if err != nil {
+				return err
+			}
+			// nolint: errcheck
+			defer s.Close()
+
+			l, err := net.Listen("tcp", listenAddr)
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			// nolint: errcheck
+			fmt.Fprintf(os.Stderr, "listening on %s\n", l.Addr())
+			return serve(l, s)
+		},
+		Args: cobra.NoArgs,
+	}
+}
+
+// serve - serve HTTP requests until interrupted or terminated, then wait for
+// in-flight requests to finish
+func serve(l net.Listener, h http.Handler) error {
+	srv := &http.Server{Handler: h}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	shutdown := make(chan error, 1)
+	go func() {
+		<-sigs
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
+	err := srv.Serve(l)
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return <-shutdown
+}
+
+func initServeFlags(command *cobra.Command) {
+	command.Flags().SortFlags = false
+
+	initDatasourceFlags(command)
+
+	command.Flags().StringArrayVarP(&opts.Templates, "template", "t", []string{}, "Additional template file(s)")
+
+	command.Flags().StringVar(&listenAddr, "listen", "127.0.0.1:8080", "the `address` to listen on")
+	command.Flags().DurationVar(&serveOptions.CacheTTL, "cache-ttl", 0, "how long to cache data read from datasources for. Omit to cache data until gomplate exits")
+	command.Flags().DurationVar(&serveOptions.Timeout, "timeout", 30*time.Second, "the maximum time to render a single request. 0 for no limit")
+	command.Flags().IntVar(&serveOptions.MaxRenders, "max-renders", 0, "the maximum number of templates to render at once. Omit for 4 per CPU")
+
+	command.Flags().StringVar(&opts.LDelim, "left-delim", "{{", "override the default left-`delimiter` [$GOMPLATE_LEFT_DELIM]")
+	command.Flags().StringVar(&opts.RDelim, "right-delim", "}}", "override the default right-`delimiter` [$GOMPLATE_RIGHT_DELIM]")
+
+	command.Flags().StringVar(&opts.MissingKey, "missing-key", "error", "how references to missing map keys are handled: error, zero, default, or strict")
+	command.Flags().StringArrayVar(&opts.Sandbox, "sandbox", nil, "restrict what templates can do, with a `setting`: allow=<namespaces or functions>, fs-root=<dir>, no-network, or default. Can be specified multiple times. The default policy is used when no settings are given")
+	command.Flags().BoolVar(&serveOptions.NoSandbox, "no-sandbox", false, "don't restrict what templates can do. Anyone who can reach the server can then read and write files, read the environment, and run plugins")
+
+	command.Flags().StringVar(&configFile, "config", defaultConfigFile, "config `file` (overridden by commandline flags)")
+
+	command.Flags().BoolVarP(&verbose, "verbose", "V", false, "output extra information about what gomplate is doing")
+}
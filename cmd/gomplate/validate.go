@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/hairyhenderson/gomplate"
 	"github.com/spf13/cobra"
 )
 
@@ -37,6 +38,15 @@ func mustTogether(cmd *cobra.Command, left, right string) error {
 	return nil
 }
 
+func validateErrorFormat(format string) error {
+	switch format {
+	case "", "text", "json":
+		return nil
+	default:
+		return fmt.Errorf("unsupported error format %q - must be text or json", format)
+	}
+}
+
 func validateOpts(cmd *cobra.Command, args []string) (err error) {
 	err = notTogether(cmd, "in", "file", "input-dir")
 	if err == nil {
@@ -55,9 +65,115 @@ func validateOpts(cmd *cobra.Command, args []string) (err error) {
 		err = mustTogether(cmd, "output-dir", "input-dir")
 	}
 
-	if err == nil {
+	// with --foreach, the output map names the output for each item, whatever
+	// the input
+	if err == nil && !cmd.Flag("foreach").Changed {
 		err = mustTogether(cmd, "output-map", "input-dir")
 	}
 
+	if err == nil {
+		err = mustTogether(cmd, "foreach", "output-map")
+	}
+
+	if err == nil {
+		err = notTogether(cmd, "watch", "diff")
+	}
+
+	if err == nil {
+		err = notTogether(cmd, "watch", "check")
+	}
+
+	for _, f := range []string{"watch", "diff", "check"} {
+		if err == nil {
+			err = notTogether(cmd, "incremental", f)
+		}
+	}
+
+	return err
+}
+
+// setting - whether the setting for the named flag is set in the config
+type setting struct {
+	flag string
+	set  bool
+}
+
+func notTogetherConfig(settings ...setting) error {
+	found := 0
+	for _, s := range settings {
+		if s.set {
+			found++
+		}
+	}
+	if found < 2 {
+		return nil
+	}
+	a := make([]string, len(settings))
+	for i, s := range settings {
+		a[i] = "--" + s.flag
+	}
+	return fmt.Errorf("only one of these flags is supported at a time: %s", strings.Join(a, ", "))
+}
+
+func mustTogetherConfig(left, right setting) error {
+	if left.set && !right.set {
+		return fmt.Errorf("--%s must be set when --%s is set", right.flag, left.flag)
+	}
+	return nil
+}
+
+// validateConfig - validate the final config, merged from the config file and
+// the flags. validateOpts only sees the flags, so this catches settings which
+// can't be used together when some are set in the config file.
+// nolint: gocyclo
+func validateConfig(cfg *gomplate.Config) error {
+	in := setting{"in", cfg.Input != ""}
+	file := setting{"file", len(cfg.InputFiles) > 0}
+	inputDir := setting{"input-dir", cfg.InputDir != ""}
+	out := setting{"out", len(cfg.OutputFiles) > 0}
+	outputDir := setting{"output-dir", cfg.OutputDir != ""}
+	outputMap := setting{"output-map", cfg.OutputMap != ""}
+	execPipe := setting{"exec-pipe", cfg.ExecPipe}
+	foreach := setting{"foreach", cfg.ForEach != ""}
+	watch := setting{"watch", cfg.Watch}
+	incremental := setting{"incremental", cfg.Incremental != ""}
+
+	err := notTogetherConfig(in, file, inputDir)
+	if err == nil {
+		err = notTogetherConfig(out, outputDir, outputMap, execPipe)
+	}
+
+	if err == nil && execPipe.set && len(cfg.PostExec) == 0 {
+		err = fmt.Errorf("--exec-pipe may only be used with a post-exec command after --")
+	}
+
+	if err == nil {
+		err = mustTogetherConfig(outputDir, inputDir)
+	}
+
+	if err == nil && !foreach.set {
+		err = mustTogetherConfig(outputMap, inputDir)
+	}
+
+	if err == nil {
+		err = mustTogetherConfig(foreach, outputMap)
+	}
+
+	for _, s := range []setting{{"diff", cfg.Diff}, {"check", cfg.Check}} {
+		if err == nil {
+			err = notTogetherConfig(watch, s)
+		}
+	}
+
+	for _, s := range []setting{watch, {"diff", cfg.Diff}, {"check", cfg.Check}} {
+		if err == nil {
+			err = notTogetherConfig(incremental, s)
+		}
+	}
+
+	if err == nil {
+		err = validateErrorFormat(cfg.ErrorFormat)
+	}
+
 	return err
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"testing"
 
+	"github.com/hairyhenderson/gomplate"
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 )
@@ -82,6 +84,72 @@ func TestValidateOpts(t *testing.T) {
 		"--output-map", "bar",
 	))
 	assert.NoError(t, err)
+
+	err = validateOpts(parseFlags("--foreach", "data"))
+	assert.Error(t, err)
+
+	err = validateOpts(parseFlags(
+		"-i", "foo",
+		"--foreach", "data",
+		"--output-map", "{{ .item }}",
+	))
+	assert.NoError(t, err)
+
+	err = validateOpts(parseFlags("--diff", "--check"))
+	assert.NoError(t, err)
+
+	err = validateOpts(parseFlags("--watch", "--diff"))
+	assert.Error(t, err)
+
+	err = validateOpts(parseFlags("--watch", "--check"))
+	assert.Error(t, err)
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.NoError(t, validateConfig(&gomplate.Config{}))
+	assert.NoError(t, validateConfig(&gomplate.Config{InputDir: "in", OutputDir: "out", Incremental: ".cache.json"}))
+	assert.NoError(t, validateConfig(&gomplate.Config{Input: "foo", ForEach: "data", OutputMap: "{{ .item }}"}))
+	assert.NoError(t, validateConfig(&gomplate.Config{ExecPipe: true, PostExec: []string{"cat"}}))
+
+	testdata := []struct {
+		cfg      *gomplate.Config
+		expected string
+	}{
+		{&gomplate.Config{Input: "foo", InputDir: "in"}, "only one of these flags is supported at a time: --in, --file, --input-dir"},
+		{&gomplate.Config{InputDir: "in", OutputDir: "out", OutputMap: "bar"}, "only one of these flags is supported at a time: --out, --output-dir, --output-map, --exec-pipe"},
+		{&gomplate.Config{ExecPipe: true}, "--exec-pipe may only be used with a post-exec command after --"},
+		{&gomplate.Config{Input: "foo", OutputDir: "out"}, "--input-dir must be set when --output-dir is set"},
+		{&gomplate.Config{OutputMap: "bar"}, "--input-dir must be set when --output-map is set"},
+		{&gomplate.Config{Input: "foo", ForEach: "data"}, "--output-map must be set when --foreach is set"},
+		{&gomplate.Config{Watch: true, Diff: true}, "only one of these flags is supported at a time: --watch, --diff"},
+		{&gomplate.Config{Watch: true, Check: true}, "only one of these flags is supported at a time: --watch, --check"},
+		{&gomplate.Config{Incremental: ".cache.json", Watch: true}, "only one of these flags is supported at a time: --incremental, --watch"},
+		{&gomplate.Config{Incremental: ".cache.json", Diff: true}, "only one of these flags is supported at a time: --incremental, --diff"},
+		{&gomplate.Config{Incremental: ".cache.json", Check: true}, "only one of these flags is supported at a time: --incremental, --check"},
+		{&gomplate.Config{ErrorFormat: "xml"}, `unsupported error format "xml" - must be text or json`},
+	}
+	for _, d := range testdata {
+		assert.EqualError(t, validateConfig(d.cfg), d.expected)
+	}
+}
+
+func TestLoadConfigValidated(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	// conflicting settings are caught even when they're not all flags
+	_ = afero.WriteFile(fs, defaultConfigFile, []byte("watch: true\n"), 0644)
+	cfg, err := loadConfig(parseFlags("--diff"))
+	assert.NoError(t, err)
+	assert.EqualError(t, validateConfig(cfg), "only one of these flags is supported at a time: --watch, --diff")
+}
+
+func TestValidateErrorFormat(t *testing.T) {
+	assert.NoError(t, validateErrorFormat(""))
+	assert.NoError(t, validateErrorFormat("text"))
+	assert.NoError(t, validateErrorFormat("json"))
+	assert.Error(t, validateErrorFormat("xml"))
 }
 
 func parseFlags(flags ...string) (cmd *cobra.Command, args []string) {
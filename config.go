@@ -1,10 +1,19 @@
 package gomplate
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
 )
 
 // Config - values necessary for rendering templates with gomplate.
@@ -30,6 +39,511 @@ type Config struct {
 	RDelim string
 
 	Templates []string
+
+	// PostExec - the command to run after all templates are rendered
+	// successfully (i.e. the args following '--' on the commandline)
+	PostExec []string
+	// ExecPipe - pipe the rendered output to the PostExec command's stdin
+	ExecPipe bool
+
+	// PostRender - commands to run on each output file once it's written, in
+	// "glob=command" form. The command's words are templates, rendered with
+	// the output's path (.path) and input name (.input). When a command fails,
+	// the output's previous contents are restored.
+	PostRender []string
+
+	// Parallelism - the maximum number of templates to render concurrently
+	Parallelism int
+
+	// Diff - don't write any outputs, instead print a diff of how each output
+	// would change
+	Diff bool
+	// Check - don't write any outputs, instead fail if any output would change
+	Check bool
+
+	// Watch - keep running, and re-render templates when their input files,
+	// nested templates, or file datasources change
+	Watch bool
+
+	// SkipUnchanged - leave output files untouched (including their
+	// modification times) when the rendered content is identical
+	SkipUnchanged bool
+
+	// DepFile - write the files each output depends on to this file, in
+	// Makefile syntax
+	DepFile string
+
+	// Incremental - keep a manifest of the hashes of each output's inputs in
+	// this file, and skip rendering outputs whose inputs are unchanged
+	Incremental string
+
+	// ErrorFormat - the format errors are reported in: "text" (the default)
+	// or "json" (see WriteErrorReports)
+	ErrorFormat string
+
+	// MetricsFile - write metrics to this file once rendering is done, in
+	// Prometheus text format when it ends with ".prom", otherwise as JSON
+	MetricsFile string
+
+	// ForEach - render each input template once for each item selected from a
+	// datasource, in "alias" or "alias:jsonpath" form. Output paths are named
+	// with OutputMap, which is required.
+	ForEach string
+
+	// MissingKey - how references to missing map keys are handled: "error"
+	// (the default), "zero", "default", or "strict" (see
+	// RenderOptions.MissingKey)
+	MissingKey string
+
+	// Sandbox - settings for the policy restricting what templates can do, in
+	// "allow=<namespaces>", "fs-root=<dir>", "no-network", or "default" form.
+	// Templates aren't restricted when empty (see Sandbox).
+	Sandbox []string
+
+	// origins records where each value was set (e.g. a config file name, or
+	// "flags"), keyed by the same names used in String()
+	origins map[string]string
+	// set records the boolean settings which were set explicitly, even to
+	// false (see MarkSet), keyed by the same names used in String()
+	set map[string]bool
+}
+
+// configFile - the structure of a YAML config file (such as .gomplate.yaml),
+// which maps onto a Config. Booleans are pointers, so that values set to false
+// can be told apart from unset ones.
+type configFile struct {
+	Input       string   `yaml:"in"`
+	InputFiles  []string `yaml:"inputFiles"`
+	InputDir    string   `yaml:"inputDir"`
+	ExcludeGlob []string `yaml:"excludes"`
+	OutputFiles []string `yaml:"outputFiles"`
+	OutputDir   string   `yaml:"outputDir"`
+	OutputMap   string   `yaml:"outputMap"`
+	OutMode     string   `yaml:"chmod"`
+
+	DataSources map[string]dataSourceConfig `yaml:"datasources"`
+	Contexts    map[string]dataSourceConfig `yaml:"context"`
+
+	Plugins map[string]pluginConfig `yaml:"plugins"`
+
+	LDelim string `yaml:"leftDelim"`
+	RDelim string `yaml:"rightDelim"`
+
+	Templates []string `yaml:"templates"`
+
+	PostExec   []string `yaml:"postExec"`
+	ExecPipe   *bool    `yaml:"execPipe"`
+	PostRender []string `yaml:"postRender"`
+
+	Parallelism int   `yaml:"parallelism"`
+	Diff        *bool `yaml:"diff"`
+	Check       *bool `yaml:"check"`
+	Watch       *bool `yaml:"watch"`
+
+	SkipUnchanged *bool  `yaml:"skipUnchanged"`
+	DepFile       string `yaml:"depfile"`
+	Incremental   string `yaml:"incremental"`
+
+	ErrorFormat string `yaml:"errorFormat"`
+	MetricsFile string `yaml:"metricsFile"`
+
+	ForEach string `yaml:"foreach"`
+
+	MissingKey string `yaml:"missingKey"`
+
+	Sandbox *sandboxConfig `yaml:"sandbox"`
+}
+
+// sandboxConfig - the sandbox policy, as defined in a config file
+type sandboxConfig struct {
+	Allow     []string `yaml:"allow"`
+	FSRoot    string   `yaml:"fs-root"`
+	NoNetwork bool     `yaml:"no-network"`
+}
+
+// args - convert the policy to the settings accepted by --sandbox
+func (s *sandboxConfig) args() []string {
+	args := []string{"default"}
+	if len(s.Allow) > 0 {
+		args = append(args, "allow="+strings.Join(s.Allow, ","))
+	}
+	if s.FSRoot != "" {
+		args = append(args, "fs-root="+s.FSRoot)
+	}
+	if s.NoNetwork {
+		args = append(args, "no-network")
+	}
+	return args
+}
+
+// pluginConfig - a plugin, as defined in a config file: either just the path
+// to the command, or a mapping with the command and its options
+type pluginConfig struct {
+	Cmd      string            `yaml:"cmd"`
+	Protocol string            `yaml:"protocol"`
+	Timeout  string            `yaml:"timeout"`
+	Env      map[string]string `yaml:"env"`
+	Dir      string            `yaml:"dir"`
+	Pipe     bool              `yaml:"pipe"`
+	Output   string            `yaml:"output"`
+}
+
+// UnmarshalYAML - accept either form of plugin definition
+func (p *pluginConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&p.Cmd)
+	}
+	type plain pluginConfig
+	return value.Decode((*plain)(p))
+}
+
+// pluginArgs - convert plugin definitions from a config file to the name=path
+// form accepted by --plugin
+func pluginArgs(plugins map[string]pluginConfig) []string {
+	names := make([]string, 0, len(plugins))
+	for k := range plugins {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	args := make([]string, 0, len(names))
+	for _, name := range names {
+		args = append(args, name+"="+plugins[name].arg())
+	}
+	return args
+}
+
+// arg - the plugin's path, with its options in a query string (the form
+// accepted by --plugin)
+func (p pluginConfig) arg() string {
+	opts := url.Values{}
+	set := func(k, v string) {
+		if v != "" {
+			opts.Set(k, v)
+		}
+	}
+	set("protocol", p.Protocol)
+	set("timeout", p.Timeout)
+	set("dir", p.Dir)
+	set("output", p.Output)
+	if p.Pipe {
+		set("pipe", "true")
+	}
+	for _, k := range sortedKeys(p.Env) {
+		opts.Add("env", k+"="+p.Env[k])
+	}
+	if len(opts) == 0 {
+		return p.Cmd
+	}
+	return p.Cmd + "?" + opts.Encode()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dataSourceConfig - a datasource or context, as defined in a config file
+type dataSourceConfig struct {
+	URL    string              `yaml:"url"`
+	Header map[string][]string `yaml:"header"`
+}
+
+// ParseConfigFile - parse a YAML config file into a Config. The name is used
+// to record where the config values came from.
+func ParseConfigFile(in io.Reader, name string) (*Config, error) {
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read config file %s", name)
+	}
+	f := &configFile{}
+	// unknown keys (like typos) are an error, rather than silently ignored
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err = dec.Decode(f)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "failed to parse config file %s", name)
+	}
+
+	c := &Config{
+		Input:       f.Input,
+		InputFiles:  f.InputFiles,
+		InputDir:    f.InputDir,
+		ExcludeGlob: f.ExcludeGlob,
+		OutputFiles: f.OutputFiles,
+		OutputDir:   f.OutputDir,
+		OutputMap:   f.OutputMap,
+		OutMode:     f.OutMode,
+		LDelim:      f.LDelim,
+		RDelim:      f.RDelim,
+		Templates:   f.Templates,
+		PostExec:    f.PostExec,
+		PostRender:  f.PostRender,
+		Parallelism: f.Parallelism,
+
+		DepFile:     f.DepFile,
+		Incremental: f.Incremental,
+
+		ErrorFormat: f.ErrorFormat,
+		MetricsFile: f.MetricsFile,
+
+		ForEach: f.ForEach,
+
+		MissingKey: f.MissingKey,
+	}
+	c.DataSources, c.DataSourceHeaders = dataSourceArgs(f.DataSources)
+	var ctxHeaders []string
+	c.Contexts, ctxHeaders = dataSourceArgs(f.Contexts)
+	c.DataSourceHeaders = append(c.DataSourceHeaders, ctxHeaders...)
+	c.Plugins = pluginArgs(f.Plugins)
+	if f.Sandbox != nil {
+		c.Sandbox = f.Sandbox.args()
+	}
+	for _, b := range []struct {
+		key string
+		dst *bool
+		v   *bool
+	}{
+		{"exec_pipe", &c.ExecPipe, f.ExecPipe},
+		{"diff", &c.Diff, f.Diff},
+		{"check", &c.Check, f.Check},
+		{"watch", &c.Watch, f.Watch},
+		{"skip_unchanged", &c.SkipUnchanged, f.SkipUnchanged},
+	} {
+		if b.v != nil {
+			*b.dst = *b.v
+			c.MarkSet(b.key)
+		}
+	}
+
+	return (&Config{}).MergeFrom(c, name), nil
+}
+
+// dataSourceArgs - convert datasource definitions from a config file to the
+// alias=URL and 'alias=Name: value' forms accepted by the commandline
+func dataSourceArgs(sources map[string]dataSourceConfig) (args, headers []string) {
+	aliases := make([]string, 0, len(sources))
+	for k := range sources {
+		aliases = append(aliases, k)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		ds := sources[alias]
+		args = append(args, alias+"="+ds.URL)
+		names := make([]string, 0, len(ds.Header))
+		for k := range ds.Header {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, v := range ds.Header[name] {
+				headers = append(headers, fmt.Sprintf("%s=%s: %s", alias, name, v))
+			}
+		}
+	}
+	return args, headers
+}
+
+// MarkSet - record that the named boolean settings ("exec_pipe", "diff",
+// "check", "watch", or "skip_unchanged") were set explicitly, so that they
+// override other values when merged with MergeFrom, even when false
+func (o *Config) MarkSet(keys ...string) *Config {
+	if o.set == nil {
+		o.set = map[string]bool{}
+	}
+	for _, k := range keys {
+		o.set[k] = true
+	}
+	return o
+}
+
+// setBool - whether the named boolean setting is true, or was set explicitly
+func (o *Config) setBool(key string, v bool) bool {
+	return v || o.set[key]
+}
+
+// MergeFrom - override values in this Config with any values set in the other
+// Config, and record the given origin for each of the overridden values.
+// Boolean values only override when true, or when set explicitly (see
+// MarkSet).
+//
+// Inputs and outputs are overridden as a group, since the different input
+// (and output) options are mutually exclusive. Datasources, contexts, plugins,
+// and nested templates are merged by alias, with the other Config's
+// definitions winning.
+// nolint: gocyclo
+func (o *Config) MergeFrom(other *Config, origin string) *Config {
+	if o.origins == nil {
+		o.origins = map[string]string{}
+	}
+
+	if other.Input != "" || other.InputFiles != nil || other.InputDir != "" {
+		o.Input = other.Input
+		o.InputFiles = other.InputFiles
+		o.InputDir = other.InputDir
+		o.origins["input"] = origin
+	}
+	if len(other.ExcludeGlob) > 0 {
+		o.ExcludeGlob = other.ExcludeGlob
+		o.origins["exclude"] = origin
+	}
+	if other.OutputFiles != nil || other.OutputDir != "" || other.OutputMap != "" || other.ExecPipe {
+		o.OutputFiles = other.OutputFiles
+		o.OutputDir = other.OutputDir
+		o.OutputMap = other.OutputMap
+		o.ExecPipe = other.ExecPipe
+		o.origins["output"] = origin
+	} else if other.set["exec_pipe"] {
+		// turning off --exec-pipe leaves the other outputs alone
+		o.ExecPipe = false
+	}
+	if other.set["exec_pipe"] {
+		o.MarkSet("exec_pipe")
+	}
+	if other.Out != nil {
+		o.Out = other.Out
+	}
+	if other.OutMode != "" {
+		o.OutMode = other.OutMode
+		o.origins["chmod"] = origin
+	}
+	if len(other.DataSources) > 0 {
+		o.DataSources = mergeAliased(o.DataSources, other.DataSources)
+		o.origins["datasources"] = origin
+	}
+	if len(other.DataSourceHeaders) > 0 {
+		o.DataSourceHeaders = mergeHeaders(o.DataSourceHeaders, other.DataSourceHeaders)
+		o.origins["datasourceheaders"] = origin
+	}
+	if len(other.Contexts) > 0 {
+		o.Contexts = mergeAliased(o.Contexts, other.Contexts)
+		o.origins["contexts"] = origin
+	}
+	if len(other.Plugins) > 0 {
+		o.Plugins = mergeAliased(o.Plugins, other.Plugins)
+		o.origins["plugins"] = origin
+	}
+	if other.LDelim != "" {
+		o.LDelim = other.LDelim
+		o.origins["left_delim"] = origin
+	}
+	if other.RDelim != "" {
+		o.RDelim = other.RDelim
+		o.origins["right_delim"] = origin
+	}
+	if len(other.Templates) > 0 {
+		o.Templates = mergeAliased(o.Templates, other.Templates)
+		o.origins["templates"] = origin
+	}
+	if len(other.PostExec) > 0 {
+		o.PostExec = other.PostExec
+		o.origins["post_exec"] = origin
+	}
+	if len(other.PostRender) > 0 {
+		o.PostRender = other.PostRender
+		o.origins["post_render"] = origin
+	}
+	if other.Parallelism > 0 {
+		o.Parallelism = other.Parallelism
+		o.origins["parallelism"] = origin
+	}
+	if other.setBool("diff", other.Diff) {
+		o.Diff = other.Diff
+		o.origins["diff"] = origin
+		o.MarkSet("diff")
+	}
+	if other.setBool("check", other.Check) {
+		o.Check = other.Check
+		o.origins["check"] = origin
+		o.MarkSet("check")
+	}
+	if other.setBool("watch", other.Watch) {
+		o.Watch = other.Watch
+		o.origins["watch"] = origin
+		o.MarkSet("watch")
+	}
+	if other.setBool("skip_unchanged", other.SkipUnchanged) {
+		o.SkipUnchanged = other.SkipUnchanged
+		o.origins["skip_unchanged"] = origin
+		o.MarkSet("skip_unchanged")
+	}
+	if other.DepFile != "" {
+		o.DepFile = other.DepFile
+		o.origins["depfile"] = origin
+	}
+	if other.Incremental != "" {
+		o.Incremental = other.Incremental
+		o.origins["incremental"] = origin
+	}
+	if other.ErrorFormat != "" {
+		o.ErrorFormat = other.ErrorFormat
+		o.origins["error_format"] = origin
+	}
+	if other.MetricsFile != "" {
+		o.MetricsFile = other.MetricsFile
+		o.origins["metrics_file"] = origin
+	}
+	if other.ForEach != "" {
+		o.ForEach = other.ForEach
+		o.origins["foreach"] = origin
+	}
+	if other.MissingKey != "" {
+		o.MissingKey = other.MissingKey
+		o.origins["missing_key"] = origin
+	}
+	if len(other.Sandbox) > 0 {
+		o.Sandbox = other.Sandbox
+		o.origins["sandbox"] = origin
+	}
+	return o
+}
+
+// mergeAliased - merge two lists of alias=value arguments, with the values in
+// the second list overriding any in the first with the same alias
+func mergeAliased(base, override []string) []string {
+	out := []string{}
+	aliasOf := func(arg string) string {
+		return strings.SplitN(arg, "=", 2)[0]
+	}
+	overridden := map[string]bool{}
+	for _, v := range override {
+		overridden[aliasOf(v)] = true
+	}
+	for _, v := range base {
+		if !overridden[aliasOf(v)] {
+			out = append(out, v)
+		}
+	}
+	return append(out, override...)
+}
+
+// mergeHeaders - merge two lists of alias=Name: value headers, with the
+// headers in the second list overriding any in the first with the same alias
+// and (case-insensitive) name. Other headers are kept, so an alias can still
+// have several headers, or several values for one header from one list.
+func mergeHeaders(base, override []string) []string {
+	keyOf := func(arg string) string {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) < 2 {
+			return arg
+		}
+		name := strings.SplitN(parts[1], ":", 2)[0]
+		return parts[0] + "=" + http.CanonicalHeaderKey(strings.TrimSpace(name))
+	}
+	overridden := map[string]bool{}
+	for _, v := range override {
+		overridden[keyOf(v)] = true
+	}
+	out := []string{}
+	for _, v := range base {
+		if !overridden[keyOf(v)] {
+			out = append(out, v)
+		}
+	}
+	return append(out, override...)
 }
 
 // defaults - sets any unset fields to their default value (if applicable)
@@ -79,9 +593,10 @@ func (o *Config) String() string {
 	default:
 		c += strings.Join(o.InputFiles, ", ")
 	}
+	c += o.origin("input")
 
 	if len(o.ExcludeGlob) > 0 {
-		c += "\nexclude: " + strings.Join(o.ExcludeGlob, ", ")
+		c += "\nexclude: " + strings.Join(o.ExcludeGlob, ", ") + o.origin("exclude")
 	}
 
 	c += "\noutput: "
@@ -90,37 +605,103 @@ func (o *Config) String() string {
 		c += o.OutputDir
 	case o.OutputMap != "":
 		c += o.OutputMap
+	case o.ExecPipe:
+		c += "<exec-pipe>"
 	default:
 		c += strings.Join(o.OutputFiles, ", ")
 	}
+	c += o.origin("output")
 
 	if o.OutMode != "" {
-		c += "\nchmod: " + o.OutMode
+		c += "\nchmod: " + o.OutMode + o.origin("chmod")
 	}
 
 	if len(o.DataSources) > 0 {
-		c += "\ndatasources: " + strings.Join(o.DataSources, ", ")
+		c += "\ndatasources: " + strings.Join(o.DataSources, ", ") + o.origin("datasources")
 	}
 	if len(o.DataSourceHeaders) > 0 {
-		c += "\ndatasourceheaders: " + strings.Join(o.DataSourceHeaders, ", ")
+		c += "\ndatasourceheaders: " + strings.Join(o.DataSourceHeaders, ", ") + o.origin("datasourceheaders")
 	}
 	if len(o.Contexts) > 0 {
-		c += "\ncontexts: " + strings.Join(o.Contexts, ", ")
+		c += "\ncontexts: " + strings.Join(o.Contexts, ", ") + o.origin("contexts")
 	}
 
 	if len(o.Plugins) > 0 {
-		c += "\nplugins: " + strings.Join(o.Plugins, ", ")
+		c += "\nplugins: " + strings.Join(o.Plugins, ", ") + o.origin("plugins")
 	}
 
 	if o.LDelim != "{{" {
-		c += "\nleft_delim: " + o.LDelim
+		c += "\nleft_delim: " + o.LDelim + o.origin("left_delim")
 	}
 	if o.RDelim != "}}" {
-		c += "\nright_delim: " + o.RDelim
+		c += "\nright_delim: " + o.RDelim + o.origin("right_delim")
 	}
 
 	if len(o.Templates) > 0 {
-		c += "\ntemplates: " + strings.Join(o.Templates, ", ")
+		c += "\ntemplates: " + strings.Join(o.Templates, ", ") + o.origin("templates")
+	}
+
+	if len(o.PostExec) > 0 {
+		c += "\npost_exec: " + strings.Join(o.PostExec, " ") + o.origin("post_exec")
+	}
+
+	if len(o.PostRender) > 0 {
+		c += "\npost_render: " + strings.Join(o.PostRender, ", ") + o.origin("post_render")
+	}
+
+	if o.Parallelism > 1 {
+		c += "\nparallelism: " + strconv.Itoa(o.Parallelism) + o.origin("parallelism")
+	}
+
+	if o.Diff {
+		c += "\ndiff: true" + o.origin("diff")
+	}
+	if o.Check {
+		c += "\ncheck: true" + o.origin("check")
+	}
+
+	if o.Watch {
+		c += "\nwatch: true" + o.origin("watch")
+	}
+
+	if o.SkipUnchanged {
+		c += "\nskip_unchanged: true" + o.origin("skip_unchanged")
+	}
+
+	if o.DepFile != "" {
+		c += "\ndepfile: " + o.DepFile + o.origin("depfile")
+	}
+
+	if o.Incremental != "" {
+		c += "\nincremental: " + o.Incremental + o.origin("incremental")
+	}
+
+	if o.ErrorFormat != "" {
+		c += "\nerror_format: " + o.ErrorFormat + o.origin("error_format")
+	}
+
+	if o.MetricsFile != "" {
+		c += "\nmetrics_file: " + o.MetricsFile + o.origin("metrics_file")
+	}
+
+	if o.ForEach != "" {
+		c += "\nforeach: " + o.ForEach + o.origin("foreach")
+	}
+
+	if o.MissingKey != "" {
+		c += "\nmissing_key: " + o.MissingKey + o.origin("missing_key")
+	}
+
+	if len(o.Sandbox) > 0 {
+		c += "\nsandbox: " + strings.Join(o.Sandbox, ", ") + o.origin("sandbox")
 	}
 	return c
 }
+
+// origin - a suffix describing where the named value was set, if known
+func (o *Config) origin(key string) string {
+	if src, ok := o.origins[key]; ok && src != "" {
+		return " (from " + src + ")"
+	}
+	return ""
+}
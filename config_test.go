@@ -2,6 +2,7 @@ package gomplate
 
 import (
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -71,3 +72,154 @@ func TestGetMode(t *testing.T) {
 	_, _, err = c.getMode()
 	assert.Error(t, err)
 }
+
+func TestParseConfigFile(t *testing.T) {
+	in := `in: hello world
+excludes: ['*.bak']
+outputFiles: [out.txt]
+chmod: 644
+skipUnchanged: true
+depfile: out.d
+incremental: .cache.json
+errorFormat: json
+metricsFile: metrics.prom
+foreach: data:.tenants
+missingKey: strict
+sandbox:
+  allow: [strings, coll]
+  fs-root: ./conf
+  no-network: true
+datasources:
+  data:
+    url: file:///data.json
+    header:
+      Authorization: [Basic foo]
+context:
+  .:
+    url: env:///FOO?type=application/json
+plugins:
+  echo: /bin/echo
+  lookup:
+    cmd: /usr/local/bin/lookup
+    protocol: jsonrpc
+  filter:
+    cmd: ./filter.sh
+    timeout: 10s
+    env:
+      MODE: fast
+      DEBUG: "1"
+    dir: /tmp
+    pipe: true
+    output: json
+leftDelim: '[['
+rightDelim: ']]'
+templates: [t=foo/]
+postExec: [cat, out.txt]
+postRender: ['*.tf=terraform fmt {{ .path }}']
+`
+	c, err := ParseConfigFile(strings.NewReader(in), ".gomplate.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", c.Input)
+	assert.Equal(t, []string{"*.bak"}, c.ExcludeGlob)
+	assert.Equal(t, []string{"out.txt"}, c.OutputFiles)
+	assert.Equal(t, "644", c.OutMode)
+	assert.True(t, c.SkipUnchanged)
+	assert.Equal(t, "out.d", c.DepFile)
+	assert.Equal(t, ".cache.json", c.Incremental)
+	assert.Equal(t, "json", c.ErrorFormat)
+	assert.Equal(t, "metrics.prom", c.MetricsFile)
+	assert.Equal(t, "data:.tenants", c.ForEach)
+	assert.Equal(t, "strict", c.MissingKey)
+	assert.Equal(t, []string{"default", "allow=strings,coll", "fs-root=./conf", "no-network"}, c.Sandbox)
+	assert.Equal(t, []string{"data=file:///data.json"}, c.DataSources)
+	assert.Equal(t, []string{"data=Authorization: Basic foo"}, c.DataSourceHeaders)
+	assert.Equal(t, []string{".=env:///FOO?type=application/json"}, c.Contexts)
+	assert.Equal(t, []string{
+		"echo=/bin/echo",
+		"filter=./filter.sh?dir=%2Ftmp&env=DEBUG%3D1&env=MODE%3Dfast&output=json&pipe=true&timeout=10s",
+		"lookup=/usr/local/bin/lookup?protocol=jsonrpc",
+	}, c.Plugins)
+	assert.Equal(t, "[[", c.LDelim)
+	assert.Equal(t, "]]", c.RDelim)
+	assert.Equal(t, []string{"t=foo/"}, c.Templates)
+	assert.Equal(t, []string{"cat", "out.txt"}, c.PostExec)
+	assert.Equal(t, []string{"*.tf=terraform fmt {{ .path }}"}, c.PostRender)
+
+	_, err = ParseConfigFile(strings.NewReader("in: [ bogus"), "bad.yaml")
+	assert.Error(t, err)
+}
+
+func TestParseConfigFileStrict(t *testing.T) {
+	_, err := ParseConfigFile(strings.NewReader("inputDir: in/\noutptuDir: out/\n"), ".gomplate.yaml")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "field outptuDir not found")
+
+	c, err := ParseConfigFile(strings.NewReader(""), ".gomplate.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "", c.InputDir)
+}
+
+func TestMergeFrom(t *testing.T) {
+	c := &Config{
+		InputDir:    "in/",
+		OutputDir:   "out/",
+		DataSources: []string{"foo=foo.json", "bar=bar.json"},
+		LDelim:      "[[",
+	}
+	other := &Config{
+		Input:       "hello",
+		OutputFiles: []string{"-"},
+		DataSources: []string{"bar=baz.json"},
+	}
+	c = c.MergeFrom(other, "flags")
+	assert.Equal(t, "hello", c.Input)
+	assert.Equal(t, "", c.InputDir)
+	assert.Equal(t, []string{"-"}, c.OutputFiles)
+	assert.Equal(t, "", c.OutputDir)
+	assert.Equal(t, []string{"foo=foo.json", "bar=baz.json"}, c.DataSources)
+	assert.Equal(t, "[[", c.LDelim)
+
+	// headers are overridden by alias and (case-insensitive) name
+	c = (&Config{DataSourceHeaders: []string{
+		"foo=Authorization: Basic a", "foo=Accept: text/plain", "bar=Authorization: Basic b",
+	}}).MergeFrom(&Config{DataSourceHeaders: []string{
+		"foo=authorization: Bearer c", "foo=X-Extra: 1", "foo=X-Extra: 2",
+	}}, "flags")
+	assert.Equal(t, []string{
+		"foo=Accept: text/plain", "bar=Authorization: Basic b",
+		"foo=authorization: Bearer c", "foo=X-Extra: 1", "foo=X-Extra: 2",
+	}, c.DataSourceHeaders)
+
+	c = (&Config{}).MergeFrom(&Config{InputDir: "in/", OutputDir: "out/"}, "foo.yaml")
+	c = c.MergeFrom(&Config{OutMode: "600", SkipUnchanged: true}, "flags")
+	expected := `input: in/ (from foo.yaml)
+output: out/ (from foo.yaml)
+chmod: 600 (from flags)
+skip_unchanged: true (from flags)`
+	assert.Equal(t, expected, c.String())
+
+	// booleans set explicitly to false override true values
+	c = (&Config{}).MergeFrom(&Config{Diff: true, Watch: true, SkipUnchanged: true, ExecPipe: true}, "foo.yaml")
+	c = c.MergeFrom(&Config{Diff: false, SkipUnchanged: false, ExecPipe: false}, "flags")
+	assert.True(t, c.Diff)
+	assert.True(t, c.SkipUnchanged)
+	assert.True(t, c.ExecPipe)
+	c = c.MergeFrom((&Config{}).MarkSet("diff", "skip_unchanged", "exec_pipe"), "flags")
+	assert.False(t, c.Diff)
+	assert.True(t, c.Watch)
+	assert.False(t, c.SkipUnchanged)
+	assert.False(t, c.ExecPipe)
+}
+
+func TestParseConfigFileFalseBools(t *testing.T) {
+	c, err := ParseConfigFile(strings.NewReader("watch: false\nskipUnchanged: false\n"), ".gomplate.yaml")
+	assert.NoError(t, err)
+	assert.False(t, c.Watch)
+
+	// values set to false in the file override values set elsewhere
+	base := &Config{Watch: true, SkipUnchanged: true, Diff: true}
+	base = base.MergeFrom(c, ".gomplate.yaml")
+	assert.False(t, base.Watch)
+	assert.False(t, base.SkipUnchanged)
+	assert.True(t, base.Diff)
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/hairyhenderson/gomplate/data"
+	"github.com/pkg/errors"
 )
 
 // context for templates
@@ -20,6 +21,47 @@ func (c *tmplctx) Env() map[string]string {
 	return env
 }
 
+// restrictedCtx - a template context for templates whose sandbox policy
+// doesn't allow reading the environment. Its Env fails, however the context
+// is referred to.
+type restrictedCtx map[string]interface{}
+
+// Env - fails, since the environment can't be read
+func (c *restrictedCtx) Env() (map[string]string, error) {
+	return nil, errors.New(`.Env is not allowed by the sandbox policy - add "env" to the allow list to use it`)
+}
+
+// ctxValues - the values in a context made by createTmplContext, or false
+// for a root context (from a "." datasource)
+func ctxValues(tctx interface{}) (map[string]interface{}, bool) {
+	switch c := tctx.(type) {
+	case *tmplctx:
+		return *c, true
+	case *restrictedCtx:
+		return *c, true
+	}
+	return nil, false
+}
+
+// ctxLike - a copy of the context (which must not be a root context), with
+// the given values added. The copy is restricted like the original.
+func ctxLike(tctx interface{}, values map[string]interface{}) interface{} {
+	orig, _ := ctxValues(tctx)
+	m := make(map[string]interface{}, len(orig)+len(values))
+	for k, v := range orig {
+		m[k] = v
+	}
+	for k, v := range values {
+		m[k] = v
+	}
+	if _, ok := tctx.(*restrictedCtx); ok {
+		c := restrictedCtx(m)
+		return &c
+	}
+	c := tmplctx(m)
+	return &c
+}
+
 func createTmplContext(contexts []string, d *data.Data) (interface{}, error) {
 	var err error
 	tctx := &tmplctx{}
@@ -1,6 +1,9 @@
 package data
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,11 +14,14 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/afero"
 
 	"github.com/pkg/errors"
 
+	"github.com/hairyhenderson/gomplate/file"
 	"github.com/hairyhenderson/gomplate/libkv"
 	"github.com/hairyhenderson/gomplate/vault"
 )
@@ -65,6 +71,8 @@ func (d *Data) registerReaders() {
 
 // lookupReader - return the reader function for the given scheme
 func (d *Data) lookupReader(scheme string) (func(*Source, ...string) ([]byte, error), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if d.sourceReaders == nil {
 		d.registerReaders()
 	}
@@ -75,20 +83,76 @@ func (d *Data) lookupReader(scheme string) (func(*Source, ...string) ([]byte, er
 	return r, nil
 }
 
-// Data -
+// Data - all methods are safe for concurrent use, but Sources must not be
+// modified directly while templates are rendering
 type Data struct {
 	Sources map[string]*Source
 
+	// Fs - the filesystem file: datasources are read from. The OS filesystem
+	// is used when nil.
+	Fs afero.Fs
+
+	// OnFileRead - if set, called with the path of each file (or directory)
+	// successfully read by file: datasources, including reads served from the
+	// cache
+	OnFileRead func(path string)
+
+	// OnSourceRead - if set, called after each read from a datasource,
+	// including reads served from the cache, and failed reads
+	OnSourceRead func(SourceRead)
+
+	// CacheTTL - how long data read from datasources is cached for. Data is
+	// cached indefinitely (until cleared with ClearCache) when zero.
+	CacheTTL time.Duration
+
+	// FSRoot - if set, datasources which read local files (file: and
+	// boltdb:) may only read within this directory
+	FSRoot string
+
+	// NoNetwork - if set, datasources which read over the network (like
+	// http: and vault:) can't be read
+	NoNetwork bool
+
+	// NoDefine - if set, datasources can't be defined once templates are
+	// rendering, with DefineDatasource or by referring to them by URL
+	NoDefine bool
+
 	sourceReaders map[string]func(*Source, ...string) ([]byte, error)
-	cache         map[string][]byte
+	cache         map[string]cacheEntry
+	// reads in progress, by cache key
+	flights map[string]*flight
+
+	// guards Sources, sourceReaders, cache, and flights
+	mu sync.RWMutex
 
 	// headers from the --datasource-header/-H option that don't reference datasources from the commandline
 	extraHeaders map[string]http.Header
 }
 
+// SourceRead - a read from a datasource, as reported to the OnSourceRead hook
+type SourceRead struct {
+	Alias string
+	// URL - the datasource's URL
+	URL string
+	// Args - the arguments the datasource was read with (like a sub-path)
+	Args []string
+	// Hash - the hex-encoded SHA-256 hash of the data read (empty for failed
+	// reads)
+	Hash string
+	// Duration - how long the read took (zero for cached reads)
+	Duration time.Duration
+	// Bytes - the number of bytes read
+	Bytes int
+	// Cached - whether the read was served from the cache
+	Cached bool
+	Err    error
+}
+
 // Cleanup - clean up datasources before shutting the process down - things
 // like Logging out happen here
 func (d *Data) Cleanup() {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	for _, s := range d.Sources {
 		s.cleanup()
 	}
@@ -132,9 +196,80 @@ type Source struct {
 	asmpg             awssmpGetter            // used for aws+smp:, nil otherwise
 	awsSecretsManager awsSecretsManagerGetter // used for aws+sm, nil otherwise
 	header            http.Header             // used for http[s]: URLs, nil otherwise
+
+	// the read a copy is made for (see forRead) - nil otherwise
+	call *readCall
+
+	// guards the clients and media type, which readers lazily initialize and
+	// set. Reads are made on a copy (see forRead), so it's never held while
+	// reading.
+	mu sync.Mutex
+}
+
+// forRead - a copy of the source for a single read, so that the reader can
+// initialize clients and set the media type without holding the lock
+func (s *Source) forRead(c *readCall) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &Source{
+		call:              c,
+		Alias:             s.Alias,
+		URL:               s.URL,
+		mediaType:         s.mediaType,
+		fs:                s.fs,
+		hc:                s.hc,
+		vc:                s.vc,
+		kv:                s.kv,
+		asmpg:             s.asmpg,
+		awsSecretsManager: s.awsSecretsManager,
+		header:            s.header,
+	}
+}
+
+// keep - keep the clients initialized, and the media type set, by a read of
+// r (a copy from forRead). When a concurrent read already initialized a
+// client, r's is logged out instead.
+func (s *Source) keep(r *Source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mediaType = r.mediaType
+	if s.fs == nil {
+		s.fs = r.fs
+	}
+	if s.hc == nil {
+		s.hc = r.hc
+	}
+	if s.vc == nil {
+		s.vc = r.vc
+	} else if r.vc != nil && r.vc != s.vc {
+		r.vc.Logout()
+	}
+	if s.kv == nil {
+		s.kv = r.kv
+	} else if r.kv != nil && r.kv != s.kv {
+		r.kv.Logout()
+	}
+	if s.asmpg == nil {
+		s.asmpg = r.asmpg
+	}
+	if s.awsSecretsManager == nil {
+		s.awsSecretsManager = r.awsSecretsManager
+	}
 }
 
+// context - the context of the read the source was copied for, which readers
+// stop reading once it's done
+func (s *Source) context() context.Context {
+	if s.call == nil {
+		return context.Background()
+	}
+	return s.call.ctx
+}
+
+// inherit - the parent must be a copy from forRead, or be locked by the caller
 func (s *Source) inherit(parent *Source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.fs = parent.fs
 	s.hc = parent.hc
 	s.vc = parent.vc
@@ -163,7 +298,9 @@ func (s *Source) cleanup() {
 func (s *Source) mimeType() (mimeType string, err error) {
 	mediatype := s.URL.Query().Get("type")
 	if mediatype == "" {
+		s.mu.Lock()
 		mediatype = s.mediaType
+		s.mu.Unlock()
 	}
 	// make it so + doesn't need to be escaped
 	mediatype = strings.ReplaceAll(mediatype, " ", "+")
@@ -278,13 +415,18 @@ func (d *Data) DefineDatasource(alias, value string) (string, error) {
 	if alias == "" {
 		return "", errors.New("datasource alias must be provided")
 	}
-	if d.DatasourceExists(alias) {
-		return "", nil
+	if d.NoDefine && !d.DatasourceExists(alias) {
+		return "", errors.Errorf("can't define datasource '%s': defining datasources is not allowed", alias)
 	}
 	srcURL, err := parseSourceURL(value)
 	if err != nil {
 		return "", err
 	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.Sources[alias]; ok {
+		return "", nil
+	}
 	s := &Source{
 		Alias:  alias,
 		URL:    srcURL,
@@ -299,11 +441,15 @@ func (d *Data) DefineDatasource(alias, value string) (string, error) {
 
 // DatasourceExists -
 func (d *Data) DatasourceExists(alias string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	_, ok := d.Sources[alias]
 	return ok
 }
 
 func (d *Data) lookupSource(alias string) (*Source, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	source, ok := d.Sources[alias]
 	if !ok {
 		srcURL, err := url.Parse(alias)
@@ -323,12 +469,15 @@ func (d *Data) lookupSource(alias string) (*Source, error) {
 	return source, nil
 }
 
-func (d *Data) readDataSource(alias string, args ...string) (data, mimeType string, err error) {
+func (d *Data) readDataSource(c *readCall, alias string, args ...string) (data, mimeType string, err error) {
+	if d.NoDefine && !d.DatasourceExists(alias) {
+		return "", "", errors.Errorf("Undefined datasource '%s' (datasources can't be referred to by URL when defining datasources is not allowed)", alias)
+	}
 	source, err := d.lookupSource(alias)
 	if err != nil {
 		return "", "", err
 	}
-	b, err := d.readSource(source, args...)
+	b, err := d.readSource(c, source, args...)
 	if err != nil {
 		return "", "", errors.Wrapf(err, "Couldn't read datasource '%s'", alias)
 	}
@@ -342,13 +491,21 @@ func (d *Data) readDataSource(alias string, args ...string) (data, mimeType stri
 
 // Include -
 func (d *Data) Include(alias string, args ...string) (string, error) {
-	data, _, err := d.readDataSource(alias, args...)
+	return d.include(background, alias, args...)
+}
+
+func (d *Data) include(c *readCall, alias string, args ...string) (string, error) {
+	data, _, err := d.readDataSource(c, alias, args...)
 	return data, err
 }
 
 // Datasource -
 func (d *Data) Datasource(alias string, args ...string) (interface{}, error) {
-	data, mimeType, err := d.readDataSource(alias, args...)
+	return d.datasource(background, alias, args...)
+}
+
+func (d *Data) datasource(c *readCall, alias string, args ...string) (interface{}, error) {
+	data, mimeType, err := d.readDataSource(c, alias, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -381,38 +538,260 @@ func parseData(mimeType, s string) (out interface{}, err error) {
 // DatasourceReachable - Determines if the named datasource is reachable with
 // the given arguments. Reads from the datasource, and discards the returned data.
 func (d *Data) DatasourceReachable(alias string, args ...string) bool {
+	return d.datasourceReachable(background, alias, args...)
+}
+
+func (d *Data) datasourceReachable(c *readCall, alias string, args ...string) bool {
+	d.mu.RLock()
 	source, ok := d.Sources[alias]
+	d.mu.RUnlock()
 	if !ok {
 		return false
 	}
-	_, err := d.readSource(source, args...)
+	_, err := d.readSource(c, source, args...)
 	return err == nil
 }
 
+// readCall - the caller a read is made for: the read is abandoned once its
+// context is done, and it's reported to its onRead hook (if any), as well as
+// to OnSourceRead
+type readCall struct {
+	ctx    context.Context
+	onRead func(SourceRead)
+}
+
+// background - the caller of reads made directly through a Data, rather than
+// through a View
+var background = &readCall{ctx: context.Background()}
+
 // readSource returns the (possibly cached) data from the given source,
-// as referenced by the given args
-func (d *Data) readSource(source *Source, args ...string) ([]byte, error) {
-	if d.cache == nil {
-		d.cache = make(map[string][]byte)
-	}
-	cacheKey := source.Alias
-	for _, v := range args {
-		cacheKey += v
-	}
-	cached, ok := d.cache[cacheKey]
-	if ok {
-		return cached, nil
+// as referenced by the given args. Concurrent reads of the same source with
+// the same args are only read once, with the others waiting for the result.
+// No locks are held while reading, so reads of other sources (or of the same
+// source with other args) aren't held up.
+func (d *Data) readSource(c *readCall, source *Source, args ...string) ([]byte, error) {
+	if c == nil {
+		c = background
+	}
+	key := cacheKey(source.Alias, args...)
+	for {
+		if err := c.ctx.Err(); err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		cached, ok := d.cache[key]
+		if ok && (d.CacheTTL == 0 || time.Since(cached.readAt) < d.CacheTTL) {
+			d.mu.Unlock()
+			d.recordCachedRead(c, source, cached, args...)
+			return cached.data, nil
+		}
+		if f, ok := d.flights[key]; ok {
+			d.mu.Unlock()
+			select {
+			case <-f.done:
+			case <-c.ctx.Done():
+				return nil, c.ctx.Err()
+			}
+			if f.abandoned {
+				// the caller the read was made for gave up on it, so it's
+				// read again
+				continue
+			}
+			if f.err != nil {
+				return nil, f.err
+			}
+			d.recordCachedRead(c, source, f.entry, args...)
+			return f.entry.data, nil
+		}
+		f := &flight{done: make(chan struct{})}
+		if d.flights == nil {
+			d.flights = make(map[string]*flight)
+		}
+		d.flights[key] = f
+		d.mu.Unlock()
+
+		f.entry, f.err = d.read(c, source, args...)
+		f.abandoned = f.err != nil && c.ctx.Err() != nil
+
+		d.mu.Lock()
+		if d.flights[key] == f {
+			delete(d.flights, key)
+		}
+		if f.err == nil && !f.stale {
+			if d.cache == nil {
+				d.cache = make(map[string]cacheEntry)
+			}
+			d.cache[key] = f.entry
+		}
+		d.mu.Unlock()
+		close(f.done)
+		return f.entry.data, f.err
 	}
+}
+
+// read - read from the source, without the cache. The reader works on a copy
+// of the source, so that no lock is held while it reads.
+func (d *Data) read(c *readCall, source *Source, args ...string) (cacheEntry, error) {
 	r, err := d.lookupReader(source.URL.Scheme)
 	if err != nil {
-		return nil, errors.Wrap(err, "Datasource not yet supported")
+		return cacheEntry{}, errors.Wrap(err, "Datasource not yet supported")
 	}
-	data, err := r(source, args...)
+	if err = d.checkAccess(source, args...); err != nil {
+		return cacheEntry{}, err
+	}
+	rs := source.forRead(c)
+	if rs.fs == nil && rs.URL.Scheme == "file" {
+		rs.fs = d.Fs
+	}
+	start := time.Now()
+	data, err := r(rs, args...)
+	source.keep(rs)
+	read := SourceRead{Alias: source.Alias, URL: source.URL.String(), Args: args, Duration: time.Since(start), Bytes: len(data), Err: err}
 	if err != nil {
-		return nil, err
+		d.recordSourceRead(c, read)
+		return cacheEntry{}, err
 	}
-	d.cache[cacheKey] = data
-	return data, nil
+	read.Hash = hashData(data)
+	d.recordSourceRead(c, read)
+	d.recordFileRead(source, args...)
+	return cacheEntry{data: data, hash: read.Hash, readAt: start}, nil
+}
+
+// recordCachedRead - record a read served from the cache (or from a
+// concurrent read of the same data)
+func (d *Data) recordCachedRead(c *readCall, source *Source, cached cacheEntry, args ...string) {
+	d.recordFileRead(source, args...)
+	d.recordSourceRead(c, SourceRead{
+		Alias: source.Alias, URL: source.URL.String(), Args: args,
+		Hash: cached.hash, Bytes: len(cached.data), Cached: true,
+	})
+}
+
+// networkSchemes - the schemes of datasources which read over the network
+var networkSchemes = map[string]bool{
+	"aws+smp":      true,
+	"aws+sm":       true,
+	"consul":       true,
+	"consul+http":  true,
+	"consul+https": true,
+	"http":         true,
+	"https":        true,
+	"vault":        true,
+	"vault+http":   true,
+	"vault+https":  true,
+	"s3":           true,
+	"gs":           true,
+}
+
+// checkAccess - returns an error if reading from the source isn't allowed by
+// FSRoot or NoNetwork
+func (d *Data) checkAccess(source *Source, args ...string) error {
+	switch scheme := source.URL.Scheme; {
+	case d.NoNetwork && networkSchemes[scheme]:
+		return errors.New("network access is not allowed")
+	case d.FSRoot != "" && scheme == "file":
+		p, err := filePath(source, args...)
+		if err != nil {
+			return err
+		}
+		return file.InRoot(d.FSRoot, p)
+	case d.FSRoot != "" && scheme == "boltdb":
+		return file.InRoot(d.FSRoot, filepath.FromSlash(source.URL.Path))
+	}
+	return nil
+}
+
+// recordFileRead - report a successful read from a file: source to the
+// OnFileRead hook, if any
+func (d *Data) recordFileRead(source *Source, args ...string) {
+	if d.OnFileRead == nil || source.URL.Scheme != "file" {
+		return
+	}
+	if p, err := filePath(source, args...); err == nil {
+		d.OnFileRead(p)
+	}
+}
+
+// recordSourceRead - report a read to the OnSourceRead hook, and to the
+// caller's hook, if any
+func (d *Data) recordSourceRead(c *readCall, r SourceRead) {
+	if d.OnSourceRead != nil {
+		d.OnSourceRead(r)
+	}
+	if c != nil && c.onRead != nil {
+		c.onRead(r)
+	}
+}
+
+// cacheEntry - data cached from a datasource
+type cacheEntry struct {
+	data   []byte
+	hash   string
+	readAt time.Time
+}
+
+// flight - a read in progress, which concurrent reads of the same data wait
+// for (done is closed once it's finished)
+type flight struct {
+	done  chan struct{}
+	entry cacheEntry
+	err   error
+	// stale - set when the cache is cleared during the read, so that the
+	// result isn't cached
+	stale bool
+	// abandoned - set when the read failed because its caller's context was
+	// done, so that others waiting for it read again
+	abandoned bool
+}
+
+// hashData - the hex-encoded SHA-256 hash of the data
+func hashData(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheKey - the key for caching data read from the given alias with the
+// given args. The alias is always a distinct prefix, so that entries can be
+// cleared by alias.
+func cacheKey(alias string, args ...string) string {
+	return alias + "\x00" + strings.Join(args, "\x00")
+}
+
+// ClearCache - remove cached data for the given datasource aliases, so that
+// they'll be re-read the next time they're referenced. All cached data is
+// cleared when no aliases are given.
+func (d *Data) ClearCache(aliases ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(aliases) == 0 {
+		d.cache = nil
+	}
+	for _, alias := range aliases {
+		prefix := cacheKey(alias)
+		for k := range d.cache {
+			if strings.HasPrefix(k, prefix) {
+				delete(d.cache, k)
+			}
+		}
+	}
+	// reads in progress may have read the old data, so later reads must
+	// read again
+	for k, f := range d.flights {
+		if len(aliases) == 0 || hasAliasPrefix(k, aliases) {
+			f.stale = true
+			delete(d.flights, k)
+		}
+	}
+}
+
+// hasAliasPrefix - whether the cache key is for one of the aliases
+func hasAliasPrefix(key string, aliases []string) bool {
+	for _, alias := range aliases {
+		if strings.HasPrefix(key, cacheKey(alias)) {
+			return true
+		}
+	}
+	return false
 }
 
 func readStdin(source *Source, args ...string) ([]byte, error) {
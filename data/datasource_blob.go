@@ -25,7 +25,7 @@ func readBlob(source *Source, args ...string) (output []byte, err error) {
 		return nil, errors.New("Maximum two arguments to blob datasource: alias, extraPath")
 	}
 
-	ctx := context.TODO()
+	ctx := source.context()
 
 	key := source.URL.Path
 	if len(args) == 1 {
@@ -19,17 +19,9 @@ func readFile(source *Source, args ...string) ([]byte, error) {
 		source.fs = afero.NewOsFs()
 	}
 
-	p := filepath.FromSlash(source.URL.Path)
-
-	if len(args) == 1 {
-		parsed, err := url.Parse(args[0])
-		if err != nil {
-			return nil, err
-		}
-
-		if parsed.Path != "" {
-			p = filepath.Join(p, parsed.Path)
-		}
+	p, err := filePath(source, args...)
+	if err != nil {
+		return nil, err
 	}
 
 	// make sure we can access the file
@@ -58,6 +50,24 @@ func readFile(source *Source, args ...string) ([]byte, error) {
 	return b, nil
 }
 
+// filePath - the path of the file (or directory) to read from a file: source,
+// given the (optional) sub-path argument
+func filePath(source *Source, args ...string) (string, error) {
+	p := filepath.FromSlash(source.URL.Path)
+
+	if len(args) == 1 {
+		parsed, err := url.Parse(args[0])
+		if err != nil {
+			return "", err
+		}
+
+		if parsed.Path != "" {
+			p = filepath.Join(p, parsed.Path)
+		}
+	}
+	return p, nil
+}
+
 func readFileDir(source *Source, p string) ([]byte, error) {
 	names, err := afero.ReadDir(source.fs, p)
 	if err != nil {
@@ -30,7 +30,7 @@ func readHTTP(source *Source, args ...string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("GET", u.String(), nil)
+	req, err := http.NewRequestWithContext(source.context(), "GET", u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
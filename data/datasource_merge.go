@@ -37,7 +37,7 @@ func (d *Data) readMerge(source *Source, args ...string) ([]byte, error) {
 		}
 		subSource.inherit(source)
 
-		b, err := d.readSource(subSource)
+		b, err := d.readSource(source.call, subSource)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Couldn't read datasource '%s'", part)
 		}
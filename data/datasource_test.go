@@ -3,9 +3,12 @@ package data
 import (
 	"fmt"
 	"net/url"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 
@@ -357,3 +360,252 @@ func TestQueryParse(t *testing.T) {
 	assert.NoError(t, err)
 	assert.EqualValues(t, expected, u)
 }
+
+func TestClearCache(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/foo.json", []byte(`{"a":1}`), 0644)
+	_ = afero.WriteFile(fs, "/foobar.json", []byte(`{"b":2}`), 0644)
+
+	sources := map[string]*Source{
+		"foo": {
+			Alias:     "foo",
+			URL:       &url.URL{Scheme: "file", Path: "/foo.json"},
+			mediaType: jsonMimetype,
+			fs:        fs,
+		},
+		"foobar": {
+			Alias:     "foobar",
+			URL:       &url.URL{Scheme: "file", Path: "/foobar.json"},
+			mediaType: jsonMimetype,
+			fs:        fs,
+		},
+	}
+	d := &Data{Sources: sources}
+
+	_, err := d.Datasource("foo")
+	assert.NoError(t, err)
+	_, err = d.Datasource("foobar")
+	assert.NoError(t, err)
+
+	_ = afero.WriteFile(fs, "/foo.json", []byte(`{"a":2}`), 0644)
+	_ = afero.WriteFile(fs, "/foobar.json", []byte(`{"b":3}`), 0644)
+
+	d.ClearCache("foo")
+	actual, err := d.Datasource("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 2}, actual)
+
+	// foobar is still cached
+	actual, err = d.Datasource("foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"b": 2}, actual)
+
+	d.ClearCache()
+	actual, err = d.Datasource("foobar")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"b": 3}, actual)
+}
+
+func TestCacheTTL(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/foo.json", []byte(`{"a":1}`), 0644)
+	d := &Data{
+		Sources: map[string]*Source{
+			"foo": {Alias: "foo", URL: &url.URL{Scheme: "file", Path: "/foo.json"}, mediaType: jsonMimetype, fs: fs},
+		},
+		CacheTTL: 50 * time.Millisecond,
+	}
+
+	_, err := d.Datasource("foo")
+	assert.NoError(t, err)
+	_ = afero.WriteFile(fs, "/foo.json", []byte(`{"a":2}`), 0644)
+
+	actual, err := d.Datasource("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 1}, actual)
+
+	time.Sleep(60 * time.Millisecond)
+	actual, err = d.Datasource("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 2}, actual)
+}
+
+func TestConcurrentDatasourceReads(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/foo.json", []byte(`{"a":1}`), 0644)
+	d := &Data{Sources: map[string]*Source{
+		"foo": {
+			Alias: "foo",
+			URL:   &url.URL{Scheme: "file", Path: "/foo.json"},
+			fs:    fs,
+		},
+	}}
+
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			alias := fmt.Sprintf("bar%d", i)
+			_, err := d.DefineDatasource(alias, "https://example.com")
+			if err == nil && !d.DatasourceExists(alias) {
+				err = fmt.Errorf("%s not defined", alias)
+			}
+			if err == nil {
+				_, err = d.Datasource("foo")
+			}
+			if i%2 == 0 {
+				d.ClearCache("foo")
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, <-errs)
+	}
+	assert.Len(t, d.Sources, 21)
+}
+
+func TestConcurrentReadsOfOneSource(t *testing.T) {
+	release := make(chan struct{})
+	var reads int32
+	d := &Data{
+		Sources: map[string]*Source{
+			"foo": {Alias: "foo", URL: &url.URL{Scheme: "slow", Opaque: "foo"}},
+		},
+		sourceReaders: map[string]func(*Source, ...string) ([]byte, error){
+			"slow": func(s *Source, args ...string) ([]byte, error) {
+				if args[0] == "blocked" {
+					atomic.AddInt32(&reads, 1)
+					<-release
+				}
+				return []byte(args[0]), nil
+			},
+		},
+	}
+
+	results := make(chan string, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			out, err := d.Include("foo", "blocked")
+			assert.NoError(t, err)
+			results <- out
+		}()
+	}
+
+	// a read of the same source with other args isn't held up
+	quick := make(chan string)
+	go func() {
+		out, _ := d.Include("foo", "quick")
+		quick <- out
+	}()
+	select {
+	case out := <-quick:
+		assert.Equal(t, "quick", out)
+	case <-time.After(time.Second):
+		t.Fatal("read was blocked by a read of other args")
+	}
+
+	close(release)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, "blocked", <-results)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reads))
+}
+
+func TestOnFileRead(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/tmp/foo.json", []byte(`{}`), 0644)
+
+	read := []string{}
+	d := &Data{
+		Sources: map[string]*Source{
+			"foo":   {Alias: "foo", URL: &url.URL{Scheme: "file", Path: "/tmp/foo.json"}},
+			"bogus": {Alias: "bogus", URL: &url.URL{Scheme: "file", Path: "/tmp/bogus.json"}},
+		},
+		Fs:         fs,
+		OnFileRead: func(p string) { read = append(read, p) },
+	}
+	_, err := d.Include("foo")
+	assert.NoError(t, err)
+	// cached reads are still reported
+	_, err = d.Include("foo")
+	assert.NoError(t, err)
+	_, err = d.Include("bogus")
+	assert.Error(t, err)
+
+	p := filepath.FromSlash("/tmp/foo.json")
+	assert.Equal(t, []string{p, p}, read)
+}
+
+func TestOnSourceRead(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/tmp/foo.json", []byte(`{"a": 1}`), 0644)
+
+	reads := []SourceRead{}
+	d := &Data{
+		Sources: map[string]*Source{
+			"foo":   {Alias: "foo", URL: &url.URL{Scheme: "file", Path: "/tmp/foo.json"}},
+			"bogus": {Alias: "bogus", URL: &url.URL{Scheme: "file", Path: "/tmp/bogus.json"}},
+		},
+		Fs:           fs,
+		OnSourceRead: func(r SourceRead) { reads = append(reads, r) },
+	}
+	_, err := d.Datasource("foo")
+	assert.NoError(t, err)
+	_, err = d.Datasource("foo")
+	assert.NoError(t, err)
+	_, err = d.Datasource("bogus")
+	assert.Error(t, err)
+
+	assert.Len(t, reads, 3)
+	assert.Equal(t, "foo", reads[0].Alias)
+	assert.Equal(t, 8, reads[0].Bytes)
+	assert.False(t, reads[0].Cached)
+	assert.NoError(t, reads[0].Err)
+	assert.Equal(t, "file:///tmp/foo.json", reads[0].URL)
+	assert.Equal(t, "f9d86028c6e0d64e225186f96acb69338b2c59764df79162107f5c4bb34d1310", reads[0].Hash)
+	assert.Equal(t, SourceRead{Alias: "foo", URL: "file:///tmp/foo.json", Hash: reads[0].Hash, Bytes: 8, Cached: true}, reads[1])
+	assert.Equal(t, "bogus", reads[2].Alias)
+	assert.Error(t, reads[2].Err)
+	assert.Equal(t, "", reads[2].Hash)
+}
+
+func TestSandboxedSources(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/conf/foo.json", []byte(`{"a": 1}`), 0644)
+	_ = afero.WriteFile(fs, "/secret.json", []byte(`{"b": 2}`), 0644)
+
+	d := &Data{
+		Sources: map[string]*Source{
+			"foo":    {Alias: "foo", URL: &url.URL{Scheme: "file", Path: "/conf/foo.json"}},
+			"dir":    {Alias: "dir", URL: &url.URL{Scheme: "file", Path: "/conf/"}},
+			"secret": {Alias: "secret", URL: &url.URL{Scheme: "file", Path: "/secret.json"}},
+			"web":    {Alias: "web", URL: &url.URL{Scheme: "https", Host: "example.com"}},
+		},
+		Fs:        fs,
+		FSRoot:    "/conf",
+		NoNetwork: true,
+		NoDefine:  true,
+	}
+	_, err := d.Datasource("foo")
+	assert.NoError(t, err)
+	_, err = d.Include("dir", "foo.json")
+	assert.NoError(t, err)
+
+	_, err = d.Include("dir", "../secret.json")
+	assert.Error(t, err)
+	_, err = d.Datasource("secret")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is outside of /conf")
+	_, err = d.Datasource("web")
+	assert.Contains(t, err.Error(), "network access is not allowed")
+	assert.False(t, d.DatasourceReachable("secret"))
+
+	_, err = d.Datasource("file:///secret.json")
+	assert.Contains(t, err.Error(), "Undefined datasource 'file:///secret.json'")
+	_, err = d.DefineDatasource("bar", "file:///conf/foo.json")
+	assert.Error(t, err)
+	assert.False(t, d.DatasourceExists("bar"))
+	// redefining an existing datasource is still a no-op
+	_, err = d.DefineDatasource("foo", "file:///secret.json")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,44 @@
+package data
+
+import "context"
+
+// View - a view of the datasources for a single caller, like one render of a
+// template. Reads through a View share the Data's datasources and cache, but
+// are abandoned once the View's context is done, and are reported to the
+// View's hook (if any) as well as to the Data's OnSourceRead hook.
+type View struct {
+	d    *Data
+	call *readCall
+}
+
+// View - a view of the datasources whose reads are made with ctx, and
+// reported to onRead (if set)
+func (d *Data) View(ctx context.Context, onRead func(SourceRead)) *View {
+	return &View{d: d, call: &readCall{ctx: ctx, onRead: onRead}}
+}
+
+// Datasource - like Data.Datasource, reading with the View's context
+func (v *View) Datasource(alias string, args ...string) (interface{}, error) {
+	return v.d.datasource(v.call, alias, args...)
+}
+
+// Include - like Data.Include, reading with the View's context
+func (v *View) Include(alias string, args ...string) (string, error) {
+	return v.d.include(v.call, alias, args...)
+}
+
+// DatasourceReachable - like Data.DatasourceReachable, reading with the
+// View's context
+func (v *View) DatasourceReachable(alias string, args ...string) bool {
+	return v.d.datasourceReachable(v.call, alias, args...)
+}
+
+// DatasourceExists - see Data.DatasourceExists
+func (v *View) DatasourceExists(alias string) bool {
+	return v.d.DatasourceExists(alias)
+}
+
+// DefineDatasource - see Data.DefineDatasource
+func (v *View) DefineDatasource(alias, value string) (string, error) {
+	return v.d.DefineDatasource(alias, value)
+}
@@ -0,0 +1,171 @@
+package gomplate
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// depTracker records the files each output depends on, for writing a depfile
+// (as with --depfile).
+//
+// Files read while an output is rendering are attributed to it. When several
+// outputs render concurrently their reads can't be told apart, so files are
+// attributed to all of them - extra dependencies only cause extra rebuilds,
+// whereas missing ones would leave stale outputs. Files read while nothing is
+// rendering (such as context datasources) are dependencies of every output.
+type depTracker struct {
+	mu sync.Mutex
+
+	common    []string
+	rendering map[string]bool
+	outputs   []string
+	deps      map[string][]string
+}
+
+func newDepTracker() *depTracker {
+	return &depTracker{
+		rendering: map[string]bool{},
+		deps:      map[string][]string{},
+	}
+}
+
+// record - record that the given file was read. Safe for concurrent use.
+func (d *depTracker) record(path string) {
+	path = relToWd(path)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.rendering) == 0 {
+		d.common = append(d.common, path)
+		return
+	}
+	for output := range d.rendering {
+		d.deps[output] = append(d.deps[output], path)
+	}
+}
+
+// begin - start attributing reads to the given output, which is known to
+// depend on the given files
+func (d *depTracker) begin(output string, files ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.deps[output]; !ok {
+		d.outputs = append(d.outputs, output)
+	}
+	deps := append([]string{}, files...)
+	deps = append(deps, d.common...)
+	d.deps[output] = deps
+	d.rendering[output] = true
+}
+
+// end - stop attributing reads to the given output
+func (d *depTracker) end(output string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.rendering, output)
+}
+
+// files - all files any output depends on
+func (d *depTracker) files() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	files := append([]string{}, d.common...)
+	for _, output := range d.outputs {
+		files = append(files, d.deps[output]...)
+	}
+	return unique(files)
+}
+
+// dependsOn - whether the output depended on any of the given files (as made
+// relative to the working directory, like recorded paths) when it was last
+// rendered. Outputs not rendered yet depend on everything.
+func (d *depTracker) dependsOn(output string, files map[string]bool) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deps, ok := d.deps[output]
+	if !ok {
+		return true
+	}
+	for _, dep := range deps {
+		if files[relToWd(dep)] {
+			return true
+		}
+	}
+	return false
+}
+
+// write - write the dependencies in Makefile syntax, one rule per output
+func (d *depTracker) write(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	buf := &bytes.Buffer{}
+	for _, output := range d.outputs {
+		buf.WriteString(escapeDep(output))
+		buf.WriteString(":")
+		for _, dep := range unique(d.deps[output]) {
+			buf.WriteString(" ")
+			buf.WriteString(escapeDep(dep))
+		}
+		buf.WriteString("\n")
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// templateDeps - the files a template is known to depend on before it's
+// rendered: the template itself (unless given inline or on stdin), and all
+// nested templates (except those read from URLs), since any of them may be
+// referenced
+func (g *gomplate) templateDeps(t *tplate) []string {
+	deps := []string{}
+	if t.name != "<arg>" && t.name != "-" {
+		deps = append(deps, t.name)
+	}
+	nested := nestedTemplateFiles(g.nestedTemplates)
+	sort.Strings(nested)
+	return append(deps, nested...)
+}
+
+// unique - the given strings with duplicates removed, in order of first
+// appearance
+func unique(in []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// relToWd - paths under the working directory are made relative to it, since
+// that's how make usually refers to them. Datasource paths are always absolute.
+func relToWd(p string) string {
+	if !filepath.IsAbs(p) {
+		return p
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return p
+	}
+	rel, err := filepath.Rel(wd, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return p
+	}
+	return rel
+}
+
+// escapeDep - escape a path for use in a Makefile rule
+func escapeDep(p string) string {
+	return strings.NewReplacer(
+		" ", `\ `,
+		"#", `\#`,
+		"$", "$$",
+	).Replace(p)
+}
@@ -0,0 +1,69 @@
+package gomplate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDepTracker(t *testing.T) {
+	d := newDepTracker()
+	d.record("ctx.json")
+
+	d.begin("out/a", "in/a", "t.tmpl")
+	d.record("data.json")
+	d.record("data.json")
+	d.end("out/a")
+
+	d.begin("out/b b", "in/b")
+	d.begin("out/c", "in/c")
+	d.record("$shared#1")
+	d.end("out/b b")
+	d.end("out/c")
+
+	d.record("ignored")
+
+	wd, _ := os.Getwd()
+	assert.Equal(t, filepath.Join("dir", "f"), relToWd(filepath.Join(wd, "dir", "f")))
+	assert.Equal(t, "rel", relToWd("rel"))
+	assert.Equal(t, filepath.Dir(wd), relToWd(filepath.Dir(wd)))
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, d.write(buf))
+	expected := `out/a: in/a t.tmpl ctx.json data.json
+out/b\ b: in/b ctx.json $$shared\#1
+out/c: in/c ctx.json $$shared\#1
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestRunTemplatesDepFile(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	_ = afero.WriteFile(fs, "in/a", []byte(`{{ .ctx.a }}{{ template "t" }}{{ include "inc" }}`), 0644)
+	_ = afero.WriteFile(fs, "in/b", []byte(`{{ (ds "data").b }}`), 0644)
+	_ = afero.WriteFile(fs, "t.tmpl", []byte(`t`), 0644)
+	_ = afero.WriteFile(fs, "/ctx.json", []byte(`{"a": "a"}`), 0644)
+	_ = afero.WriteFile(fs, "/data.json", []byte(`{"b": "b"}`), 0644)
+	_ = afero.WriteFile(fs, "/inc.txt", []byte(`inc`), 0644)
+
+	err := RunTemplates(&Config{
+		InputDir:    "in",
+		OutputDir:   "out",
+		Contexts:    []string{"ctx=file:///ctx.json"},
+		DataSources: []string{"data=file:///data.json", "inc=file:///inc.txt"},
+		Templates:   []string{"t=t.tmpl"},
+		DepFile:     "out.d",
+	})
+	assert.NoError(t, err)
+	assertFile(t, "out/a", "atinc")
+	assertFile(t, "out.d", `out/a: in/a t.tmpl /ctx.json /inc.txt
+out/b: in/b t.tmpl /ctx.json /data.json
+`)
+}
@@ -0,0 +1,216 @@
+package gomplate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/afero"
+)
+
+// dryRun - render all templates in memory without writing any files, and
+// find the outputs that would change. With o.Diff, a unified diff is written
+// to out for each of these. With o.Check, an error is returned if any output
+// would change.
+func (g *gomplate) dryRun(o *Config, out io.Writer) error {
+	// all writes (including creating output directories) go to a memory layer
+	// on top of the filesystem, so nothing is modified
+	base := g.fs
+	rendered := afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(base), afero.NewMemMapFs())
+	origStdout := g.stdout
+	defer func() {
+		g.fs = base
+		g.stdout = origStdout
+	}()
+	g.fs = rendered
+	// output to stdout isn't compared to anything
+	g.stdout = &nopWCloser{ioutil.Discard}
+
+	tmpl, err := g.gatherTemplates(o)
+	if err != nil {
+		return err
+	}
+	err = g.renderTemplates(tmpl, o.Parallelism)
+	if err != nil {
+		return err
+	}
+
+	changed := []string{}
+	for _, t := range tmpl {
+		paths := t.extraPaths
+		if t.targetPath != "-" {
+			paths = append([]string{t.targetPath}, paths...)
+		}
+		for _, p := range paths {
+			differs, diff, err := diffOutput(base, rendered, p)
+			if err != nil {
+				return err
+			}
+			if !differs {
+				continue
+			}
+			changed = append(changed, p)
+			if o.Diff {
+				_, err = io.WriteString(out, diff)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if o.Check && len(changed) > 0 {
+		return errors.Errorf("%d output(s) would change: %s", len(changed), strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// errDryRun - the error for anything that would modify files in a dry run
+var errDryRun = errors.New("nothing can be written with --diff or --check")
+
+// dryRunFs - a read-only filesystem for dry runs, whose write operations fail
+// with errDryRun, so that functions like file.Write fail clearly instead of
+// modifying anything
+type dryRunFs struct {
+	afero.Fs
+}
+
+func (f *dryRunFs) Create(name string) (afero.File, error) {
+	return nil, &os.PathError{Op: "create", Path: name, Err: errDryRun}
+}
+
+func (f *dryRunFs) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: errDryRun}
+}
+
+func (f *dryRunFs) MkdirAll(path string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: path, Err: errDryRun}
+}
+
+func (f *dryRunFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errDryRun}
+	}
+	return f.Fs.OpenFile(name, flag, perm)
+}
+
+func (f *dryRunFs) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: errDryRun}
+}
+
+func (f *dryRunFs) RemoveAll(path string) error {
+	return &os.PathError{Op: "remove", Path: path, Err: errDryRun}
+}
+
+func (f *dryRunFs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: errDryRun}
+}
+
+func (f *dryRunFs) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: errDryRun}
+}
+
+func (f *dryRunFs) Chtimes(name string, atime, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: errDryRun}
+}
+
+// denyPlugins - replace the plugins in funcMap with functions which fail, as
+// they run external commands, which could modify anything
+func denyPlugins(funcMap template.FuncMap, plugins []*plugin) {
+	for _, p := range plugins {
+		err := errors.Errorf("plugin %s can't be run with --diff or --check, as it could modify files", p.name)
+		funcMap[p.name] = func(...interface{}) (interface{}, error) {
+			return nil, err
+		}
+	}
+}
+
+// diffOutput - whether the file at path would change, with a unified diff
+// between its current content (in base) and its newly-rendered content (in
+// rendered). It's unchanged when the content and mode are identical, or when
+// nothing was rendered (i.e. empty output was suppressed). A file that
+// doesn't exist yet is always a change, even when it's rendered empty.
+func diffOutput(base, rendered afero.Fs, path string) (changed bool, diff string, err error) {
+	after, err := afero.ReadFile(rendered, path)
+	if os.IsNotExist(err) {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", errors.Wrapf(err, "failed to read rendered output %s", path)
+	}
+	fromDate, toDate := "(current)", "(rendered)"
+	before, err := afero.ReadFile(base, path)
+	switch {
+	case os.IsNotExist(err):
+		changed = true
+		fromDate = "(missing)"
+	case err != nil:
+		return false, "", errors.Wrapf(err, "failed to read %s", path)
+	default:
+		// a mode change (from --chmod, or front matter) is a change too
+		was, now, err := fileModes(base, rendered, path)
+		if err != nil {
+			return false, "", err
+		}
+		if was != now {
+			changed = true
+			fromDate = fmt.Sprintf("(current, mode %04o)", was)
+			toDate = fmt.Sprintf("(rendered, mode %04o)", now)
+		}
+	}
+	if !changed && bytes.Equal(before, after) {
+		return false, "", nil
+	}
+	if bytes.Equal(before, after) {
+		// only the headers are shown when the content is the same
+		return true, fmt.Sprintf("--- %s\t%s\n+++ %s\t%s\n", path, fromDate, path, toDate), nil
+	}
+	diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(string(before)),
+		B:        splitLines(string(after)),
+		FromFile: path,
+		FromDate: fromDate,
+		ToFile:   path,
+		ToDate:   toDate,
+		Context:  3,
+	})
+	return true, diff, err
+}
+
+// fileModes - the permissions of the file at path, currently (in base) and
+// once rendered
+func fileModes(base, rendered afero.Fs, path string) (before, after os.FileMode, err error) {
+	fi, err := base.Stat(path)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to stat %s", path)
+	}
+	before = fi.Mode().Perm()
+	fi, err = rendered.Stat(path)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to stat rendered output %s", path)
+	}
+	return before, fi.Mode().Perm(), nil
+}
+
+// splitLines - split s into lines for diffing, each ending with a newline.
+// A last line without a newline is followed by a "\ No newline at end of
+// file" marker (as in diff's output), so that adding or removing a trailing
+// newline shows up as a change.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n\\ No newline at end of file\n"
+	return lines
+}
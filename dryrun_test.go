@@ -0,0 +1,185 @@
+package gomplate
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	base := fs
+
+	_ = afero.WriteFile(fs, "in/same", []byte("same\n"), 0644)
+	_ = afero.WriteFile(fs, "in/changed", []byte("one\n{{ print `two` }}\nthree\n"), 0644)
+	_ = afero.WriteFile(fs, "in/new", []byte("new\n"), 0644)
+	_ = afero.WriteFile(fs, "out/same", []byte("same\n"), 0644)
+	_ = afero.WriteFile(fs, "out/changed", []byte("one\n2\nthree\n"), 0644)
+
+	g := newGomplate(fs, template.FuncMap{}, "{{", "}}", nil, nil)
+
+	out := &bytes.Buffer{}
+	err := g.dryRun(&Config{InputDir: "in", OutputDir: "out", Diff: true}, out)
+	assert.NoError(t, err)
+	expected := `--- out/changed	(current)
++++ out/changed	(rendered)
+@@ -1,3 +1,3 @@
+ one
+-2
++two
+ three
+--- out/new	(missing)
++++ out/new	(rendered)
+@@ -0,0 +1 @@
++new
+`
+	assert.Equal(t, expected, out.String())
+
+	// nothing was written, and the renderer writes to its filesystem again
+	assert.Equal(t, base, g.fs)
+	assertFile(t, "out/changed", "one\n2\nthree\n")
+	exists, _ := afero.Exists(fs, "out/new")
+	assert.False(t, exists)
+
+	out.Reset()
+	err = g.dryRun(&Config{InputDir: "in", OutputDir: "out", Check: true}, out)
+	assert.EqualError(t, err, "2 output(s) would change: out/changed, out/new")
+	assert.Empty(t, out.String())
+
+	err = g.dryRun(&Config{InputFiles: []string{"in/same"}, OutputFiles: []string{"out/same"}, Check: true}, out)
+	assert.NoError(t, err)
+}
+
+func TestDryRunExtraOutputs(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	_ = afero.WriteFile(fs, "in/main", []byte(`main{{ out.Write "extra" "new" }}`), 0644)
+	_ = afero.WriteFile(fs, "out/main", []byte("main"), 0644)
+	_ = afero.WriteFile(fs, "out/extra", []byte("old"), 0644)
+
+	g := newGomplate(fs, template.FuncMap{}, "{{", "}}", nil, nil)
+	g.outputDir = "out"
+
+	err := g.dryRun(&Config{InputDir: "in", OutputDir: "out", Check: true}, &bytes.Buffer{})
+	assert.EqualError(t, err, "1 output(s) would change: out/extra")
+	assertFile(t, "out/extra", "old")
+}
+
+func TestDiffOutputTrailingNewline(t *testing.T) {
+	base := afero.NewMemMapFs()
+	rendered := afero.NewMemMapFs()
+	_ = afero.WriteFile(base, "a", []byte("hello"), 0644)
+	_ = afero.WriteFile(rendered, "a", []byte("hello\n"), 0644)
+	_ = afero.WriteFile(base, "b", []byte("one\ntwo\n"), 0644)
+	_ = afero.WriteFile(rendered, "b", []byte("one\ntwo"), 0644)
+
+	changed, diff, err := diffOutput(base, rendered, "a")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, `--- a	(current)
++++ a	(rendered)
+@@ -1 +1 @@
+-hello
+\ No newline at end of file
++hello
+`, diff)
+
+	changed, diff, err = diffOutput(base, rendered, "b")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, `--- b	(current)
++++ b	(rendered)
+@@ -1,2 +1,2 @@
+ one
+-two
++two
+\ No newline at end of file
+`, diff)
+
+	changed, diff, err = diffOutput(base, base, "a")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Empty(t, diff)
+}
+
+func TestDiffOutputNewAndModes(t *testing.T) {
+	base := afero.NewMemMapFs()
+	rendered := afero.NewMemMapFs()
+	_ = afero.WriteFile(rendered, "empty", []byte{}, 0644)
+	_ = afero.WriteFile(base, "script", []byte("#!/bin/sh\n"), 0644)
+	_ = afero.WriteFile(rendered, "script", []byte("#!/bin/sh\n"), 0755)
+	_ = afero.WriteFile(base, "conf", []byte("a\n"), 0600)
+	_ = afero.WriteFile(rendered, "conf", []byte("b\n"), 0644)
+
+	// a new file is a change, even when it's empty
+	changed, diff, err := diffOutput(base, rendered, "empty")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "--- empty\t(missing)\n+++ empty\t(rendered)\n", diff)
+
+	changed, diff, err = diffOutput(base, rendered, "script")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "--- script\t(current, mode 0644)\n+++ script\t(rendered, mode 0755)\n", diff)
+
+	changed, diff, err = diffOutput(base, rendered, "conf")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, `--- conf	(current, mode 0600)
++++ conf	(rendered, mode 0644)
+@@ -1 +1 @@
+-a
++b
+`, diff)
+}
+
+func TestDryRunChmod(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	_ = afero.WriteFile(fs, "in", []byte("same\n"), 0644)
+	_ = afero.WriteFile(fs, "out", []byte("same\n"), 0644)
+
+	g := newGomplate(fs, template.FuncMap{}, "{{", "}}", nil, nil)
+	err := g.dryRun(&Config{InputFiles: []string{"in"}, OutputFiles: []string{"out"}, OutMode: "755", Check: true}, &bytes.Buffer{})
+	assert.EqualError(t, err, "1 output(s) would change: out")
+
+	err = g.dryRun(&Config{InputFiles: []string{"in"}, OutputFiles: []string{"out"}, OutMode: "644", Check: true}, &bytes.Buffer{})
+	assert.NoError(t, err)
+}
+
+func TestDryRunNoSideEffects(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "out", []byte("old"), 0644)
+
+	dfs := &dryRunFs{afero.NewReadOnlyFs(fs)}
+	err := afero.WriteFile(dfs, "other", []byte("x"), 0644)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "nothing can be written with --diff or --check")
+	assert.Error(t, dfs.Remove("out"))
+	b, err := afero.ReadFile(dfs, "out")
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(b))
+
+	// outputs are still rendered to memory on top of it
+	funcMap := template.FuncMap{}
+	denyPlugins(funcMap, []*plugin{{name: "touch"}})
+	g := newGomplate(dfs, funcMap, "{{", "}}", nil, nil)
+	err = g.dryRun(&Config{Input: "new", OutputFiles: []string{"out"}, Check: true}, &bytes.Buffer{})
+	assert.EqualError(t, err, "1 output(s) would change: out")
+
+	err = g.dryRun(&Config{Input: `{{ touch "x" }}`, OutputFiles: []string{"out"}, Check: true}, &bytes.Buffer{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "plugin touch can't be run with --diff or --check")
+	assertFile(t, "out", "old")
+}
@@ -0,0 +1,299 @@
+package gomplate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// how many lines of source are shown before and after the line with the error
+const errorContextLines = 2
+
+// ErrorReport - a structured report of an error rendering a template, for
+// showing to users (see WriteErrorReports)
+type ErrorReport struct {
+	// Message - the full error message
+	Message string `json:"message"`
+
+	// Template - the name of the template (usually its path) the error
+	// occurred in, if known
+	Template string `json:"template,omitempty"`
+	// Line, Column - the position of the error in the template, starting at 1.
+	// The column is 0 when it isn't known (as for syntax errors).
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+	// Source - the lines of the template around the error
+	Source []SourceLine `json:"source,omitempty"`
+
+	// Namespace - the namespace of the function that failed (such as
+	// "strings"), if any
+	Namespace string `json:"namespace,omitempty"`
+	// Function - the function that failed (such as "strings.Repeat")
+	Function string `json:"function,omitempty"`
+	// Call - the failing call, as it appears in the template (arguments may be
+	// abbreviated)
+	Call string `json:"call,omitempty"`
+	// Datasource - the alias of the datasource involved, if any
+	Datasource string `json:"datasource,omitempty"`
+}
+
+// SourceLine - a line of template source in an ErrorReport
+type SourceLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// templateError - an error from rendering a template, which keeps access to
+// the template's source (and the sources of nested templates) for error
+// reports. The source's line offset is the number of lines preceding it in
+// its file (i.e. front matter).
+type templateError struct {
+	err    error
+	source func(name string) (src string, lineOffset int, ok bool)
+}
+
+func (e *templateError) Error() string {
+	return e.err.Error()
+}
+
+// Cause - the underlying error (for github.com/pkg/errors.Cause)
+func (e *templateError) Cause() error {
+	return e.err
+}
+
+// wrapTemplateError - wrap an error from rendering the given template, so
+// that error reports can show its source
+func (g *gomplate) wrapTemplateError(t *tplate, err error) error {
+	if err == nil {
+		return nil
+	}
+	// the nested templates' sources are the ones that were parsed, rather
+	// than read again (they may have changed since, or be expensive to read)
+	g.baseMu.Lock()
+	nested := g.baseSources
+	g.baseMu.Unlock()
+	return &templateError{
+		err: err,
+		source: func(name string) (string, int, bool) {
+			if name == t.name {
+				return t.contents, t.frontMatterLines, true
+			}
+			s, ok := nested[name]
+			return s, 0, ok
+		},
+	}
+}
+
+// ErrorReports - reports for an error returned by RunTemplates or
+// Renderer.Render. When several templates failed to render, there's a report
+// for each. Errors that didn't come from a template have only a Message.
+func ErrorReports(err error) []ErrorReport {
+	if err == nil {
+		return nil
+	}
+	if errs, ok := err.(renderErrors); ok {
+		reports := make([]ErrorReport, len(errs))
+		for i, e := range errs {
+			reports[i] = newErrorReport(e)
+		}
+		return reports
+	}
+	return []ErrorReport{newErrorReport(err)}
+}
+
+var (
+	// parse errors have no column
+	tmplErrLocRe     = regexp.MustCompile(`template: (.+?):(\d+):(?:(\d+):)? `)
+	tmplErrCallRe    = regexp.MustCompile(`executing ".*?" at <(.*?)>: `)
+	undefinedFuncRe  = regexp.MustCompile(`function "(.*?)" not defined`)
+	datasourceErrRe  = regexp.MustCompile(`datasource '(.*?)'`)
+	datasourceCallRe = regexp.MustCompile("^\\S+\\s+(\"(?:[^\"\\\\]|\\\\.)*\"|`[^`]*`)")
+)
+
+// datasourceFuncs - functions whose first argument is a datasource alias
+var datasourceFuncs = map[string]bool{
+	"ds":                  true,
+	"datasource":          true,
+	"datasourceExists":    true,
+	"datasourceReachable": true,
+	"include":             true,
+}
+
+func newErrorReport(err error) ErrorReport {
+	r := ErrorReport{Message: err.Error()}
+
+	if m := tmplErrLocRe.FindStringSubmatch(r.Message); m != nil {
+		r.Template = m[1]
+		r.Line, _ = strconv.Atoi(m[2])
+		if m[3] != "" {
+			// text/template's columns start at 0
+			col, _ := strconv.Atoi(m[3])
+			r.Column = col + 1
+		}
+	}
+
+	if m := tmplErrCallRe.FindStringSubmatch(r.Message); m != nil {
+		r.Call = m[1]
+		// fields, variables, and actions (like {{template "foo"}}) aren't
+		// function calls
+		if f := strings.Fields(r.Call); len(f) > 0 && !strings.HasPrefix(f[0], ".") && !strings.HasPrefix(f[0], "$") && !strings.HasPrefix(f[0], "{{") {
+			r.Function = f[0]
+		}
+	} else if m := undefinedFuncRe.FindStringSubmatch(r.Message); m != nil {
+		r.Function = m[1]
+	}
+	if i := strings.LastIndex(r.Function, "."); i > 0 {
+		r.Namespace = r.Function[:i]
+	}
+
+	if datasourceFuncs[r.Function] {
+		if m := datasourceCallRe.FindStringSubmatch(r.Call); m != nil {
+			r.Datasource, _ = strconv.Unquote(m[1])
+		}
+	}
+	if r.Datasource == "" {
+		if m := datasourceErrRe.FindStringSubmatch(r.Message); m != nil {
+			r.Datasource = m[1]
+		}
+	}
+
+	if te, ok := err.(*templateError); ok && r.Line > 0 {
+		if src, offset, ok := te.source(r.Template); ok {
+			r.Source = sourceLines(src, r.Line)
+			if offset > 0 {
+				r.Line += offset
+				for i := range r.Source {
+					r.Source[i].Line += offset
+				}
+				r.Message = shiftErrorLine(r.Message, offset)
+			}
+		}
+	}
+	return r
+}
+
+// shiftErrorLine - shift the line number of the (first) template location in
+// msg by offset
+func shiftErrorLine(msg string, offset int) string {
+	loc := tmplErrLocRe.FindStringSubmatchIndex(msg)
+	if loc == nil {
+		return msg
+	}
+	line, _ := strconv.Atoi(msg[loc[4]:loc[5]])
+	return msg[:loc[4]] + strconv.Itoa(line+offset) + msg[loc[5]:]
+}
+
+// sourceLines - the lines of src around the given line
+func sourceLines(src string, line int) []SourceLine {
+	lines := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+	if line > len(lines) {
+		return nil
+	}
+	first := line - errorContextLines
+	if first < 1 {
+		first = 1
+	}
+	last := line + errorContextLines
+	if last > len(lines) {
+		last = len(lines)
+	}
+	out := []SourceLine{}
+	for n := first; n <= last; n++ {
+		out = append(out, SourceLine{Line: n, Text: strings.TrimSuffix(lines[n-1], "\r")})
+	}
+	return out
+}
+
+// String - the report in a human-readable form: the message, followed by the
+// source around the error (with a caret at the failing column), and the
+// function and datasource involved
+func (r ErrorReport) String() string {
+	b := &strings.Builder{}
+	b.WriteString(r.Message)
+	if r.Template == "" {
+		return b.String()
+	}
+
+	width := 0
+	for _, l := range r.Source {
+		if w := len(strconv.Itoa(l.Line)); w > width {
+			width = w
+		}
+	}
+	gutter := strings.Repeat(" ", width)
+
+	fmt.Fprintf(b, "\n%s--> %s:%d", gutter, r.Template, r.Line)
+	if r.Column > 0 {
+		fmt.Fprintf(b, ":%d", r.Column)
+	}
+	if len(r.Source) > 0 {
+		fmt.Fprintf(b, "\n%s |", gutter)
+	}
+	for _, l := range r.Source {
+		fmt.Fprintf(b, "\n%*d | %s", width, l.Line, l.Text)
+		if l.Line == r.Line && r.Column > 0 {
+			fmt.Fprintf(b, "\n%s | %s^", gutter, caretIndent(l.Text, r.Column))
+		}
+	}
+
+	if r.Function != "" {
+		fmt.Fprintf(b, "\n%s = function: %s", gutter, r.Function)
+	}
+	if r.Call != "" {
+		fmt.Fprintf(b, "\n%s = call: %s", gutter, r.Call)
+	}
+	if r.Datasource != "" {
+		fmt.Fprintf(b, "\n%s = datasource: %s", gutter, r.Datasource)
+	}
+	return b.String()
+}
+
+// caretIndent - whitespace to line a caret up under the given (1-based)
+// column of the line, keeping tabs so it lines up however they're displayed.
+// The column counts bytes (as in template errors), but the caret must be
+// indented by characters, so a space is written for each rune before it.
+func caretIndent(line string, col int) string {
+	if col-1 > len(line) {
+		col = len(line) + 1
+	}
+	prefix := line[:col-1]
+	indent := &strings.Builder{}
+	indent.Grow(utf8.RuneCountInString(prefix))
+	for len(prefix) > 0 {
+		c, size := utf8.DecodeRuneInString(prefix)
+		prefix = prefix[size:]
+		if c == '\t' {
+			indent.WriteRune(c)
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+	return indent.String()
+}
+
+// WriteErrorReports - write reports for the given error (see ErrorReports) in
+// the given format: "json" writes each report as a JSON object on its own
+// line, and anything else writes the reports in human-readable form.
+func WriteErrorReports(w io.Writer, err error, format string) error {
+	reports := ErrorReports(err)
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, r := range reports {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, r := range reports {
+		if _, err := fmt.Fprintln(w, r.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package gomplate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorReports(t *testing.T) {
+	assert.Nil(t, ErrorReports(nil))
+	assert.Equal(t, []ErrorReport{{Message: "foo"}}, ErrorReports(errors.New("foo")))
+
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/t.tmpl", []byte("one\n{{ strings.Repeat -1 \"a\" }}"), 0644)
+	r, err := NewRenderer(RenderOptions{Templates: []string{"t=/t.tmpl"}, Fs: fs})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	render := func(in string) ErrorReport {
+		_, err := r.Render(context.Background(), "in.tmpl", strings.NewReader(in), &bytes.Buffer{})
+		assert.Error(t, err)
+		reports := ErrorReports(err)
+		assert.Len(t, reports, 1)
+		return reports[0]
+	}
+
+	rep := render("1\n2\n3\n\t{{ ds \"foo\" }}\n5\n6\n7")
+	assert.Equal(t, "in.tmpl", rep.Template)
+	assert.Equal(t, 4, rep.Line)
+	assert.Equal(t, 5, rep.Column)
+	assert.Equal(t, []SourceLine{
+		{2, "2"}, {3, "3"}, {4, "\t{{ ds \"foo\" }}"}, {5, "5"}, {6, "6"},
+	}, rep.Source)
+	assert.Equal(t, "ds", rep.Function)
+	assert.Equal(t, "", rep.Namespace)
+	assert.Equal(t, `ds "foo"`, rep.Call)
+	assert.Equal(t, "foo", rep.Datasource)
+	assert.Equal(t, `template: in.tmpl:4:4: executing "in.tmpl" at <ds "foo">: error calling ds: Undefined datasource 'foo'
+ --> in.tmpl:4:5
+  |
+2 | 2
+3 | 3
+4 | 	{{ ds "foo" }}
+  | 	   ^
+5 | 5
+6 | 6
+  = function: ds
+  = call: ds "foo"
+  = datasource: foo`, rep.String())
+
+	// the alias is found in the message when it's not a constant
+	rep = render(`{{ $a := "bar" }}{{ datasource $a }}`)
+	assert.Equal(t, "datasource", rep.Function)
+	assert.Equal(t, "bar", rep.Datasource)
+
+	// errors in nested templates show the nested template's source
+	rep = render(`{{ template "t" }}`)
+	assert.Equal(t, "t", rep.Template)
+	assert.Equal(t, 2, rep.Line)
+	assert.Equal(t, "strings", rep.Namespace)
+	assert.Equal(t, "strings.Repeat", rep.Function)
+	assert.Equal(t, []SourceLine{{1, "one"}, {2, `{{ strings.Repeat -1 "a" }}`}}, rep.Source)
+	assert.Equal(t, "", rep.Datasource)
+
+	// the source shown is the one that was parsed, even if the file has
+	// changed since
+	_, err = r.Render(context.Background(), "in.tmpl", strings.NewReader(`{{ template "t" }}`), &bytes.Buffer{})
+	assert.Error(t, err)
+	_ = afero.WriteFile(fs, "/t.tmpl", []byte("changed"), 0644)
+	rep = ErrorReports(err)[0]
+	assert.Equal(t, []SourceLine{{1, "one"}, {2, `{{ strings.Repeat -1 "a" }}`}}, rep.Source)
+
+	// the column counts bytes, but the caret lines up by characters
+	rep = render(`héllo wörld {{ ds "foo" }}`)
+	assert.Equal(t, 18, rep.Column)
+	assert.Contains(t, rep.String(), "\n  | "+strings.Repeat(" ", 15)+"^\n")
+
+	// template actions aren't functions
+	rep = render(`{{ template "nope" }}`)
+	assert.Equal(t, `{{template "nope"}}`, rep.Call)
+	assert.Equal(t, "", rep.Function)
+
+	// syntax errors have no column
+	rep = render("{{ bogus }}")
+	assert.Equal(t, 1, rep.Line)
+	assert.Equal(t, 0, rep.Column)
+	assert.Equal(t, "bogus", rep.Function)
+	assert.Equal(t, `template: in.tmpl:1: function "bogus" not defined
+ --> in.tmpl:1
+  |
+1 | {{ bogus }}
+  = function: bogus`, rep.String())
+
+	errs := renderErrors{errors.New("foo"), errors.New("bar")}
+	assert.Equal(t, []ErrorReport{{Message: "foo"}, {Message: "bar"}}, ErrorReports(errs))
+}
+
+func TestCaretIndent(t *testing.T) {
+	assert.Equal(t, "", caretIndent("abc", 1))
+	assert.Equal(t, "  ", caretIndent("abc", 3))
+	assert.Equal(t, "\t ", caretIndent("\tabc", 3))
+	// "é" and "ö" are 2 bytes each, "日本" 3 bytes each
+	assert.Equal(t, "      ", caretIndent("héllo {{", 8))
+	assert.Equal(t, "   ", caretIndent("日本 x", 8))
+	assert.Equal(t, "    ", caretIndent("abcd", 10))
+}
+
+func TestWriteErrorReports(t *testing.T) {
+	err := renderErrors{
+		errors.New("foo"),
+		errors.New(`template: in:1:3: executing "in" at <.bar>: map has no entry for key "bar"`),
+	}
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, WriteErrorReports(out, err, "json"))
+	assert.Equal(t, `{"message":"foo"}
+{"message":"template: in:1:3: executing \"in\" at <.bar>: map has no entry for key \"bar\"","template":"in","line":1,"column":4,"call":".bar"}
+`, out.String())
+
+	out.Reset()
+	assert.NoError(t, WriteErrorReports(out, err, "text"))
+	assert.Equal(t, `foo
+template: in:1:3: executing "in" at <.bar>: map has no entry for key "bar"
+--> in:1:4
+ = call: .bar
+`, out.String())
+}
@@ -17,6 +17,11 @@ var fs = afero.NewOsFs()
 
 // Read -
 func Read(filename string) (string, error) {
+	return ReadFs(fs, filename)
+}
+
+// ReadFs - like Read, but reads from the given filesystem
+func ReadFs(fs afero.Fs, filename string) (string, error) {
 	inFile, err := fs.OpenFile(filename, os.O_RDONLY, 0)
 	if err != nil {
 		return "", errors.Wrapf(err, "failed to open %s", filename)
@@ -33,10 +38,17 @@ func Read(filename string) (string, error) {
 
 // ReadDir -
 func ReadDir(path string) ([]string, error) {
+	return ReadDirFs(fs, path)
+}
+
+// ReadDirFs - like ReadDir, but reads from the given filesystem
+func ReadDirFs(fs afero.Fs, path string) ([]string, error) {
 	f, err := fs.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	// nolint: errcheck
+	defer f.Close()
 	i, err := f.Stat()
 	if err != nil {
 		return nil, err
@@ -49,12 +61,17 @@ func ReadDir(path string) ([]string, error) {
 
 // Write a
 func Write(filename string, content []byte) error {
+	return WriteFs(fs, filename, content)
+}
+
+// WriteFs - like Write, but writes to the given filesystem
+func WriteFs(fs afero.Fs, filename string, content []byte) error {
 	err := assertPathInWD(filename)
 	if err != nil {
 		return errors.Wrapf(err, "failed to open %s", filename)
 	}
 
-	fi, err := os.Stat(filename)
+	fi, err := fs.Stat(filename)
 	if err != nil && !os.IsNotExist(err) {
 		return errors.Wrapf(err, "failed to stat %s", filename)
 	}
@@ -70,6 +87,8 @@ func Write(filename string, content []byte) error {
 	if err != nil {
 		return errors.Wrapf(err, "failed to open %s", filename)
 	}
+	// nolint: errcheck
+	defer inFile.Close()
 	n, err := inFile.Write(content)
 	if err != nil {
 		return errors.Wrapf(err, "failed to write %s", filename)
@@ -98,3 +117,44 @@ func assertPathInWD(filename string) error {
 	}
 	return nil
 }
+
+// InRoot - returns an error unless the path is within the root directory.
+// Relative paths are relative to the working directory. Symbolic links are
+// followed (where they exist), so they can't be used to reach files outside
+// the root.
+func InRoot(root, filename string) error {
+	r, err := resolvePath(root)
+	if err != nil {
+		return err
+	}
+	f, err := resolvePath(filename)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(r, f)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.Errorf("path %s is outside of %s", filename, root)
+	}
+	return nil
+}
+
+// resolvePath - the absolute path, with symbolic links followed. When the
+// path doesn't exist, links in its nearest existing parent are followed.
+func resolvePath(p string) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	if r, err := filepath.EvalSymlinks(p); err == nil {
+		return r, nil
+	}
+	dir, base := filepath.Split(p)
+	if dir = filepath.Clean(dir); dir == p {
+		return p, nil
+	}
+	dir, err = resolvePath(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, base), nil
+}
@@ -121,3 +121,28 @@ func TestAssertPathInWD(t *testing.T) {
 	err = assertPathInWD(filepath.Join("..", base))
 	assert.NoError(t, err)
 }
+
+func TestInRoot(t *testing.T) {
+	rootDir := tfs.NewDir(t, "gomplate-test",
+		tfs.WithDir("root", tfs.WithFile("foo", "foo")),
+		tfs.WithFile("secret", "shh"),
+	)
+	defer rootDir.Remove()
+	root := rootDir.Join("root")
+
+	assert.NoError(t, InRoot(root, root))
+	assert.NoError(t, InRoot(root, filepath.Join(root, "foo")))
+	assert.NoError(t, InRoot(root, filepath.Join(root, "new", "file")))
+	assert.NoError(t, InRoot(root, filepath.Join(root, "..foo")))
+	assert.Error(t, InRoot(root, rootDir.Join("secret")))
+	assert.Error(t, InRoot(root, filepath.Join(root, "..", "secret")))
+	assert.Error(t, InRoot(root, "/"))
+
+	// links can't escape the root
+	err := os.Symlink(rootDir.Join("secret"), filepath.Join(root, "link"))
+	assert.NoError(t, err)
+	assert.Error(t, InRoot(root, filepath.Join(root, "link")))
+	err = os.Symlink(rootDir.Path(), filepath.Join(root, "dirlink"))
+	assert.NoError(t, err)
+	assert.Error(t, InRoot(root, filepath.Join(root, "dirlink", "new")))
+}
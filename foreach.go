@@ -0,0 +1,157 @@
+package gomplate
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/hairyhenderson/gomplate/coll"
+	"github.com/hairyhenderson/gomplate/data"
+	"github.com/pkg/errors"
+)
+
+// forEachItems - read the items to render each template for, given a --foreach
+// spec: a datasource alias, optionally followed by a JSONPath expression
+// selecting the items within it (as in "tenants:.tenants[*]"). When the
+// selected value is an array, each of its elements is an item, otherwise the
+// value itself is the only item.
+func forEachItems(spec string, d *data.Data) ([]interface{}, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	v, err := d.Datasource(parts[0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read --foreach datasource")
+	}
+	if len(parts) > 1 {
+		v, err = coll.JSONPath(parts[1], v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to select --foreach items with %q", parts[1])
+		}
+	}
+	if a, ok := v.([]interface{}); ok {
+		return a, nil
+	}
+	return []interface{}{v}, nil
+}
+
+// itemContext - the context to render a template with for a --foreach item:
+// the given context, with the item added as .item
+func itemContext(tctx interface{}, item interface{}) (interface{}, error) {
+	if _, ok := ctxValues(tctx); !ok {
+		return nil, errors.New("--foreach can't be combined with a root context (\".\")")
+	}
+	return ctxLike(tctx, map[string]interface{}{"item": item}), nil
+}
+
+// gatherForEach - gather the input templates as usual, but with a template
+// for each input and item, with the output named by the output map (which can
+// refer to the item as .item)
+func (g *gomplate) gatherForEach(o *Config, items []interface{}) ([]*tplate, error) {
+	if o.OutputMap == "" {
+		return nil, errors.New("--output-map must be set when --foreach is set")
+	}
+	o.defaults()
+	mode, modeOverride, err := o.getMode()
+	if err != nil {
+		return nil, err
+	}
+
+	// the inputs are read once, and shared by each item's template
+	inputs := []*tplate{}
+	switch {
+	case o.Input != "":
+		inputs = append(inputs, &tplate{name: "<arg>", contents: o.Input, mode: mode})
+	case o.InputDir != "":
+		files, err := listDir(g.fs, o.InputDir, o.ExcludeGlob)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			t, err := fileToTemplates(g.fs, filepath.Join(o.InputDir, f), "", mode, modeOverride)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, t)
+		}
+	default:
+		for _, f := range o.InputFiles {
+			t, err := fileToTemplates(g.fs, f, "", mode, modeOverride)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, t)
+		}
+	}
+
+	templates := []*tplate{}
+	rendered := map[string]foreachOutput{}
+	for i, item := range items {
+		ictx, err := itemContext(g.tmplctx, item)
+		if err != nil {
+			return nil, err
+		}
+		namer := mappingNamer(o.OutputMap, g.fork(ictx))
+		for _, in := range inputs {
+			if err := in.loadContents(g.fs); err != nil {
+				return nil, err
+			}
+			// paths in an input dir are mapped relative to the dir, as usual
+			inPath := in.name
+			if o.InputDir != "" {
+				inPath, _ = filepath.Rel(o.InputDir, in.name)
+			}
+			outPath, err := namer(inPath)
+			if err != nil {
+				return nil, err
+			}
+			if err := g.fs.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+				return nil, err
+			}
+			t := &tplate{
+				name:             in.name,
+				contents:         in.contents,
+				frontMatterLines: in.frontMatterLines,
+				targetPath:       outPath,
+				mode:             in.mode,
+				modeOverride:     modeOverride,
+				skipUnchanged:    o.SkipUnchanged,
+				stdout:           g.stdout,
+				item:             item,
+				hasItem:          true,
+			}
+			if in.front != nil {
+				if err := t.applyFrontMatter(g.fs, in.front); err != nil {
+					return nil, err
+				}
+			}
+			if err := checkForEachOutput(rendered, t.targetPath, foreachOutput{in.name, i, item}); err != nil {
+				return nil, err
+			}
+			templates = append(templates, t)
+		}
+	}
+	return processTemplates(g.fs, templates)
+}
+
+// foreachOutput - the input and item an output is rendered from
+type foreachOutput struct {
+	in    string
+	index int
+	item  interface{}
+}
+
+// checkForEachOutput - record the output, failing when it's already rendered
+// from another item (or input), since one would silently overwrite the other
+func checkForEachOutput(rendered map[string]foreachOutput, outPath string, o foreachOutput) error {
+	if outPath == "-" {
+		return nil
+	}
+	p := filepath.Clean(outPath)
+	prev, ok := rendered[p]
+	if !ok {
+		rendered[p] = o
+		return nil
+	}
+	if prev.index == o.index {
+		return errors.Errorf("--foreach: %s and %s are both rendered to %s for item %d (%v)", prev.in, o.in, outPath, o.index, o.item)
+	}
+	return errors.Errorf("--foreach: items %d (%v) and %d (%v) are both rendered to %s - use .item in --output-map to give each item its own output", prev.index, prev.item, o.index, o.item, outPath)
+}
@@ -0,0 +1,114 @@
+package gomplate
+
+import (
+	"testing"
+
+	"github.com/hairyhenderson/gomplate/data"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForEachItems(t *testing.T) {
+	memfs := afero.NewMemMapFs()
+	_ = afero.WriteFile(memfs, "/tenants.json", []byte(`{"tenants": [{"name": "a"}, {"name": "b"}]}`), 0644)
+	_ = afero.WriteFile(memfs, "/list.json", []byte(`["x", "y"]`), 0644)
+	d, err := data.NewData([]string{"tenants=file:///tenants.json", "list=file:///list.json?type=application/array%2Bjson"}, nil)
+	assert.NoError(t, err)
+	d.Fs = memfs
+
+	items, err := forEachItems("list", d)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"x", "y"}, items)
+
+	items, err = forEachItems("tenants:.tenants", d)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "a"},
+		map[string]interface{}{"name": "b"},
+	}, items)
+
+	items, err = forEachItems("tenants:.tenants[*].name", d)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"a", "b"}, items)
+
+	// a single value is a single item
+	items, err = forEachItems("tenants:.tenants[0]", d)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "a"}}, items)
+
+	_, err = forEachItems("tenants:.bogus", d)
+	assert.Error(t, err)
+
+	_, err = forEachItems("nope", d)
+	assert.Error(t, err)
+}
+
+func TestItemContext(t *testing.T) {
+	c := &tmplctx{"foo": "bar"}
+	ictx, err := itemContext(c, 42)
+	assert.NoError(t, err)
+	assert.Equal(t, &tmplctx{"foo": "bar", "item": 42}, ictx)
+	assert.Equal(t, &tmplctx{"foo": "bar"}, c)
+
+	_, err = itemContext(map[string]interface{}{}, 42)
+	assert.Error(t, err)
+}
+
+func TestRunTemplatesForEach(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/data.yaml", []byte("tenants:\n- name: a\n  size: 1\n- name: b\n  size: 2\n"), 0644)
+	_ = afero.WriteFile(fs, "/in/deploy.yaml", []byte("name: {{ .item.name }}\nsize: {{ .item.size }}\nenv: {{ .config.env }}\n"), 0644)
+	_ = afero.WriteFile(fs, "/in/svc.yaml", []byte("svc: {{ .item.name }}\n"), 0644)
+	_ = afero.WriteFile(fs, "/config.json", []byte(`{"env": "prod"}`), 0644)
+
+	err := RunTemplates(&Config{
+		InputDir:    "/in",
+		OutputMap:   `/out/{{ .item.name }}/{{ .in }}`,
+		DataSources: []string{"data=file:///data.yaml"},
+		Contexts:    []string{"config=file:///config.json"},
+		ForEach:     "data:.tenants",
+	})
+	assert.NoError(t, err)
+	assertFile(t, "/out/a/deploy.yaml", "name: a\nsize: 1\nenv: prod\n")
+	assertFile(t, "/out/a/svc.yaml", "svc: a\n")
+	assertFile(t, "/out/b/deploy.yaml", "name: b\nsize: 2\nenv: prod\n")
+	assertFile(t, "/out/b/svc.yaml", "svc: b\n")
+
+	err = RunTemplates(&Config{
+		Input:       `{{ .item }}`,
+		OutputMap:   `/out/{{ .item }}.txt`,
+		DataSources: []string{"data=file:///data.yaml"},
+		ForEach:     "data:.tenants[*].name",
+	})
+	assert.NoError(t, err)
+	assertFile(t, "/out/a.txt", "a")
+	assertFile(t, "/out/b.txt", "b")
+
+	err = RunTemplates(&Config{
+		Input:       `{{ .item }}`,
+		DataSources: []string{"data=file:///data.yaml"},
+		ForEach:     "data:.tenants",
+	})
+	assert.Error(t, err)
+
+	// outputs can't collide, or one item's output would overwrite another's
+	_ = afero.WriteFile(fs, "/out/a.txt", []byte("old"), 0644)
+	err = RunTemplates(&Config{
+		Input:       `{{ .item }}`,
+		OutputMap:   `/out/a.txt`,
+		DataSources: []string{"data=file:///data.yaml"},
+		ForEach:     "data:.tenants[*].name",
+	})
+	assert.EqualError(t, err, "--foreach: items 0 (a) and 1 (b) are both rendered to /out/a.txt - use .item in --output-map to give each item its own output")
+	assertFile(t, "/out/a.txt", "old")
+
+	err = RunTemplates(&Config{
+		InputDir:    "/in",
+		OutputMap:   `/out/{{ .item.name }}.yaml`,
+		DataSources: []string{"data=file:///data.yaml"},
+		ForEach:     "data:.tenants",
+	})
+	assert.EqualError(t, err, "--foreach: /in/deploy.yaml and /in/svc.yaml are both rendered to /out/a.yaml for item 0 (map[name:a size:1])")
+}
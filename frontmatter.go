@@ -0,0 +1,239 @@
+package gomplate
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hairyhenderson/gomplate/conv"
+	"github.com/hairyhenderson/gomplate/data"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// the lines a front matter block starts and ends with. The opening line is
+// distinct from YAML's document separator, so that templates of multi-document
+// YAML (like Kubernetes manifests) are never mistaken for front matter.
+const (
+	frontMatterOpen  = "---gomplate"
+	frontMatterClose = "---"
+)
+
+// frontMatter - per-template settings, from a YAML block at the very start of
+// a template file, between "---gomplate" and "---" lines
+type frontMatter struct {
+	// Out - the output path, relative to the directory the output would
+	// otherwise be written to
+	Out   string `yaml:"out"`
+	Chmod string `yaml:"chmod"`
+
+	LDelim string `yaml:"leftDelim"`
+	RDelim string `yaml:"rightDelim"`
+
+	// Datasources - additional datasources, as alias: URL
+	Datasources map[string]string `yaml:"datasources"`
+	// Contexts - additional datasources to add to the template's context,
+	// as alias: URL
+	Contexts map[string]string `yaml:"context"`
+
+	// Skip - a template, rendered with the template's context, which skips
+	// rendering (leaving the output untouched) when it renders to "true"
+	Skip string `yaml:"skip"`
+}
+
+// splitFrontMatter - split the front matter block (if any) from the rest of
+// the template. Only the block's content is returned, without the delimiters.
+func splitFrontMatter(contents string) (front, body string, ok bool) {
+	lines := strings.SplitAfter(contents, "\n")
+	if strings.TrimRight(lines[0], "\r\n") != frontMatterOpen {
+		return "", contents, false
+	}
+	n := len(lines[0])
+	for _, line := range lines[1:] {
+		if strings.TrimRight(line, "\r\n") == frontMatterClose {
+			return contents[len(lines[0]):n], contents[n+len(line):], true
+		}
+		n += len(line)
+	}
+	return "", contents, false
+}
+
+// parseFrontMatter - strip the front matter block (if any) from the given
+// template, returning the parsed block (nil when there's none) and the rest
+// of the template.
+func parseFrontMatter(name, contents string) (*frontMatter, string, error) {
+	front, body, ok := splitFrontMatter(contents)
+	if !ok {
+		return nil, contents, nil
+	}
+	fm := &frontMatter{}
+	dec := yaml.NewDecoder(strings.NewReader(front))
+	dec.KnownFields(true)
+	err := dec.Decode(fm)
+	if err != nil && err != io.EOF {
+		return nil, "", errors.Wrapf(err, "failed to parse front matter in %s", name)
+	}
+	return fm, body, nil
+}
+
+// applyFrontMatter - apply the settings from the template's front matter
+func (t *tplate) applyFrontMatter(fs afero.Fs, fm *frontMatter) error {
+	if fm.Chmod != "" {
+		mode, _, err := (&Config{OutMode: fm.Chmod}).getMode()
+		if err != nil {
+			return errors.Wrapf(err, "invalid chmod in front matter in %s", t.name)
+		}
+		t.mode = mode
+		t.modeOverride = true
+	}
+	if fm.Out != "" {
+		// re-applying (when the template is reloaded) must start from the
+		// original path
+		if t.origTargetPath == "" {
+			t.origTargetPath = t.targetPath
+		}
+		t.targetPath = fm.Out
+		if !filepath.IsAbs(fm.Out) && t.origTargetPath != "-" {
+			t.targetPath = filepath.Join(filepath.Dir(t.origTargetPath), fm.Out)
+		}
+		if err := fs.MkdirAll(filepath.Dir(t.targetPath), 0755); err != nil {
+			return err
+		}
+	}
+	t.front = fm
+	return nil
+}
+
+// defines - whether the front matter defines the named datasource (or context)
+func (fm *frontMatter) defines(alias string) bool {
+	if fm == nil {
+		return false
+	}
+	_, ds := fm.Datasources[alias]
+	_, c := fm.Contexts[alias]
+	return ds || c
+}
+
+// delims - the delimiters to parse the template with
+func (t *tplate) delims(g *gomplate) (left, right string) {
+	left, right = g.leftDelim, g.rightDelim
+	if t.front != nil && t.front.LDelim != "" {
+		left = t.front.LDelim
+	}
+	if t.front != nil && t.front.RDelim != "" {
+		right = t.front.RDelim
+	}
+	return left, right
+}
+
+// frontMatterSources - the datasources defined in templates' front matter,
+// by alias. All templates share the same datasources, so an alias can't be
+// defined differently by different templates.
+type frontMatterSources struct {
+	mu      sync.Mutex
+	defined map[string]frontMatterSource
+}
+
+// frontMatterSource - a datasource defined in front matter, and the template
+// which first defined it
+type frontMatterSource struct {
+	url, template string
+}
+
+func newFrontMatterSources() *frontMatterSources {
+	return &frontMatterSources{defined: map[string]frontMatterSource{}}
+}
+
+// define - define the datasource, unless it's already defined (e.g. with
+// --datasource). Fails when another template's front matter defined the same
+// alias with a different URL.
+func (s *frontMatterSources) define(d *data.Data, tmpl, alias, u string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.defined[alias]; ok {
+		if prev.url != u {
+			return errors.Errorf("datasource %s is defined as %s in front matter in %s, and can't be redefined as %s in %s", alias, prev.url, prev.template, u, tmpl)
+		}
+		return nil
+	}
+	if d.DatasourceExists(alias) {
+		return nil
+	}
+	if _, err := d.DefineDatasource(alias, u); err != nil {
+		return errors.Wrapf(err, "failed to define datasource %s from front matter in %s", alias, tmpl)
+	}
+	s.defined[alias] = frontMatterSource{url: u, template: tmpl}
+	return nil
+}
+
+// defineFrontMatterSources - define the datasources and contexts named in the
+// template's front matter
+func (g *gomplate) defineFrontMatterSources(t *tplate) error {
+	fm := t.front
+	if fm == nil || (len(fm.Datasources) == 0 && len(fm.Contexts) == 0) {
+		return nil
+	}
+	if g.data == nil {
+		return errors.Errorf("datasources can't be defined in front matter in %s here", t.name)
+	}
+	for _, sources := range []map[string]string{fm.Datasources, fm.Contexts} {
+		for _, alias := range sortedKeys(sources) {
+			if err := g.frontSources.define(g.data, t.name, alias, sources[alias]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// frontMatterContext - define the datasources and contexts named in the
+// template's front matter, and add the contexts to the given context.
+// Datasources already defined (e.g. with --datasource) are left as they are.
+func (g *gomplate) frontMatterContext(t *tplate, tctx interface{}) (interface{}, error) {
+	if err := g.defineFrontMatterSources(t); err != nil {
+		return nil, err
+	}
+	fm := t.front
+	if fm == nil || len(fm.Contexts) == 0 {
+		return tctx, nil
+	}
+
+	if _, ok := ctxValues(tctx); !ok {
+		return nil, errors.Errorf("front matter contexts in %s can't be combined with a root context (\".\")", t.name)
+	}
+	values := make(map[string]interface{}, len(fm.Contexts))
+	for alias := range fm.Contexts {
+		v, err := g.data.Datasource(alias)
+		if err != nil {
+			return nil, err
+		}
+		values[alias] = v
+	}
+	return ctxLike(tctx, values), nil
+}
+
+// skipTemplate - whether the template's skip condition (if any) is true
+func (g *gomplate) skipTemplate(t *tplate, tctx interface{}) (bool, error) {
+	if t.front == nil || t.front.Skip == "" {
+		return false, nil
+	}
+	s := &tplate{
+		name:     t.name + " (skip)",
+		contents: t.front.Skip,
+		front:    t.front,
+		funcs:    t.funcs,
+	}
+	tmpl, err := s.toGoTemplate(g, tctx)
+	if err != nil {
+		return false, err
+	}
+	out := &bytes.Buffer{}
+	err = tmpl.Execute(out, tctx)
+	if err != nil {
+		return false, err
+	}
+	return conv.ToBool(strings.TrimSpace(out.String())), nil
+}
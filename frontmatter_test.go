@@ -0,0 +1,216 @@
+package gomplate
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	testdata := []struct {
+		in, front, body string
+		ok              bool
+	}{
+		{"", "", "", false},
+		{"hello", "", "hello", false},
+		{"---gomplate\nfoo: bar\n---\nhello", "foo: bar\n", "hello", true},
+		{"---gomplate\r\nfoo: bar\r\n---\r\nhello\r\n", "foo: bar\r\n", "hello\r\n", true},
+		{"---gomplate\n---\nhello", "", "hello", true},
+		{"---gomplate\nfoo: bar\n---", "foo: bar\n", "", true},
+		// not terminated
+		{"---gomplate\nfoo: bar\nhello", "", "---gomplate\nfoo: bar\nhello", false},
+		// not at the start
+		{"\n---gomplate\nfoo: bar\n---\nhello", "", "\n---gomplate\nfoo: bar\n---\nhello", false},
+		// a plain YAML document separator isn't front matter
+		{"---\nfoo: bar\n---\nhello", "", "---\nfoo: bar\n---\nhello", false},
+	}
+	for _, d := range testdata {
+		front, body, ok := splitFrontMatter(d.in)
+		assert.Equal(t, d.ok, ok, d.in)
+		assert.Equal(t, d.front, front, d.in)
+		assert.Equal(t, d.body, body, d.in)
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	fm, body, err := parseFrontMatter("t", "hello")
+	assert.NoError(t, err)
+	assert.Nil(t, fm)
+	assert.Equal(t, "hello", body)
+
+	fm, body, err = parseFrontMatter("t", `---gomplate
+out: foo.txt
+chmod: 0600
+leftDelim: '[['
+rightDelim: ']]'
+datasources:
+  foo: foo.json
+context:
+  bar: bar.json
+skip: true
+---
+hello`)
+	assert.NoError(t, err)
+	assert.Equal(t, &frontMatter{
+		Out:         "foo.txt",
+		Chmod:       "0600",
+		LDelim:      "[[",
+		RDelim:      "]]",
+		Datasources: map[string]string{"foo": "foo.json"},
+		Contexts:    map[string]string{"bar": "bar.json"},
+		Skip:        "true",
+	}, fm)
+	assert.Equal(t, "hello", body)
+
+	fm, body, err = parseFrontMatter("t", "---gomplate\n---\nhello")
+	assert.NoError(t, err)
+	assert.Equal(t, &frontMatter{}, fm)
+	assert.Equal(t, "hello", body)
+
+	// known keys with invalid values are errors
+	_, _, err = parseFrontMatter("t", "---gomplate\ndatasources: [foo]\n---\nhello")
+	assert.Error(t, err)
+	_, _, err = parseFrontMatter("t", "---gomplate\nkind: ConfigMap\n---\nhello")
+	assert.Error(t, err)
+
+	// multi-document YAML templates are left alone, even when their first
+	// document's keys are front matter keys
+	for _, in := range []string{
+		"---\napiVersion: v1\nkind: ConfigMap\n---\nkind: Secret\n",
+		"---\ncontext: prod\n---\nkind: ConfigMap\n",
+		"---\nskip: \"true\"\n---\nfoo: 1\n",
+		"---\nout: foo.txt\n---\nhello",
+	} {
+		fm, body, err = parseFrontMatter("t", in)
+		assert.NoError(t, err, in)
+		assert.Nil(t, fm, in)
+		assert.Equal(t, in, body, in)
+	}
+}
+
+func TestApplyFrontMatter(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	tp := &tplate{name: "in/foo.tmpl", targetPath: "out/foo.tmpl", mode: 0644}
+	err := tp.applyFrontMatter(fs, &frontMatter{Out: "sub/foo.txt", Chmod: "600"})
+	assert.NoError(t, err)
+	assert.Equal(t, "out/sub/foo.txt", tp.targetPath)
+	assert.Equal(t, os.FileMode(0600), tp.mode)
+	assert.True(t, tp.modeOverride)
+
+	// re-applying starts from the original path
+	err = tp.applyFrontMatter(fs, &frontMatter{Out: "sub/foo.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, "out/sub/foo.txt", tp.targetPath)
+
+	tp = &tplate{name: "foo.tmpl", targetPath: "-"}
+	err = tp.applyFrontMatter(fs, &frontMatter{Out: "foo.txt"})
+	assert.NoError(t, err)
+	assert.Equal(t, "foo.txt", tp.targetPath)
+
+	err = tp.applyFrontMatter(fs, &frontMatter{Chmod: "rwx"})
+	assert.Error(t, err)
+}
+
+func TestRunTemplatesFrontMatter(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/config.json", []byte(`{"name": "world", "enabled": false}`), 0644)
+	_ = afero.WriteFile(fs, "/in/plain.txt", []byte(`Hello, {{ .config.name }}`), 0644)
+	_ = afero.WriteFile(fs, "/in/chart.yaml", []byte(`---gomplate
+leftDelim: '[['
+rightDelim: ']]'
+---
+name: [[ .config.name ]]
+value: {{ .Values.foo }}
+`), 0644)
+	_ = afero.WriteFile(fs, "/in/secret.tmpl", []byte(`---gomplate
+out: secret.txt
+chmod: 0600
+context:
+  secrets: file:///secrets.json
+---
+{{ .secrets.password }}`), 0644)
+	_ = afero.WriteFile(fs, "/secrets.json", []byte(`{"password": "hunter2"}`), 0644)
+	manifests := `---
+apiVersion: v1
+kind: ConfigMap
+---
+apiVersion: v1
+kind: Secret
+`
+	_ = afero.WriteFile(fs, "/in/manifests.yaml", []byte(manifests), 0644)
+	_ = afero.WriteFile(fs, "/in/skipped.txt", []byte(`---gomplate
+skip: '{{ not .config.enabled }}'
+---
+nope`), 0644)
+
+	err := RunTemplates(&Config{
+		InputDir:  "/in",
+		OutputDir: "/out",
+		Contexts:  []string{"config=file:///config.json"},
+	})
+	assert.NoError(t, err)
+	assertFile(t, "/out/plain.txt", "Hello, world")
+	assertFile(t, "/out/chart.yaml", "name: world\nvalue: {{ .Values.foo }}\n")
+	assertFile(t, "/out/secret.txt", "hunter2")
+	assertFile(t, "/out/manifests.yaml", manifests)
+	fi, err := fs.Stat("/out/secret.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	_, err = fs.Stat("/out/secret.tmpl")
+	assert.True(t, os.IsNotExist(err))
+	_, err = fs.Stat("/out/skipped.txt")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFrontMatterErrorPositions(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/in.tmpl", []byte("---gomplate\nchmod: 644\n---\nfoo\n{{ bogus }}\n"), 0644)
+
+	err := RunTemplates(&Config{InputFiles: []string{"/in.tmpl"}, OutputFiles: []string{"/out"}})
+	assert.Error(t, err)
+	r := ErrorReports(err)[0]
+	assert.Equal(t, 5, r.Line)
+	assert.Contains(t, r.Message, "template: /in.tmpl:5:")
+	assert.Equal(t, []SourceLine{{Line: 4, Text: "foo"}, {Line: 5, Text: "{{ bogus }}"}}, r.Source)
+
+	problems, err := Lint(&Config{InputFiles: []string{"/in.tmpl"}})
+	assert.NoError(t, err)
+	assert.Len(t, problems, 1)
+	assert.Equal(t, 5, problems[0].Line)
+}
+
+func TestFrontMatterDatasourceConflicts(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/a.yaml", []byte("v: 1"), 0644)
+	_ = afero.WriteFile(fs, "/b.yaml", []byte("v: 2"), 0644)
+	_ = afero.WriteFile(fs, "/in/x.tmpl", []byte("---gomplate\ndatasources:\n  cfg: file:///a.yaml\n---\n{{ (ds \"cfg\").v }}"), 0644)
+	_ = afero.WriteFile(fs, "/in/y.tmpl", []byte("---gomplate\ndatasources:\n  cfg: file:///a.yaml\n---\n{{ (ds \"cfg\").v }}"), 0644)
+
+	// the same definition in several templates is fine
+	err := RunTemplates(&Config{InputDir: "/in", OutputDir: "/out"})
+	assert.NoError(t, err)
+	assertFile(t, "/out/x.tmpl", "1")
+	assertFile(t, "/out/y.tmpl", "1")
+
+	// a different one isn't
+	_ = afero.WriteFile(fs, "/in/y.tmpl", []byte("---gomplate\ndatasources:\n  cfg: file:///b.yaml\n---\n{{ (ds \"cfg\").v }}"), 0644)
+	err = RunTemplates(&Config{InputDir: "/in", OutputDir: "/out"})
+	assert.EqualError(t, err, "datasource cfg is defined as file:///a.yaml in front matter in /in/x.tmpl, and can't be redefined as file:///b.yaml in /in/y.tmpl")
+
+	// datasources defined with --datasource take precedence
+	err = RunTemplates(&Config{InputDir: "/in", OutputDir: "/out", DataSources: []string{"cfg=file:///b.yaml"}})
+	assert.NoError(t, err)
+	assertFile(t, "/out/x.tmpl", "2")
+	assertFile(t, "/out/y.tmpl", "2")
+}
@@ -10,24 +10,40 @@ import (
 // Funcs - The function mappings are defined here!
 func Funcs(d *data.Data) template.FuncMap {
 	f := template.FuncMap{}
-	funcs.AddDataFuncs(f, d)
-	funcs.AWSFuncs(f)
-	funcs.AddBase64Funcs(f)
-	funcs.AddNetFuncs(f)
-	funcs.AddReFuncs(f)
-	funcs.AddStringFuncs(f)
-	funcs.AddEnvFuncs(f)
-	funcs.AddConvFuncs(f)
-	funcs.AddTimeFuncs(f)
-	funcs.AddMathFuncs(f)
-	funcs.AddCryptoFuncs(f)
-	funcs.AddFileFuncs(f)
-	funcs.AddFilePathFuncs(f)
-	funcs.AddPathFuncs(f)
-	funcs.AddSockaddrFuncs(f)
-	funcs.AddTestFuncs(f)
-	funcs.AddCollFuncs(f)
-	funcs.AddUUIDFuncs(f)
-	funcs.AddRandomFuncs(f)
+	for _, ns := range namespaces(d) {
+		ns.add(f)
+	}
 	return f
 }
+
+// namespace - a named group of functions, and the function that adds them
+// (with their aliases) to a function map
+type namespace struct {
+	name string
+	add  func(map[string]interface{})
+}
+
+// namespaces - the built-in function namespaces
+func namespaces(d *data.Data) []namespace {
+	return []namespace{
+		{"data", func(f map[string]interface{}) { funcs.AddDataFuncs(f, d) }},
+		{"aws", funcs.AWSFuncs},
+		{"base64", funcs.AddBase64Funcs},
+		{"net", funcs.AddNetFuncs},
+		{"regexp", funcs.AddReFuncs},
+		{"strings", funcs.AddStringFuncs},
+		{"env", funcs.AddEnvFuncs},
+		{"conv", funcs.AddConvFuncs},
+		{"time", funcs.AddTimeFuncs},
+		{"math", funcs.AddMathFuncs},
+		{"crypto", funcs.AddCryptoFuncs},
+		{"file", funcs.AddFileFuncs},
+		{"filepath", funcs.AddFilePathFuncs},
+		{"path", funcs.AddPathFuncs},
+		{"sockaddr", funcs.AddSockaddrFuncs},
+		{"test", funcs.AddTestFuncs},
+		{"coll", funcs.AddCollFuncs},
+		{"uuid", funcs.AddUUIDFuncs},
+		{"random", funcs.AddRandomFuncs},
+	}
+}
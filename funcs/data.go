@@ -18,8 +18,18 @@ func DataNS() *DataFuncs {
 	return dataNS
 }
 
+// Datasources - the datasources the datasource functions read from, such as a
+// *data.Data, or a *data.View of one
+type Datasources interface {
+	Datasource(alias string, args ...string) (interface{}, error)
+	DatasourceExists(alias string) bool
+	DatasourceReachable(alias string, args ...string) bool
+	DefineDatasource(alias, value string) (string, error)
+	Include(alias string, args ...string) (string, error)
+}
+
 // AddDataFuncs -
-func AddDataFuncs(f map[string]interface{}, d *data.Data) {
+func AddDataFuncs(f map[string]interface{}, d Datasources) {
 	f["datasource"] = d.Datasource
 	f["ds"] = d.Datasource
 	f["datasourceExists"] = d.DatasourceExists
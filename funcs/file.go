@@ -16,7 +16,7 @@ var (
 
 // FileNS - the File namespace
 func FileNS() *FileFuncs {
-	ffInit.Do(func() { ff = &FileFuncs{afero.NewOsFs()} })
+	ffInit.Do(func() { ff = &FileFuncs{fs: afero.NewOsFs()} })
 	return ff
 }
 
@@ -25,18 +25,61 @@ func AddFileFuncs(f map[string]interface{}) {
 	f["file"] = FileNS
 }
 
+// AddFileFuncsFs - like AddFileFuncs, but files are read from (and written
+// to) the given filesystem, and onRead (if set) is called with the path of
+// each file (or directory) successfully read with file.Read or file.ReadDir
+func AddFileFuncsFs(f map[string]interface{}, fs afero.Fs, onRead func(path string)) {
+	ns := &FileFuncs{fs: fs, onRead: onRead}
+	f["file"] = func() *FileFuncs { return ns }
+}
+
+// AddSandboxedFileFuncs - like AddFileFuncsFs, but files outside the root
+// directory can't be read or written
+func AddSandboxedFileFuncs(f map[string]interface{}, fs afero.Fs, root string, onRead func(path string)) {
+	ns := &FileFuncs{fs: fs, onRead: onRead, root: root}
+	f["file"] = func() *FileFuncs { return ns }
+}
+
 // FileFuncs -
 type FileFuncs struct {
-	fs afero.Fs
+	fs     afero.Fs
+	onRead func(path string)
+	// root - if set, the directory all paths must be within
+	root string
+}
+
+// checkRoot - returns an error if the path is outside the root, if any
+func (f *FileFuncs) checkRoot(path string) error {
+	if f.root == "" {
+		return nil
+	}
+	return file.InRoot(f.root, path)
+}
+
+// recordRead - report a successful read to the onRead hook, if any
+func (f *FileFuncs) recordRead(path string) {
+	if f.onRead != nil {
+		f.onRead(path)
+	}
 }
 
 // Read -
 func (f *FileFuncs) Read(path interface{}) (string, error) {
-	return file.Read(conv.ToString(path))
+	if err := f.checkRoot(conv.ToString(path)); err != nil {
+		return "", err
+	}
+	s, err := file.ReadFs(f.fs, conv.ToString(path))
+	if err == nil {
+		f.recordRead(conv.ToString(path))
+	}
+	return s, err
 }
 
 // Stat -
 func (f *FileFuncs) Stat(path interface{}) (os.FileInfo, error) {
+	if err := f.checkRoot(conv.ToString(path)); err != nil {
+		return nil, err
+	}
 	return f.fs.Stat(conv.ToString(path))
 }
 
@@ -54,11 +97,21 @@ func (f *FileFuncs) IsDir(path interface{}) bool {
 
 // ReadDir -
 func (f *FileFuncs) ReadDir(path interface{}) ([]string, error) {
-	return file.ReadDir(conv.ToString(path))
+	if err := f.checkRoot(conv.ToString(path)); err != nil {
+		return nil, err
+	}
+	names, err := file.ReadDirFs(f.fs, conv.ToString(path))
+	if err == nil {
+		f.recordRead(conv.ToString(path))
+	}
+	return names, err
 }
 
 // Walk -
 func (f *FileFuncs) Walk(path interface{}) ([]string, error) {
+	if err := f.checkRoot(conv.ToString(path)); err != nil {
+		return nil, err
+	}
 	files := make([]string, 0)
 	err := afero.Walk(f.fs, conv.ToString(path), func(subpath string, finfo os.FileInfo, err error) error {
 		if err != nil {
@@ -72,10 +125,13 @@ func (f *FileFuncs) Walk(path interface{}) ([]string, error) {
 
 // Write -
 func (f *FileFuncs) Write(path interface{}, data interface{}) (s string, err error) {
+	if err := f.checkRoot(conv.ToString(path)); err != nil {
+		return "", err
+	}
 	if b, ok := data.([]byte); ok {
-		err = file.Write(conv.ToString(path), b)
+		err = file.WriteFs(f.fs, conv.ToString(path), b)
 	} else {
-		err = file.Write(conv.ToString(path), []byte(conv.ToString(data)))
+		err = file.WriteFs(f.fs, conv.ToString(path), []byte(conv.ToString(data)))
 	}
 	return "", err
 }
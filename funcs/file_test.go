@@ -10,7 +10,7 @@ import (
 
 func TestFileExists(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	ff := &FileFuncs{fs}
+	ff := &FileFuncs{fs: fs}
 
 	_ = fs.Mkdir("/tmp", 0777)
 	f, _ := fs.Create("/tmp/foo")
@@ -22,7 +22,7 @@ func TestFileExists(t *testing.T) {
 
 func TestFileIsDir(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	ff := &FileFuncs{fs}
+	ff := &FileFuncs{fs: fs}
 
 	_ = fs.Mkdir("/tmp", 0777)
 	f, _ := fs.Create("/tmp/foo")
@@ -34,7 +34,7 @@ func TestFileIsDir(t *testing.T) {
 
 func TestFileWalk(t *testing.T) {
 	fs := afero.NewMemMapFs()
-	ff := &FileFuncs{fs}
+	ff := &FileFuncs{fs: fs}
 
 	_ = fs.Mkdir("/tmp", 0777)
 	_ = fs.Mkdir("/tmp/bar", 0777)
@@ -53,3 +53,29 @@ func TestFileWalk(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, expectedPaths, actualPaths)
 }
+
+func TestFileRoot(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	ff := &FileFuncs{fs: fs, root: "/conf"}
+
+	_ = fs.Mkdir("/conf", 0777)
+	_, _ = fs.Create("/conf/foo")
+	_, _ = fs.Create("/secret")
+
+	assert.True(t, ff.Exists("/conf/foo"))
+	assert.False(t, ff.Exists("/secret"))
+	assert.False(t, ff.IsDir("/"))
+
+	_, err := ff.Read("/conf/../secret")
+	assert.Error(t, err)
+	_, err = ff.ReadDir("/")
+	assert.Error(t, err)
+	_, err = ff.Walk("/")
+	assert.Error(t, err)
+	_, err = ff.Write("/secret", "oops")
+	assert.Error(t, err)
+
+	files, err := ff.Walk("/conf")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.FromSlash("/conf"), filepath.FromSlash("/conf/foo")}, files)
+}
@@ -4,11 +4,12 @@ package gomplate
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -23,46 +24,180 @@ type gomplate struct {
 	leftDelim       string
 	rightDelim      string
 	nestedTemplates templateAliases
-	rootTemplate    *template.Template
 	tmplctx         interface{}
+
+	// the filesystem templates (including nested templates) are read from, and
+	// outputs are written to
+	fs afero.Fs
+	// where output to "-" is written
+	stdout io.WriteCloser
+	// metrics for all templates rendered by this gomplate
+	metrics *MetricsType
+	// records the files each output depends on - nil unless writing a depfile
+	deps *depTracker
+	// tracks the inputs of each output - nil unless --incremental is set
+	incr *incrTracker
+	// the directory extra outputs (written with out.Write) are relative to -
+	// empty when rendering with a Renderer, which has no outputs
+	outputDir string
+	// the hooks run on each output once it's written, with --post-render
+	postRender []*postRenderHook
+	// the outputs claimed by the templates being rendered (see
+	// renderTemplates)
+	claims *outputClaims
+	// the items each template is rendered for, with --foreach
+	items []interface{}
+	// datasources, for templates which define their own in front matter
+	data *data.Data
+	// how missing map keys are handled (see RenderOptions.MissingKey)
+	missingKey string
+	// the policy restricting what templates can do, if any
+	sandbox *Sandbox
+	// the datasources defined in templates' front matter
+	frontSources *frontMatterSources
+
+	// the template all templates are cloned from, with the nested templates
+	// parsed into it (see baseTemplate), and the nested templates' sources,
+	// by alias, for assembling layouts
+	base        *template.Template
+	baseSources map[string]string
+	baseErr     error
+	baseMu      sync.Mutex
 }
 
 // runTemplate -
 func (g *gomplate) runTemplate(t *tplate) error {
-	tmpl, err := t.toGoTemplate(g)
+	t.written = false
+	t.extra, t.extraPaths = nil, nil
+	tctx, err := g.templateContext(t)
+	if err == nil {
+		var skip bool
+		skip, err = g.skipTemplate(t, tctx)
+		if err == nil && skip {
+			return abortTarget(t.target)
+		}
+	}
+	if err == nil {
+		t.extra = newExtraOutputs(g.fs, g.outputDir, t)
+		t.extra.claims = g.claims
+		if len(g.postRender) > 0 {
+			t.extra.needsBackup = func(p string) bool { return len(g.hooksFor(p)) > 0 }
+		}
+		err = g.executeTemplate(t, tctx)
+	}
+	err = closeTarget(t.target, g.wrapTemplateError(t, err))
+	// extra outputs (from out.Write) are only written when the template's
+	// own output is
+	t.extraPaths, err = t.extra.commit(err)
+	t.written = err == nil && t.targetPath != "-" && !suppressed(t.target)
+	return err
+}
+
+// executeTemplate - parse and execute the template with the given context
+func (g *gomplate) executeTemplate(t *tplate, tctx interface{}) error {
+	tmpl, err := t.toGoTemplate(g, tctx)
 	if err != nil {
 		return err
 	}
+	err = tmpl.Execute(t.target, tctx)
+	if err != nil && g.missingKey == missingKeyStrict {
+		err = g.explainMissingKey(tmpl, err)
+	}
+	return err
+}
 
-	// nolint: gocritic
-	switch t.target.(type) {
-	case io.Closer:
-		if t.target != os.Stdout {
+// templateContext - the context to render the given template with. Templates
+// rendered for a --foreach item get the item too, and templates with front
+// matter get the contexts it names.
+func (g *gomplate) templateContext(t *tplate) (tctx interface{}, err error) {
+	if err = g.sandbox.checkOut(t); err != nil {
+		return nil, err
+	}
+	tctx = g.tmplctx
+	if t.hasItem {
+		tctx, err = itemContext(tctx, t.item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g.frontMatterContext(t, tctx)
+}
+
+// closeTarget - close the target once rendering is done. When rendering failed
+// the output is aborted instead (where possible), so that no partial output is
+// written.
+func closeTarget(target io.Writer, err error) error {
+	if target == os.Stdout {
+		return err
+	}
+	if err != nil {
+		if a, ok := target.(aborter); ok {
+			// nolint: errcheck
+			a.Abort()
+		} else if c, ok := target.(io.Closer); ok {
 			// nolint: errcheck
-			defer t.target.(io.Closer).Close()
+			c.Close()
+		}
+		return err
+	}
+	if c, ok := target.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// abortTarget - discard the output written to target so far, leaving the
+// output untouched, where possible
+func abortTarget(target io.Writer) error {
+	if a, ok := target.(aborter); ok {
+		return a.Abort()
+	}
+	return nil
+}
+
+// abortTargets - abort the targets of templates which won't be rendered
+func abortTargets(tmpl []*tplate) {
+	for _, t := range tmpl {
+		if t.target != nil {
+			// nolint: errcheck
+			abortTarget(t.target)
 		}
 	}
-	err = tmpl.Execute(t.target, g.tmplctx)
-	return err
 }
 
 type templateAliases map[string]string
 
 // newGomplate -
-func newGomplate(funcMap template.FuncMap, leftDelim, rightDelim string, nested templateAliases, tctx interface{}) *gomplate {
+func newGomplate(fs afero.Fs, funcMap template.FuncMap, leftDelim, rightDelim string, nested templateAliases, tctx interface{}) *gomplate {
 	return &gomplate{
 		leftDelim:       leftDelim,
 		rightDelim:      rightDelim,
 		funcMap:         funcMap,
 		nestedTemplates: nested,
 		tmplctx:         tctx,
+		fs:              fs,
+		metrics:         newMetrics(),
+		frontSources:    newFrontMatterSources(),
 	}
 }
 
-func parseTemplateArgs(templateArgs []string) (templateAliases, error) {
+// fork - a copy of this gomplate which renders with the given context.
+// Metrics and the (parsed) nested templates are shared.
+func (g *gomplate) fork(tctx interface{}) *gomplate {
+	f := newGomplate(g.fs, g.funcMap, g.leftDelim, g.rightDelim, g.nestedTemplates, tctx)
+	f.base, f.baseSources, f.baseErr = g.baseTemplate()
+	f.metrics = g.metrics
+	f.data = g.data
+	f.missingKey = g.missingKey
+	f.sandbox = g.sandbox
+	f.frontSources = g.frontSources
+	return f
+}
+
+func parseTemplateArgs(fs afero.Fs, templateArgs []string) (templateAliases, error) {
 	nested := templateAliases{}
 	for _, templateArg := range templateArgs {
-		err := parseTemplateArg(templateArg, nested)
+		err := parseTemplateArg(fs, templateArg, nested)
 		if err != nil {
 			return nil, err
 		}
@@ -70,32 +205,56 @@ func parseTemplateArgs(templateArgs []string) (templateAliases, error) {
 	return nested, nil
 }
 
-func parseTemplateArg(templateArg string, ta templateAliases) error {
+// parseTemplateArg - add the nested template(s) given by a --template
+// argument, in [alias=]path form, to ta. The path may be a file, a directory
+// (all files within it are added, recursively), a glob pattern (which may
+// include "**" to match any number of directories), or a URL. Templates in a
+// directory or matched by a glob are named by their path relative to the
+// directory (or the glob's fixed prefix), prefixed by the alias.
+func parseTemplateArg(fs afero.Fs, templateArg string, ta templateAliases) error {
 	parts := strings.SplitN(templateArg, "=", 2)
-	pth := parts[0]
+	pth := templateArg
 	alias := ""
-	if len(parts) > 1 {
+	// un-aliased URLs may contain '=' in their query strings
+	if len(parts) > 1 && !strings.Contains(parts[0], "://") {
 		alias = parts[0]
 		pth = parts[1]
 	}
 
+	if isNestedTemplateURL(pth) {
+		if isGitURL(pth) {
+			return errors.Errorf("nested templates can't be read from git repositories (%s)", pth)
+		}
+		if alias == "" {
+			alias = pth
+		}
+		ta[alias] = pth
+		return nil
+	}
+
+	// a path that exists is used as it is, even if it looks like a glob
+	// pattern (like "tmpl[1].t")
+	if _, err := fs.Stat(pth); err != nil && isGlob(pth) {
+		base, files, err := globFiles(fs, pth)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return errors.Errorf("no templates match %s", pth)
+		}
+		addNestedFiles(ta, alias, base, files)
+		return nil
+	}
+
 	switch fi, err := fs.Stat(pth); {
 	case err != nil:
 		return err
 	case fi.IsDir():
-		files, err := afero.ReadDir(fs, pth)
+		files, err := walkFiles(fs, pth, nil)
 		if err != nil {
 			return err
 		}
-		prefix := pth
-		if alias != "" {
-			prefix = alias
-		}
-		for _, f := range files {
-			if !f.IsDir() { // one-level only
-				ta[path.Join(prefix, f.Name())] = path.Join(pth, f.Name())
-			}
-		}
+		addNestedFiles(ta, alias, pth, files)
 	default:
 		if alias != "" {
 			ta[alias] = pth
@@ -109,55 +268,300 @@ func parseTemplateArg(templateArg string, ta templateAliases) error {
 // RunTemplates - run all gomplate templates specified by the given configuration
 func RunTemplates(o *Config) error {
 	Metrics = newMetrics()
-	defer runCleanupHooks()
 	// make sure config is sane
 	o.defaults()
-	ds := append(o.DataSources, o.Contexts...)
-	d, err := data.NewData(ds, o.DataSourceHeaders)
+	err := runConfig(o)
+	if o.MetricsFile != "" {
+		// metrics are written even when rendering failed, since failures are
+		// what they're most useful for
+		if merr := writeMetricsFile(fs, o.MetricsFile, Metrics, err); err == nil {
+			err = merr
+		}
+	}
+	return err
+}
+
+// runConfig - render the templates specified by the given configuration, in
+// the mode it selects
+func runConfig(o *Config) error {
+	afs, finish, err := useArchives(fs, o)
 	if err != nil {
 		return err
 	}
-	addCleanupHook(d.Cleanup)
-	nested, err := parseTemplateArgs(o.Templates)
+	err = runConfigFs(afs, o)
+	// an output archive is only written once everything has rendered
+	if ferr := finish(err == nil && !o.Diff && !o.Check); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// runConfigFs - render the templates specified by the given configuration,
+// reading and writing through the given filesystem (see useArchives)
+func runConfigFs(fs afero.Fs, o *Config) error {
+	sandbox, err := parseSandbox(o.Sandbox)
 	if err != nil {
 		return err
 	}
-	c, err := createTmplContext(o.Contexts, d)
+	hooks, err := parsePostRenderHooks(o.PostRender, o.LDelim, o.RDelim)
 	if err != nil {
 		return err
 	}
-	funcMap := Funcs(d)
-	err = bindPlugins(o.Plugins, funcMap)
+	// --watch uses the files each output read to decide what to re-render
+	var deps *depTracker
+	if o.DepFile != "" || o.Watch {
+		deps = newDepTracker()
+	}
+	// --incremental doesn't apply when nothing is written
+	var incr *incrTracker
+	var onSourceRead func(data.SourceRead)
+	if o.Incremental != "" && !o.Diff && !o.Check && !o.Watch {
+		incr = newIncrTracker(fs, o.Incremental, configHash(o))
+		onSourceRead = incr.recordSource
+	}
+	var onFileRead func(string)
+	if deps != nil || incr != nil {
+		onFileRead = func(path string) {
+			if deps != nil {
+				deps.record(path)
+			}
+			if incr != nil {
+				incr.recordFile(path)
+			}
+		}
+	}
+	// nothing may be written in a dry run, except the rendered outputs (which
+	// go to memory, see dryRun)
+	if o.Diff || o.Check {
+		fs = &dryRunFs{afero.NewReadOnlyFs(fs)}
+	}
+	r, err := NewRenderer(RenderOptions{
+		Datasources:       o.DataSources,
+		DatasourceHeaders: o.DataSourceHeaders,
+		Contexts:          o.Contexts,
+		Templates:         o.Templates,
+		Plugins:           o.Plugins,
+		Fs:                fs,
+		OnFileRead:        onFileRead,
+		OnSourceRead:      onSourceRead,
+		LDelim:            o.LDelim,
+		RDelim:            o.RDelim,
+		MissingKey:        o.MissingKey,
+		Sandbox:           sandbox,
+	})
 	if err != nil {
 		return err
 	}
-	g := newGomplate(funcMap, o.LDelim, o.RDelim, nested, c)
+	// nolint: errcheck
+	defer r.Close()
+	g := r.g
+	Metrics = g.metrics
+	g.deps = deps
+	g.incr = incr
+	g.outputDir = o.OutputDir
+	// nothing is written with --diff or --check, so there's nothing to run
+	// the hooks on
+	if !o.Diff && !o.Check {
+		g.postRender = hooks
+	}
+	g.stdout = Stdout
+	// --exec-pipe redirects standard out to the out pipe
+	if o.Out != nil {
+		g.stdout = &nopWCloser{o.Out}
+	}
 
-	return g.runTemplates(o)
+	if o.ForEach != "" {
+		g.items, err = forEachItems(o.ForEach, r.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.Diff || o.Check {
+		denyPlugins(g.funcMap, r.plugins)
+		return g.dryRun(o, g.stdout)
+	}
+	if o.Watch {
+		return g.watch(o, r.data, interruptCh())
+	}
+	err = g.runTemplates(o)
+	if incr != nil {
+		// the manifest is written even when rendering failed, so that outputs
+		// which were rendered aren't rendered again
+		if werr := incr.write(); err == nil {
+			err = werr
+		}
+	}
+	if err != nil || deps == nil {
+		return err
+	}
+	return writeDepFile(fs, o.DepFile, deps)
+}
+
+// writeDepFile - write the depfile, replacing it atomically like any other
+// output
+func writeDepFile(fs afero.Fs, path string, deps *depTracker) error {
+	f, err := createOutFile(fs, path, 0644, false, false)
+	if err != nil {
+		return err
+	}
+	err = deps.write(f)
+	return closeTarget(f, err)
 }
 
 func (g *gomplate) runTemplates(o *Config) error {
-	start := time.Now()
-	tmpl, err := gatherTemplates(o, chooseNamer(o, g))
-	Metrics.GatherDuration = time.Since(start)
+	tmpl, err := g.gatherTemplates(o)
 	if err != nil {
-		Metrics.Errors++
 		return err
 	}
-	Metrics.TemplatesGathered = len(tmpl)
-	start = time.Now()
-	defer func() { Metrics.TotalRenderDuration = time.Since(start) }()
+	return g.renderTemplates(tmpl, o.Parallelism)
+}
+
+// gatherTemplates - gather the templates to render, recording metrics
+func (g *gomplate) gatherTemplates(o *Config) ([]*tplate, error) {
+	start := time.Now()
+	var tmpl []*tplate
+	var err error
+	if o.ForEach != "" {
+		tmpl, err = g.gatherForEach(o, g.items)
+	} else {
+		tmpl, err = gatherTemplates(g.fs, o, g.stdout, chooseNamer(o, g))
+	}
+	g.metrics.GatherDuration = time.Since(start)
+	if err != nil {
+		g.metrics.Errors++
+		return nil, err
+	}
+	g.metrics.TemplatesGathered = len(tmpl)
+	return tmpl, nil
+}
+
+// renderTemplates - render the given (already-gathered) templates.
+//
+// With a parallelism of 1 (or less), templates are rendered in order, stopping
+// at the first error. Otherwise, up to parallelism templates are rendered
+// concurrently, and all errors are returned. Templates written to stdout are
+// always rendered in order, so their output isn't interleaved.
+//
+// Each output may only be written by one template - nothing is rendered when
+// two templates have the same output, and an extra output (from out.Write)
+// fails when another template claimed it first.
+func (g *gomplate) renderTemplates(tmpl []*tplate, parallelism int) error {
+	start := time.Now()
+	defer func() { g.metrics.TotalRenderDuration = time.Since(start) }()
+	g.claims = newOutputClaims()
 	for _, t := range tmpl {
-		tstart := time.Now()
-		err := g.runTemplate(t)
-		Metrics.RenderDuration[t.name] = time.Since(tstart)
+		if owner, ok := g.claims.claim(t.targetPath, t.name); !ok {
+			abortTargets(tmpl)
+			return errors.Errorf("output %s is written by both %s and %s", t.targetPath, owner, t.name)
+		}
+	}
+	if parallelism <= 1 {
+		for i, t := range tmpl {
+			if err := g.renderTemplate(t); err != nil {
+				// the rest aren't rendered, so their temporary files must be
+				// removed
+				abortTargets(tmpl[i+1:])
+				return err
+			}
+		}
+		return nil
+	}
+
+	// errors are indexed by template, so they're reported in a stable order
+	errs := make([]error, len(tmpl))
+	work := make(chan int)
+	wg := &sync.WaitGroup{}
+	for n := 0; n < parallelism; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				errs[i] = g.renderTemplate(tmpl[i])
+			}
+		}()
+	}
+
+	stdoutTmpl := []int{}
+	for i, t := range tmpl {
+		if t.targetPath == "-" {
+			stdoutTmpl = append(stdoutTmpl, i)
+			continue
+		}
+		work <- i
+	}
+	close(work)
+	for _, i := range stdoutTmpl {
+		errs[i] = g.renderTemplate(tmpl[i])
+	}
+	wg.Wait()
+
+	return newRenderErrors(errs)
+}
+
+// renderTemplate - render a single template, recording metrics
+func (g *gomplate) renderTemplate(t *tplate) error {
+	if g.deps != nil && t.targetPath != "-" {
+		g.deps.begin(t.targetPath, g.templateDeps(t)...)
+		defer g.deps.end(t.targetPath)
+	}
+	if g.incr != nil && t.targetPath != "-" {
+		g.incr.begin(t.targetPath)
+		defer g.incr.end(t.targetPath)
+		if g.incr.unchanged(g, t) {
+			g.skipUnchangedInputs(t)
+			return abortTarget(t.target)
+		}
+	}
+	tstart := time.Now()
+	err := g.runTemplateHooked(t)
+	g.metrics.recordRender(t.name, time.Since(tstart), err)
+	g.metrics.recordExtraOutputs(len(t.extraPaths))
+	if g.incr != nil && t.targetPath != "-" {
+		g.incr.finish(g, t, err)
+	}
+	return err
+}
+
+// skipUnchangedInputs - skip rendering the template, since its inputs are
+// unchanged (with --incremental). The files it read last time are still
+// dependencies, when writing a depfile.
+func (g *gomplate) skipUnchangedInputs(t *tplate) {
+	e := g.incr.skip(t.targetPath)
+	if g.deps != nil {
+		for _, f := range e.Files {
+			g.deps.record(f.Path)
+		}
+	}
+	g.metrics.recordSkip()
+}
+
+// renderErrors - the errors from rendering several templates
+type renderErrors []error
+
+// newRenderErrors - returns nil when there are no (non-nil) errors
+func newRenderErrors(errs []error) error {
+	e := renderErrors{}
+	for _, err := range errs {
 		if err != nil {
-			Metrics.Errors++
-			return err
+			e = append(e, err)
 		}
-		Metrics.TemplatesProcessed++
 	}
-	return nil
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e renderErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors occurred:\n\t* %s", len(e), strings.Join(msgs, "\n\t* "))
 }
 
 func chooseNamer(o *Config, g *gomplate) func(string) (string, error) {
@@ -182,22 +586,18 @@ func mappingNamer(outMap string, g *gomplate) func(string) (string, error) {
 			contents: outMap,
 			target:   out,
 		}
-		tpl, err := t.toGoTemplate(g)
+		tpl, err := t.toGoTemplate(g, g.tmplctx)
 		if err != nil {
 			return "", err
 		}
-		tctx := &tmplctx{}
-		// nolint: gocritic
-		switch c := g.tmplctx.(type) {
-		case *tmplctx:
-			for k, v := range *c {
-				if k != "in" && k != "ctx" {
-					(*tctx)[k] = v
-				}
-			}
+		values, _ := ctxValues(g.tmplctx)
+		c := tmplctx{}
+		for k, v := range values {
+			c[k] = v
 		}
-		(*tctx)["ctx"] = g.tmplctx
-		(*tctx)["in"] = inPath
+		c["ctx"] = g.tmplctx
+		c["in"] = inPath
+		tctx := g.sandbox.context(&c)
 
 		err = tpl.Execute(t.target, tctx)
 		if err != nil {
@@ -2,6 +2,7 @@ package gomplate
 
 import (
 	"bytes"
+	"fmt"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
@@ -171,6 +172,14 @@ func TestParseTemplateArg(t *testing.T) {
 	_ = fs.MkdirAll("dir", 0755)
 	afero.WriteFile(fs, "dir/foo.t", []byte("hi"), 0600)
 	afero.WriteFile(fs, "dir/bar.t", []byte("hi"), 0600)
+	_ = fs.MkdirAll("lib/forms/inputs", 0755)
+	afero.WriteFile(fs, "lib/base.tmpl", []byte("hi"), 0600)
+	afero.WriteFile(fs, "lib/forms/form.tmpl", []byte("hi"), 0600)
+	afero.WriteFile(fs, "lib/forms/inputs/text.tmpl", []byte("hi"), 0600)
+	afero.WriteFile(fs, "lib/forms/inputs/README", []byte("hi"), 0600)
+	afero.WriteFile(fs, "tmpl[1].t", []byte("hi"), 0600)
+	_ = fs.MkdirAll("v[2]/sub", 0755)
+	afero.WriteFile(fs, "v[2]/sub/a.t", []byte("hi"), 0600)
 
 	testdata := []struct {
 		arg      string
@@ -184,11 +193,44 @@ func TestParseTemplateArg(t *testing.T) {
 		{"foo=dir/foo.t", map[string]string{"foo": "dir/foo.t"}, false},
 		{"dir/", map[string]string{"dir/foo.t": "dir/foo.t", "dir/bar.t": "dir/bar.t"}, false},
 		{"t=dir/", map[string]string{"t/foo.t": "dir/foo.t", "t/bar.t": "dir/bar.t"}, false},
+		{"lib", map[string]string{
+			"lib/base.tmpl":              "lib/base.tmpl",
+			"lib/forms/form.tmpl":        "lib/forms/form.tmpl",
+			"lib/forms/inputs/text.tmpl": "lib/forms/inputs/text.tmpl",
+			"lib/forms/inputs/README":    "lib/forms/inputs/README",
+		}, false},
+		{"l=lib/forms", map[string]string{
+			"l/form.tmpl":        "lib/forms/form.tmpl",
+			"l/inputs/text.tmpl": "lib/forms/inputs/text.tmpl",
+			"l/inputs/README":    "lib/forms/inputs/README",
+		}, false},
+		{"lib/**/*.tmpl", map[string]string{
+			"lib/base.tmpl":              "lib/base.tmpl",
+			"lib/forms/form.tmpl":        "lib/forms/form.tmpl",
+			"lib/forms/inputs/text.tmpl": "lib/forms/inputs/text.tmpl",
+		}, false},
+		{"l=lib/*/*.tmpl", map[string]string{
+			"l/forms/form.tmpl": "lib/forms/form.tmpl",
+		}, false},
+		{"lib/*/inputs/*", map[string]string{
+			"lib/forms/inputs/text.tmpl": "lib/forms/inputs/text.tmpl",
+			"lib/forms/inputs/README":    "lib/forms/inputs/README",
+		}, false},
+		// paths that exist aren't globs, even with wildcard characters
+		{"tmpl[1].t", map[string]string{"tmpl[1].t": "tmpl[1].t"}, false},
+		{"t=tmpl[1].t", map[string]string{"t": "tmpl[1].t"}, false},
+		{"v=v[2]/**/*.t", map[string]string{"v/sub/a.t": "v[2]/sub/a.t"}, false},
+		{"lib/**/*.bogus", nil, true},
+		{"lib/[", nil, true},
+		{"https://example.com/t.tmpl", map[string]string{"https://example.com/t.tmpl": "https://example.com/t.tmpl"}, false},
+		{"t=https://example.com/t.tmpl?a=b", map[string]string{"t": "https://example.com/t.tmpl?a=b"}, false},
+		{"https://example.com/t.tmpl?a=b", map[string]string{"https://example.com/t.tmpl?a=b": "https://example.com/t.tmpl?a=b"}, false},
+		{"t=git+https://example.com/repo.git//t.tmpl", nil, true},
 	}
 
 	for _, d := range testdata {
 		nested := templateAliases{}
-		err := parseTemplateArg(d.arg, nested)
+		err := parseTemplateArg(fs, d.arg, nested)
 		if d.err {
 			assert.Error(t, err, d.arg)
 		} else {
@@ -222,11 +264,11 @@ func TestParseTemplateArgs(t *testing.T) {
 		"t/bar.t":   "dir/bar.t",
 	}
 
-	nested, err := parseTemplateArgs(args)
+	nested, err := parseTemplateArgs(fs, args)
 	assert.NoError(t, err)
 	assert.Equal(t, templateAliases(expected), nested)
 
-	_, err = parseTemplateArgs([]string{"bogus.t"})
+	_, err = parseTemplateArgs(fs, []string{"bogus.t"})
 	assert.Error(t, err)
 }
 
@@ -254,3 +296,100 @@ func TestMappingNamer(t *testing.T) {
 	expected = filepath.FromSlash("out/foofile")
 	assert.Equal(t, expected, out)
 }
+
+func TestRenderTemplatesConcurrently(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	g := newGomplate(fs, template.FuncMap{
+		"fail": func(s string) (string, error) { return "", fmt.Errorf("failed %s", s) },
+	}, "{{", "}}", nil, nil)
+	tmpl := []*tplate{}
+	for i := 0; i < 20; i++ {
+		contents := fmt.Sprintf("out %d", i)
+		if i%5 == 0 {
+			contents = fmt.Sprintf(`{{ fail "%d" }}`, i)
+		}
+		out, err := fs.Create(fmt.Sprintf("out%d", i))
+		assert.NoError(t, err)
+		tmpl = append(tmpl, &tplate{
+			name:       fmt.Sprintf("in%d", i),
+			targetPath: fmt.Sprintf("out%d", i),
+			contents:   contents,
+			target:     out,
+		})
+	}
+
+	err := g.renderTemplates(tmpl, 4)
+	assert.Error(t, err)
+	assert.Len(t, err, 4)
+	assert.Contains(t, err.Error(), "4 errors occurred")
+	assert.Contains(t, err.Error(), "failed 15")
+	assert.Equal(t, 16, g.metrics.TemplatesProcessed)
+	assert.Equal(t, 4, g.metrics.Errors)
+	assert.Len(t, g.metrics.RenderDuration, 20)
+
+	b, err := afero.ReadFile(fs, "out7")
+	assert.NoError(t, err)
+	assert.Equal(t, "out 7", string(b))
+}
+
+func TestRenderErrors(t *testing.T) {
+	assert.NoError(t, newRenderErrors(nil))
+	assert.NoError(t, newRenderErrors([]error{nil, nil}))
+
+	err := newRenderErrors([]error{nil, fmt.Errorf("foo")})
+	assert.EqualError(t, err, "foo")
+
+	err = newRenderErrors([]error{fmt.Errorf("foo"), nil, fmt.Errorf("bar")})
+	assert.EqualError(t, err, "2 errors occurred:\n\t* foo\n\t* bar")
+}
+
+func TestRunTemplateFailureKeepsOutput(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "out", []byte("previous"), 0644)
+
+	g := &gomplate{funcMap: template.FuncMap{
+		"fail": func() (string, error) { return "", fmt.Errorf("failed") },
+	}}
+	out, err := createOutFile(fs, "out", 0644, false, false)
+	assert.NoError(t, err)
+	err = g.runTemplate(&tplate{name: "in", contents: `partial{{ fail }}`, target: out})
+	assert.Error(t, err)
+	assertFile(t, "out", "previous")
+	files, err := afero.ReadDir(fs, ".")
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestRunTemplatesFailureLeavesNoTempFiles(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "in/a.txt", []byte("a"), 0644)
+	_ = afero.WriteFile(fs, "in/b.txt", []byte(`{{ fail "oops" }}`), 0644)
+	_ = afero.WriteFile(fs, "in/c.txt", []byte("c"), 0644)
+
+	err := RunTemplates(&Config{InputDir: "in", OutputDir: "out"})
+	assert.Error(t, err)
+	files, err := afero.ReadDir(fs, "out")
+	assert.NoError(t, err)
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	assert.Equal(t, []string{"a.txt"}, names)
+
+	// nor when gathering fails partway
+	_ = fs.RemoveAll("out")
+	_, err = processTemplates(fs, []*tplate{
+		{name: "in/a.txt", targetPath: "out/a.txt"},
+		{name: "in/missing.txt", targetPath: "out/missing.txt"},
+	})
+	assert.Error(t, err)
+	files, _ = afero.ReadDir(fs, "out")
+	assert.Empty(t, files)
+}
@@ -0,0 +1,387 @@
+package gomplate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hairyhenderson/gomplate/data"
+	"github.com/spf13/afero"
+)
+
+// manifestVersion - the version of the manifest format written by
+// --incremental. Manifests with other versions are ignored.
+const manifestVersion = 1
+
+// manifest - the hashes of each output's inputs, as of the run which last
+// rendered it
+type manifest struct {
+	Version int                       `json:"version"`
+	Outputs map[string]*manifestEntry `json:"outputs"`
+}
+
+// manifestEntry - the hashes of an output's inputs, and of the output itself
+// (so that outputs changed or removed since are rendered again)
+type manifestEntry struct {
+	Config      string       `json:"config"`
+	Template    string       `json:"template"`
+	Nested      string       `json:"nested"`
+	Item        string       `json:"item,omitempty"`
+	Output      string       `json:"output"`
+	Datasources []sourceHash `json:"datasources,omitempty"`
+	Files       []fileHash   `json:"files,omitempty"`
+	// Extra - the additional outputs written with out.Write
+	Extra []fileHash `json:"extra,omitempty"`
+}
+
+// sourceHash - the hash of the data read from a datasource
+type sourceHash struct {
+	Alias string   `json:"alias"`
+	URL   string   `json:"url"`
+	Args  []string `json:"args,omitempty"`
+	Hash  string   `json:"hash"`
+}
+
+// fileHash - the hash of a file read while rendering (for directories, the
+// hash of the names of the files in it)
+type fileHash struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+}
+
+// incrTracker - tracks the inputs of each output, for skipping outputs whose
+// inputs are unchanged since the last run (as with --incremental).
+//
+// Reads are attributed to outputs the same way as for depfiles (see
+// depTracker): reads made while several outputs are rendering are attributed
+// to all of them, and reads made while nothing is rendering (like context
+// datasources) to every output.
+type incrTracker struct {
+	fs     afero.Fs
+	path   string
+	config string
+	prev   map[string]*manifestEntry
+
+	nestedOnce sync.Once
+	nested     string
+	nestedErr  error
+
+	mu        sync.Mutex
+	common    outputReads
+	rendering map[string]bool
+	reads     map[string]*outputReads
+	entries   map[string]*manifestEntry
+}
+
+// outputReads - the datasources and files read while an output was rendering
+type outputReads struct {
+	sources []sourceHash
+	files   []string
+}
+
+// newIncrTracker - a tracker for the manifest at the given path, with the
+// entries from the last run (if the manifest can be read - otherwise every
+// output is rendered). The config hash covers the settings which affect
+// every output.
+func newIncrTracker(fs afero.Fs, path, config string) *incrTracker {
+	t := &incrTracker{
+		fs:        fs,
+		path:      path,
+		config:    config,
+		prev:      map[string]*manifestEntry{},
+		rendering: map[string]bool{},
+		reads:     map[string]*outputReads{},
+		entries:   map[string]*manifestEntry{},
+	}
+	b, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return t
+	}
+	m := manifest{}
+	if err := json.Unmarshal(b, &m); err == nil && m.Version == manifestVersion && m.Outputs != nil {
+		t.prev = m.Outputs
+	}
+	return t
+}
+
+// configHash - the hash of the settings in the config which affect how every
+// output is rendered
+func configHash(o *Config) string {
+	b, _ := json.Marshal([]interface{}{
+		o.DataSources, o.DataSourceHeaders, o.Contexts, o.Plugins, o.Templates,
+		o.LDelim, o.RDelim, o.OutMode, o.MissingKey, o.Sandbox, o.ForEach,
+		o.PostRender,
+	})
+	return hashBytes(b)
+}
+
+// recordFile - record that the given file was read. Safe for concurrent use.
+func (t *incrTracker) recordFile(path string) {
+	path = relToWd(path)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, r := range t.current() {
+		r.files = append(r.files, path)
+	}
+}
+
+// recordSource - record a read from a datasource. Failed reads aren't
+// recorded, since outputs which failed to render are never skipped. Safe for
+// concurrent use.
+func (t *incrTracker) recordSource(r data.SourceRead) {
+	if r.Err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, reads := range t.current() {
+		reads.sources = append(reads.sources, sourceHash{Alias: r.Alias, URL: r.URL, Args: r.Args, Hash: r.Hash})
+	}
+}
+
+// current - the reads that a read made now is attributed to
+func (t *incrTracker) current() []*outputReads {
+	if len(t.rendering) == 0 {
+		return []*outputReads{&t.common}
+	}
+	out := make([]*outputReads, 0, len(t.rendering))
+	for output := range t.rendering {
+		out = append(out, t.reads[output])
+	}
+	return out
+}
+
+// begin - start attributing reads to the given output
+func (t *incrTracker) begin(output string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reads[output] = &outputReads{
+		sources: append([]sourceHash{}, t.common.sources...),
+		files:   append([]string{}, t.common.files...),
+	}
+	t.rendering[output] = true
+}
+
+// end - stop attributing reads to the given output
+func (t *incrTracker) end(output string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.rendering, output)
+}
+
+// newEntry - the entry for the template's output, without the hashes of what
+// it read while rendering
+func (t *incrTracker) newEntry(g *gomplate, tmpl *tplate) (*manifestEntry, error) {
+	t.nestedOnce.Do(func() {
+		var sources map[string]string
+		_, sources, t.nestedErr = g.baseTemplate()
+		t.nested = hashSources(sources)
+	})
+	if t.nestedErr != nil {
+		return nil, t.nestedErr
+	}
+	front, err := json.Marshal(tmpl.front)
+	if err != nil {
+		return nil, err
+	}
+	e := &manifestEntry{
+		Config:   t.config,
+		Template: hashBytes([]byte(tmpl.contents + "\x00" + string(front))),
+		Nested:   t.nested,
+	}
+	if tmpl.hasItem {
+		item, err := json.Marshal(tmpl.item)
+		if err != nil {
+			return nil, err
+		}
+		e.Item = hashBytes(item)
+	}
+	return e, nil
+}
+
+// unchanged - whether the template's output, and all of its inputs, are
+// unchanged since the last run. Datasources the output read last time are
+// read again to check their hashes.
+func (t *incrTracker) unchanged(g *gomplate, tmpl *tplate) bool {
+	prev, ok := t.prev[tmpl.targetPath]
+	if !ok {
+		return false
+	}
+	e, err := t.newEntry(g, tmpl)
+	if err != nil || e.Config != prev.Config || e.Template != prev.Template ||
+		e.Nested != prev.Nested || e.Item != prev.Item {
+		return false
+	}
+	if hashFile(t.fs, tmpl.targetPath) != prev.Output {
+		return false
+	}
+	for _, f := range prev.Extra {
+		if hashFile(t.fs, f.Path) != f.Hash {
+			return false
+		}
+	}
+	for _, f := range prev.Files {
+		if hashFile(t.fs, f.Path) != f.Hash {
+			return false
+		}
+	}
+	// datasources defined in front matter aren't defined until the template
+	// is rendered
+	if err := g.defineFrontMatterSources(tmpl); err != nil {
+		return false
+	}
+	for _, s := range prev.Datasources {
+		if !g.data.DatasourceExists(s.Alias) {
+			if _, err := g.data.DefineDatasource(s.Alias, s.URL); err != nil {
+				return false
+			}
+		}
+		b, err := g.data.Include(s.Alias, s.Args...)
+		if err != nil || hashBytes([]byte(b)) != s.Hash {
+			return false
+		}
+	}
+	return true
+}
+
+// skip - keep the last run's entry for the output, which isn't rendered
+func (t *incrTracker) skip(output string) *manifestEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.prev[output]
+	t.entries[output] = e
+	return e
+}
+
+// finish - record the entry for the template's output, once it's rendered.
+// Outputs which failed to render (or weren't written) have no entry, so
+// they're always rendered next time.
+func (t *incrTracker) finish(g *gomplate, tmpl *tplate, renderErr error) {
+	var e *manifestEntry
+	if renderErr == nil {
+		e, _ = t.newEntry(g, tmpl)
+	}
+	t.mu.Lock()
+	reads := t.reads[tmpl.targetPath]
+	delete(t.reads, tmpl.targetPath)
+	t.mu.Unlock()
+
+	if e != nil && reads != nil {
+		e.Output = hashFile(t.fs, tmpl.targetPath)
+		e.Datasources = uniqueSources(reads.sources)
+		for _, f := range unique(reads.files) {
+			e.Files = append(e.Files, fileHash{Path: f, Hash: hashFile(t.fs, f)})
+		}
+		for _, p := range tmpl.extraPaths {
+			e.Extra = append(e.Extra, fileHash{Path: p, Hash: hashFile(t.fs, p)})
+		}
+	}
+
+	if e != nil && e.Output == "" {
+		e = nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries[tmpl.targetPath] = e
+}
+
+// write - write the manifest, replacing it atomically like any other output.
+// Outputs which weren't rendered in this run keep their entries from the last
+// run, since they're checked against their inputs anyway.
+func (t *incrTracker) write() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := manifest{Version: manifestVersion, Outputs: map[string]*manifestEntry{}}
+	for output, e := range t.prev {
+		m.Outputs[output] = e
+	}
+	for output, e := range t.entries {
+		if e == nil {
+			delete(m.Outputs, output)
+			continue
+		}
+		m.Outputs[output] = e
+	}
+	f, err := createOutFile(t.fs, t.path, 0644, false, false)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(m)
+	return closeTarget(f, err)
+}
+
+// uniqueSources - the given reads with duplicates removed, in order of first
+// appearance
+func uniqueSources(in []sourceHash) []sourceHash {
+	seen := map[string]bool{}
+	out := []sourceHash{}
+	for _, s := range in {
+		key := s.Alias + "\x00" + strings.Join(s.Args, "\x00")
+		if !seen[key] {
+			seen[key] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// hashSources - the hash of the given template sources (by name)
+func hashSources(sources map[string]string) string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name + "\x00" + sources[name] + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile - the hash of the file's content (or for a directory, the names of
+// the files in it). Empty when the file can't be read.
+func hashFile(fs afero.Fs, path string) string {
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if fi.IsDir() {
+		var names []string
+		names, err = readDirNames(fs, path)
+		if err != nil {
+			return ""
+		}
+		return hashBytes([]byte(strings.Join(names, "\x00")))
+	}
+	b, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return ""
+	}
+	return hashBytes(b)
+}
+
+// readDirNames - the sorted names of the files in the directory
+func readDirNames(fs afero.Fs, path string) ([]string, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// nolint: errcheck
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	sort.Strings(names)
+	return names, err
+}
+
+// hashBytes - the hex-encoded SHA-256 hash of b, as reported for datasource
+// reads
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,140 @@
+package gomplate
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncrTrackerManifest(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	// unreadable manifests are ignored
+	_ = afero.WriteFile(fs, "bad.json", []byte(`{`), 0644)
+	assert.Empty(t, newIncrTracker(fs, "bad.json", "c").prev)
+	_ = afero.WriteFile(fs, "old.json", []byte(`{"version": 0, "outputs": {"out": {}}}`), 0644)
+	assert.Empty(t, newIncrTracker(fs, "old.json", "c").prev)
+	assert.Empty(t, newIncrTracker(fs, "missing.json", "c").prev)
+
+	_ = afero.WriteFile(fs, "manifest.json", []byte(`{"version": 1, "outputs": {"out/a": {"output": "x"}, "out/b": {"output": "y"}}}`), 0644)
+	tr := newIncrTracker(fs, "manifest.json", "c")
+	assert.Len(t, tr.prev, 2)
+
+	tr.entries["out/a"] = &manifestEntry{Config: "c", Output: "z"}
+	tr.entries["out/b"] = nil
+	assert.NoError(t, tr.write())
+
+	b, _ := afero.ReadFile(fs, "manifest.json")
+	m := manifest{}
+	assert.NoError(t, json.Unmarshal(b, &m))
+	assert.Equal(t, manifest{
+		Version: 1,
+		Outputs: map[string]*manifestEntry{"out/a": {Config: "c", Output: "z"}},
+	}, m)
+}
+
+func TestIncrTrackerReads(t *testing.T) {
+	tr := newIncrTracker(fs, "manifest.json", "c")
+	tr.recordFile("ctx.json")
+
+	tr.begin("out/a")
+	tr.recordFile("a.json")
+	tr.begin("out/b")
+	tr.recordFile("shared")
+	tr.end("out/a")
+	tr.end("out/b")
+
+	assert.Equal(t, []string{"ctx.json", "a.json", "shared"}, tr.reads["out/a"].files)
+	assert.Equal(t, []string{"ctx.json", "shared"}, tr.reads["out/b"].files)
+
+	assert.Equal(t, []sourceHash{{Alias: "a", Hash: "1"}, {Alias: "a", Args: []string{"x"}, Hash: "2"}},
+		uniqueSources([]sourceHash{
+			{Alias: "a", Hash: "1"},
+			{Alias: "a", Args: []string{"x"}, Hash: "2"},
+			{Alias: "a", Hash: "1"},
+		}))
+}
+
+func TestRunTemplatesIncremental(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	_ = afero.WriteFile(fs, "in/a", []byte(`{{ (ds "data").a }}`), 0644)
+	_ = afero.WriteFile(fs, "in/b", []byte(`{{ template "t" }}`), 0644)
+	_ = afero.WriteFile(fs, "in/c", []byte(`{{ include "c" }}`), 0644)
+	_ = afero.WriteFile(fs, "t.tmpl", []byte(`t`), 0644)
+	_ = afero.WriteFile(fs, "/data.json", []byte(`{"a": "a"}`), 0644)
+	_ = afero.WriteFile(fs, "/c.txt", []byte(`c`), 0644)
+
+	run := func() *MetricsType {
+		err := RunTemplates(&Config{
+			InputDir:    "in",
+			OutputDir:   "out",
+			DataSources: []string{"data=file:///data.json", "c=file:///c.txt"},
+			Templates:   []string{"t=t.tmpl"},
+			Incremental: ".gomplate-cache.json",
+		})
+		assert.NoError(t, err)
+		return Metrics
+	}
+
+	m := run()
+	assert.Equal(t, 3, m.TemplatesProcessed)
+	assert.Equal(t, 0, m.TemplatesSkipped)
+	assertFile(t, "out/a", "a")
+	assertFile(t, "out/b", "t")
+	assertFile(t, "out/c", "c")
+
+	m = run()
+	assert.Equal(t, 0, m.TemplatesProcessed)
+	assert.Equal(t, 3, m.TemplatesSkipped)
+
+	// a changed datasource
+	_ = afero.WriteFile(fs, "/data.json", []byte(`{"a": "A"}`), 0644)
+	m = run()
+	assert.Equal(t, 1, m.TemplatesProcessed)
+	assertFile(t, "out/a", "A")
+
+	// a changed nested template affects every output
+	_ = afero.WriteFile(fs, "t.tmpl", []byte(`T`), 0644)
+	m = run()
+	assert.Equal(t, 3, m.TemplatesProcessed)
+	assertFile(t, "out/b", "T")
+
+	// a changed file, read with include
+	_ = afero.WriteFile(fs, "/c.txt", []byte(`C`), 0644)
+	m = run()
+	assert.Equal(t, 1, m.TemplatesProcessed)
+	assertFile(t, "out/c", "C")
+
+	// a changed template
+	_ = afero.WriteFile(fs, "in/b", []byte(`b{{ template "t" }}`), 0644)
+	m = run()
+	assert.Equal(t, 1, m.TemplatesProcessed)
+	assertFile(t, "out/b", "bT")
+
+	// a changed (or removed) output
+	_ = afero.WriteFile(fs, "out/a", []byte(`edited`), 0644)
+	_ = fs.Remove("out/c")
+	m = run()
+	assert.Equal(t, 2, m.TemplatesProcessed)
+	assertFile(t, "out/a", "A")
+	assertFile(t, "out/c", "C")
+
+	// changed settings
+	err := RunTemplates(&Config{
+		InputDir:    "in",
+		OutputDir:   "out",
+		DataSources: []string{"data=file:///data.json", "c=file:///c.txt"},
+		Templates:   []string{"t=t.tmpl"},
+		MissingKey:  "zero",
+		Incremental: ".gomplate-cache.json",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, Metrics.TemplatesProcessed)
+}
@@ -0,0 +1,73 @@
+package gomplate
+
+import (
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// templateExtends - the name of the layout the template extends, if it starts
+// with an extends comment, like {{/* extends "layouts/base.tmpl" */}}
+func templateExtends(src, ldelim, rdelim string) (string, bool) {
+	re := regexp.MustCompile(`^\s*` + regexp.QuoteMeta(ldelim) + `(?:- )?/\*\s*extends\s+"([^"]*)"\s*\*/(?: -)?` + regexp.QuoteMeta(rdelim))
+	m := re.FindStringSubmatch(src)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// layoutDepth - how many layouts the named nested template extends, directly
+// or indirectly
+func (g *gomplate) layoutDepth(sources map[string]string, name string) int {
+	depth := 0
+	// the limit stops cycles, which are reported when rendering
+	for depth < len(sources) {
+		layout, ok := templateExtends(sources[name], g.leftDelim, g.rightDelim)
+		if !ok {
+			break
+		}
+		name = layout
+		depth++
+	}
+	return depth
+}
+
+// assembleLayout - parse the chain of layouts the named template extends
+// (starting with the given layout) into tmpl's set, from the outermost layout
+// in. Each layout's blocks are overridden by those defined by the layouts
+// extending it, and finally by the template itself (which must be parsed
+// afterwards). Layouts are nested templates, and are parsed again from their
+// sources, since the base template holds only the last definition of each
+// block. Returns the name of the outermost layout, which is executed in place
+// of the template.
+func (g *gomplate) assembleLayout(tmpl *template.Template, sources map[string]string, name, layout string) (string, error) {
+	chain := []string{name}
+	for {
+		for _, n := range chain {
+			if n == layout {
+				return "", errors.Errorf("template %s: layouts can't extend each other in a cycle (%s -> %s)", name, strings.Join(chain, " -> "), layout)
+			}
+		}
+		src, ok := sources[layout]
+		if !ok {
+			return "", errors.Errorf("template %s: layout %q (extended by %s) not defined - layouts must be nested templates (see --template)", name, layout, chain[len(chain)-1])
+		}
+		chain = append(chain, layout)
+		next, ok := templateExtends(src, g.leftDelim, g.rightDelim)
+		if !ok {
+			break
+		}
+		layout = next
+	}
+
+	for i := len(chain) - 1; i > 0; i-- {
+		_, err := tmpl.New(chain[i]).Delims(g.leftDelim, g.rightDelim).Parse(sources[chain[i]])
+		if err != nil {
+			return "", err
+		}
+	}
+	return chain[len(chain)-1], nil
+}
@@ -0,0 +1,76 @@
+package gomplate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateExtends(t *testing.T) {
+	testdata := []struct {
+		in, ldelim, rdelim string
+		layout             string
+		ok                 bool
+	}{
+		{`{{/* extends "base" */}}`, "{{", "}}", "base", true},
+		{"\n  {{- /* extends \"layouts/base.tmpl\" */ -}}\nfoo", "{{", "}}", "layouts/base.tmpl", true},
+		{`{{/*extends "base"*/}}`, "{{", "}}", "base", true},
+		{`[[/* extends "base" */]]`, "[[", "]]", "base", true},
+		{`{{/* extends "base" */}}`, "[[", "]]", "", false},
+		{`foo {{/* extends "base" */}}`, "{{", "}}", "", false},
+		{`{{/* a comment */}}`, "{{", "}}", "", false},
+		{``, "{{", "}}", "", false},
+	}
+	for _, d := range testdata {
+		layout, ok := templateExtends(d.in, d.ldelim, d.rdelim)
+		assert.Equal(t, d.ok, ok, d.in)
+		assert.Equal(t, d.layout, layout, d.in)
+	}
+}
+
+func TestLayouts(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/base.t", []byte(`<{{ block "title" . }}untitled{{ end }}|{{ block "content" . }}empty{{ end }}|{{ block "footer" . }}base{{ end }}>`), 0644)
+	_ = afero.WriteFile(fs, "/page.t", []byte(`{{/* extends "base" */}}{{ define "content" }}[{{ block "main" . }}page{{ end }}]{{ end }}{{ define "footer" }}page{{ end }}`), 0644)
+	_ = afero.WriteFile(fs, "/loop1.t", []byte(`{{/* extends "loop2" */}}`), 0644)
+	_ = afero.WriteFile(fs, "/loop2.t", []byte(`{{/* extends "loop1" */}}`), 0644)
+
+	r, err := NewRenderer(RenderOptions{
+		Fs:        fs,
+		Templates: []string{"base=/base.t", "page=/page.t", "loop1=/loop1.t", "loop2=/loop2.t"},
+	})
+	assert.NoError(t, err)
+	defer r.Close()
+	render := func(in string) (string, error) {
+		out := &bytes.Buffer{}
+		_, err := r.Render(context.Background(), "in", strings.NewReader(in), out)
+		return out.String(), err
+	}
+
+	testdata := []struct {
+		in, out string
+	}{
+		{`{{/* extends "base" */}}{{ define "title" }}A{{ end }}`, "<A|empty|base>"},
+		{`{{/* extends "base" */}}{{ define "content" }}{{ "x" }}{{ end }}ignored`, "<untitled|x|base>"},
+		{`{{/* extends "page" */}}{{ define "title" }}B{{ end }}{{ define "main" }}b{{ end }}`, "<B|[b]|page>"},
+		{`{{/* extends "page" */}}{{ define "footer" }}C{{ end }}`, "<untitled|[page]|C>"},
+		// each template's blocks are only seen by that template
+		{`{{/* extends "base" */}}`, "<untitled|empty|base>"},
+		{`{{ template "base" }}`, "<untitled|empty|base>"},
+	}
+	for _, d := range testdata {
+		out, err := render(d.in)
+		assert.NoError(t, err, d.in)
+		assert.Equal(t, d.out, out, d.in)
+	}
+
+	_, err = render(`{{/* extends "nope" */}}`)
+	assert.EqualError(t, err, `template in: layout "nope" (extended by in) not defined - layouts must be nested templates (see --template)`)
+
+	_, err = render(`{{/* extends "loop1" */}}`)
+	assert.EqualError(t, err, `template in: layouts can't extend each other in a cycle (in -> loop1 -> loop2 -> loop1)`)
+}
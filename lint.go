@@ -0,0 +1,470 @@
+package gomplate
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/hairyhenderson/gomplate/data"
+)
+
+// LintProblem - a problem found in a template by Lint
+type LintProblem struct {
+	// Template - the template's name (usually its path)
+	Template string
+	// Line, Col - the position of the problem, starting at 1. The column is 0
+	// when it isn't known (as for syntax errors).
+	Line, Col int
+	Message   string
+}
+
+func (p LintProblem) String() string {
+	switch {
+	case p.Line == 0:
+		return fmt.Sprintf("%s: %s", p.Template, p.Message)
+	case p.Col == 0:
+		return fmt.Sprintf("%s:%d: %s", p.Template, p.Line, p.Message)
+	default:
+		return fmt.Sprintf("%s:%d:%d: %s", p.Template, p.Line, p.Col, p.Message)
+	}
+}
+
+// Lint - check the input and nested templates given by the config for
+// problems, without rendering them (or reading any datasources, other than
+// nested templates given as URLs). Templates are parsed with all functions
+// (including plugins) and the configured delimiters, and these problems are
+// reported:
+//
+//   - syntax errors
+//   - calls to undefined functions
+//   - ds, datasource, and include calls with datasource aliases that were never
+//     defined (with --datasource, --context, or defineDatasource)
+//   - template and tmpl.Exec calls with undefined template names
+//
+// An error is returned only when the templates can't be linted at all (for
+// example when an input file can't be read).
+func Lint(o *Config) ([]LintProblem, error) {
+	o.defaults()
+	inputs, err := lintInputs(o)
+	if err != nil {
+		return nil, err
+	}
+	l, err := newLinter(o)
+	if err != nil {
+		return nil, err
+	}
+
+	// nested templates are parsed first, since inputs refer to them
+	nestedPaths := []string{}
+	for _, p := range l.nested {
+		nestedPaths = append(nestedPaths, p)
+	}
+	sort.Strings(nestedPaths)
+	nested := []*lintTemplate{}
+	for _, p := range unique(nestedPaths) {
+		s, err := readNestedTemplate(fs, l.data, p)
+		if err != nil {
+			return nil, err
+		}
+		nested = append(nested, &lintTemplate{name: p, contents: s, nested: true})
+	}
+	// functions defined by nested templates can be called from any template
+	for alias := range l.nested {
+		if name, ok := userFuncName(alias); ok {
+			l.addUserFunc(l.funcMap, name)
+		}
+	}
+	for _, t := range nested {
+		for _, name := range userFuncNames(t.contents, l.ldelim) {
+			l.addUserFunc(l.funcMap, name)
+		}
+	}
+	for _, t := range nested {
+		l.parse(t)
+	}
+	for _, t := range inputs {
+		l.parse(t)
+	}
+
+	all := append(inputs, nested...)
+	for _, t := range all {
+		l.collectDefinitions(t)
+	}
+
+	problems := []LintProblem{}
+	for _, t := range all {
+		problems = append(problems, l.check(t)...)
+	}
+	return problems, nil
+}
+
+// lintTemplate - a template being linted
+type lintTemplate struct {
+	name     string
+	contents string
+	nested   bool
+
+	// front - the template's front matter, if any
+	front *frontMatter
+	// lineOffset - the number of lines of front matter stripped from the
+	// contents, added to reported lines
+	lineOffset int
+
+	// nil when the template couldn't be parsed
+	tmpl *template.Template
+	// problems found while parsing
+	problems []LintProblem
+	// functions used by the template that aren't defined
+	unknownFuncs map[string]bool
+}
+
+type linter struct {
+	funcMap        template.FuncMap
+	ldelim, rdelim string
+
+	// nested template aliases, mapped to paths
+	nested templateAliases
+	// template names defined by nested templates (with define or block)
+	nestedDefines map[string]bool
+	// datasource aliases defined with --datasource, --context, or
+	// defineDatasource
+	datasources map[string]bool
+	// for reading nested templates given as URLs
+	data *data.Data
+}
+
+func newLinter(o *Config) (*linter, error) {
+	ds := append(append([]string{}, o.DataSources...), o.Contexts...)
+	d, err := data.NewData(ds, o.DataSourceHeaders)
+	if err != nil {
+		return nil, err
+	}
+	funcMap := Funcs(d)
+	_, err = bindPlugins(o.Plugins, funcMap)
+	if err != nil {
+		return nil, err
+	}
+	addTmplFuncs(funcMap, template.New("lint"), nil)
+	addOutFuncs(funcMap, nil)
+
+	nested, err := parseTemplateArgs(fs, o.Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	datasources := map[string]bool{}
+	for _, a := range ds {
+		datasources[parseAlias(a)] = true
+	}
+
+	return &linter{
+		funcMap:       funcMap,
+		ldelim:        o.LDelim,
+		rdelim:        o.RDelim,
+		nested:        nested,
+		nestedDefines: map[string]bool{},
+		datasources:   datasources,
+		data:          d,
+	}, nil
+}
+
+// lintInputs - read the input templates, without opening any outputs
+func lintInputs(o *Config) ([]*lintTemplate, error) {
+	if o.Input != "" {
+		return []*lintTemplate{{name: "<arg>", contents: o.Input}}, nil
+	}
+
+	names := o.InputFiles
+	if o.InputDir != "" {
+		dir := filepath.Clean(o.InputDir)
+		files, err := listDir(fs, dir, o.ExcludeGlob)
+		if err != nil {
+			return nil, err
+		}
+		names = make([]string, len(files))
+		for i, f := range files {
+			names[i] = filepath.Join(dir, f)
+		}
+	}
+
+	inputs := make([]*lintTemplate, len(names))
+	for i, name := range names {
+		contents, err := readInput(fs, name)
+		if err != nil {
+			return nil, err
+		}
+		fm, body, err := parseFrontMatter(name, contents)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = &lintTemplate{
+			name:       name,
+			contents:   body,
+			front:      fm,
+			lineOffset: strings.Count(contents[:len(contents)-len(body)], "\n"),
+		}
+	}
+	return inputs, nil
+}
+
+var (
+	parseErrRe       = regexp.MustCompile(`(?s)^template: (.*):(\d+): (.*)$`)
+	unknownFuncErrRe = regexp.MustCompile(`^function "(.*)" not defined$`)
+)
+
+// parse - parse the template. Since parsing stops at the first undefined
+// function, each one is replaced with a stub and parsing is retried, so that
+// all of them can be found.
+func (l *linter) parse(t *lintTemplate) {
+	t.unknownFuncs = map[string]bool{}
+	stubs := template.FuncMap{}
+	ldelim, rdelim := l.ldelim, l.rdelim
+	if t.front != nil && t.front.LDelim != "" {
+		ldelim = t.front.LDelim
+	}
+	if t.front != nil && t.front.RDelim != "" {
+		rdelim = t.front.RDelim
+	}
+	for _, name := range userFuncNames(t.contents, ldelim) {
+		l.addUserFunc(stubs, name)
+	}
+	for {
+		tmpl := template.New(t.name).Funcs(l.funcMap).Funcs(stubs).Delims(ldelim, rdelim)
+		_, err := tmpl.Parse(t.contents)
+		if err == nil {
+			t.tmpl = tmpl
+			return
+		}
+
+		// undefined functions are only reported from here when there's also a
+		// syntax error - otherwise every call is found when checking
+		p := parseErrorProblem(t.name, err)
+		if p.Line > 0 {
+			p.Line += t.lineOffset
+		}
+		t.problems = append(t.problems, p)
+		if m := unknownFuncErrRe.FindStringSubmatch(p.Message); m != nil && !t.unknownFuncs[m[1]] {
+			t.unknownFuncs[m[1]] = true
+			stubs[m[1]] = lintStub
+			continue
+		}
+		return
+	}
+}
+
+// lintStub - stands in for functions that aren't called while linting
+func lintStub(...interface{}) string { return "" }
+
+// addUserFunc - add a stub for the user-defined function, unless the name is
+// taken (which is reported when rendering)
+func (l *linter) addUserFunc(funcs template.FuncMap, name string) {
+	if _, ok := l.funcMap[name]; !ok && userFuncNameRe.MatchString(name) {
+		funcs[name] = lintStub
+	}
+}
+
+// parseErrorProblem - convert a parse error (which only has a line number)
+// into a LintProblem
+func parseErrorProblem(name string, err error) LintProblem {
+	m := parseErrRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return LintProblem{Template: name, Message: err.Error()}
+	}
+	line, _ := strconv.Atoi(m[2])
+	return LintProblem{Template: name, Line: line, Message: m[3]}
+}
+
+// collectDefinitions - record the datasources and (for nested templates) the
+// template names the template defines
+func (l *linter) collectDefinitions(t *lintTemplate) {
+	if t.tmpl == nil {
+		return
+	}
+	for _, tmpl := range t.tmpl.Templates() {
+		if t.nested && tmpl.Name() != t.name {
+			l.nestedDefines[tmpl.Name()] = true
+		}
+		walkTree(tmpl.Tree, func(n parse.Node) {
+			if alias, _, ok := funcStringArg(n, "defineDatasource"); ok {
+				l.datasources[alias] = true
+			}
+		})
+	}
+}
+
+// check - find the problems in a parsed template
+func (l *linter) check(t *lintTemplate) []LintProblem {
+	if t.tmpl == nil {
+		return t.problems
+	}
+
+	known := map[string]bool{}
+	for alias := range l.nested {
+		known[alias] = true
+	}
+	for name := range l.nestedDefines {
+		known[name] = true
+	}
+	for _, tmpl := range t.tmpl.Templates() {
+		known[tmpl.Name()] = true
+	}
+
+	problems := []LintProblem{}
+	for _, tmpl := range t.tmpl.Templates() {
+		tree := tmpl.Tree
+		if tree == nil {
+			continue
+		}
+		walkTree(tree, func(n parse.Node) {
+			problem := func(n parse.Node, format string, args ...interface{}) {
+				line, col := nodePosition(tree, n)
+				problems = append(problems, LintProblem{
+					Template: t.name,
+					Line:     line + t.lineOffset,
+					Col:      col,
+					Message:  fmt.Sprintf(format, args...),
+				})
+			}
+
+			switch n := n.(type) {
+			case *parse.IdentifierNode:
+				if t.unknownFuncs[n.Ident] {
+					problem(n, "function %q not defined", n.Ident)
+				}
+			case *parse.TemplateNode:
+				if !known[n.Name] {
+					problem(n, "template %q not defined", n.Name)
+				}
+			}
+
+			for _, f := range []string{"ds", "datasource", "include"} {
+				if alias, arg, ok := funcStringArg(n, f); ok && !l.datasources[alias] && !t.front.defines(alias) && !isAbsURL(alias) {
+					problem(arg, "datasource %q not defined", alias)
+				}
+			}
+			if name, arg, ok := funcStringArg(n, "tmpl.Exec"); ok && !known[name] {
+				problem(arg, "template %q not defined", name)
+			}
+		})
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Col < problems[j].Col
+	})
+	return problems
+}
+
+// funcStringArg - when n is a call to the named function (which may be a
+// namespaced function like "tmpl.Exec") whose first argument is a string
+// constant, returns the string and its node
+func funcStringArg(n parse.Node, name string) (string, parse.Node, bool) {
+	cmd, ok := n.(*parse.CommandNode)
+	if !ok || len(cmd.Args) < 2 {
+		return "", nil, false
+	}
+	called := ""
+	switch f := cmd.Args[0].(type) {
+	case *parse.IdentifierNode:
+		called = f.Ident
+	case *parse.ChainNode:
+		if id, ok := f.Node.(*parse.IdentifierNode); ok {
+			called = id.Ident + "." + strings.Join(f.Field, ".")
+		}
+	}
+	if called != name {
+		return "", nil, false
+	}
+	s, ok := cmd.Args[1].(*parse.StringNode)
+	if !ok {
+		return "", nil, false
+	}
+	return s.Text, s, true
+}
+
+func isAbsURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs()
+}
+
+// nodePosition - the line and (1-based) column of the node in the tree
+func nodePosition(tree *parse.Tree, n parse.Node) (line, col int) {
+	loc, _ := tree.ErrorContext(n)
+	// the location is "name:line:col", and the name may contain colons
+	parts := strings.Split(loc, ":")
+	if len(parts) < 3 {
+		return 0, 0
+	}
+	line, _ = strconv.Atoi(parts[len(parts)-2])
+	col, _ = strconv.Atoi(parts[len(parts)-1])
+	return line, col + 1
+}
+
+// walkTree - call f for every node in the tree
+func walkTree(tree *parse.Tree, f func(parse.Node)) {
+	if tree != nil {
+		walkNode(tree.Root, f)
+	}
+}
+
+// nolint: gocyclo
+func walkNode(n parse.Node, f func(parse.Node)) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		f(n)
+		for _, c := range n.Nodes {
+			walkNode(c, f)
+		}
+	case *parse.ActionNode:
+		f(n)
+		walkNode(n.Pipe, f)
+	case *parse.IfNode:
+		f(n)
+		walkBranch(&n.BranchNode, f)
+	case *parse.RangeNode:
+		f(n)
+		walkBranch(&n.BranchNode, f)
+	case *parse.WithNode:
+		f(n)
+		walkBranch(&n.BranchNode, f)
+	case *parse.TemplateNode:
+		f(n)
+		walkNode(n.Pipe, f)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		f(n)
+		for _, c := range n.Cmds {
+			walkNode(c, f)
+		}
+	case *parse.CommandNode:
+		f(n)
+		for _, a := range n.Args {
+			walkNode(a, f)
+		}
+	case *parse.ChainNode:
+		f(n)
+		walkNode(n.Node, f)
+	case nil:
+	default:
+		f(n)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, f func(parse.Node)) {
+	walkNode(n.Pipe, f)
+	walkNode(n.List, f)
+	walkNode(n.ElseList, f)
+}
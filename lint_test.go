@@ -0,0 +1,59 @@
+package gomplate
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	_ = afero.WriteFile(fs, "in/ok.t", []byte(`{{ template "t" }}{{ template "part" }}{{ tmpl.Exec "t" }}{{ ds "foo" }}{{ include "https://example.com" }}`), 0644)
+	_ = afero.WriteFile(fs, "in/bad.t", []byte(`hello
+{{ bogus 1 }} {{ ds "nope" }}
+  {{ template "missing" }} {{ bogus }}{{ blah }}
+{{ tmpl.Exec "gone" . }}{{ defineDatasource "def" "file:///x.json" }}{{ include "def" }}`), 0644)
+	_ = afero.WriteFile(fs, "in/syntax.t", []byte("{{ bogus }}\n{{ if }}"), 0644)
+	_ = afero.WriteFile(fs, "t.tmpl", []byte(`{{ define "part" }}{{ ds "ctx" }}{{ end }}{{ unknown }}`), 0644)
+
+	problems, err := Lint(&Config{
+		InputDir:    "in",
+		DataSources: []string{"foo=file:///foo.json"},
+		Contexts:    []string{"ctx=file:///ctx.json"},
+		Templates:   []string{"t=t.tmpl"},
+	})
+	assert.NoError(t, err)
+	actual := []string{}
+	for _, p := range problems {
+		actual = append(actual, p.String())
+	}
+	assert.Equal(t, []string{
+		`in/bad.t:2:4: function "bogus" not defined`,
+		`in/bad.t:2:21: datasource "nope" not defined`,
+		`in/bad.t:3:15: template "missing" not defined`,
+		`in/bad.t:3:31: function "bogus" not defined`,
+		`in/bad.t:3:42: function "blah" not defined`,
+		`in/bad.t:4:14: template "gone" not defined`,
+		`in/syntax.t:1: function "bogus" not defined`,
+		`in/syntax.t:2: missing value for if`,
+		`t.tmpl:1:46: function "unknown" not defined`,
+	}, actual)
+
+	// custom delimiters
+	problems, err = Lint(&Config{Input: `[[ bogus ]] {{ bogus }}`, LDelim: "[[", RDelim: "]]"})
+	assert.NoError(t, err)
+	assert.Equal(t, []LintProblem{{Template: "<arg>", Line: 1, Col: 4, Message: `function "bogus" not defined`}}, problems)
+
+	_, err = Lint(&Config{InputFiles: []string{"missing.t"}, OutputFiles: []string{"-"}})
+	assert.Error(t, err)
+}
+
+func TestLintProblemString(t *testing.T) {
+	assert.Equal(t, "foo: bar", LintProblem{Template: "foo", Message: "bar"}.String())
+	assert.Equal(t, "foo:1: bar", LintProblem{Template: "foo", Line: 1, Message: "bar"}.String())
+	assert.Equal(t, "foo:1:2: bar", LintProblem{Template: "foo", Line: 1, Col: 2, Message: "bar"}.String())
+}
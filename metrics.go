@@ -1,6 +1,18 @@
 package gomplate
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hairyhenderson/gomplate/data"
+	"github.com/spf13/afero"
+)
 
 // Metrics tracks interesting basic metrics around gomplate executions. Warning: experimental!
 // This may change in breaking ways without warning. This is not subject to any semantic versioning guarantees!
@@ -11,14 +23,246 @@ var Metrics *MetricsType
 type MetricsType struct {
 	TemplatesGathered   int
 	TemplatesProcessed  int
+	TemplatesSkipped    int // templates not rendered, since their inputs were unchanged
+	ExtraOutputs        int // additional outputs written by templates, with out.Write
 	Errors              int
 	GatherDuration      time.Duration            // time it took to gather templates
 	TotalRenderDuration time.Duration            // time it took to render all templates
 	RenderDuration      map[string]time.Duration // times for rendering each template
+
+	// Datasources - metrics for reads from each datasource, by alias
+	Datasources map[string]*DatasourceMetrics
+
+	// guards the fields above while templates are rendered concurrently
+	mu sync.Mutex
+}
+
+// DatasourceMetrics - metrics for reads from a datasource. Warning:
+// experimental, like MetricsType!
+type DatasourceMetrics struct {
+	Reads        int           // reads from the datasource itself (not the cache)
+	Errors       int           // failed reads
+	CacheHits    int           // reads served from the cache
+	Bytes        int           // bytes read from the datasource (not the cache)
+	ReadDuration time.Duration // total time spent reading from the datasource
 }
 
 func newMetrics() *MetricsType {
 	return &MetricsType{
 		RenderDuration: make(map[string]time.Duration),
+		Datasources:    make(map[string]*DatasourceMetrics),
+	}
+}
+
+// recordRender - record the outcome of rendering a template. Safe for
+// concurrent use.
+func (m *MetricsType) recordRender(name string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.RenderDuration[name] = d
+	if err != nil {
+		m.Errors++
+	} else {
+		m.TemplatesProcessed++
+	}
+}
+
+// recordSkip - record that a template wasn't rendered, since its inputs were
+// unchanged (with --incremental). Safe for concurrent use.
+func (m *MetricsType) recordSkip() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.TemplatesSkipped++
+}
+
+// recordExtraOutputs - record the number of additional outputs a template
+// wrote with out.Write. Safe for concurrent use.
+func (m *MetricsType) recordExtraOutputs(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ExtraOutputs += n
+}
+
+// recordDatasourceRead - record a read from a datasource (as reported by
+// data.Data's OnSourceRead hook). Safe for concurrent use.
+func (m *MetricsType) recordDatasourceRead(r data.SourceRead) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ds, ok := m.Datasources[r.Alias]
+	if !ok {
+		ds = &DatasourceMetrics{}
+		m.Datasources[r.Alias] = ds
+	}
+	switch {
+	case r.Cached:
+		ds.CacheHits++
+	case r.Err != nil:
+		ds.Errors++
+		ds.ReadDuration += r.Duration
+	default:
+		ds.Reads++
+		ds.Bytes += r.Bytes
+		ds.ReadDuration += r.Duration
+	}
+}
+
+// metricsJSON - the form metrics are written in by writeMetricsJSON.
+// Durations are in seconds.
+type metricsJSON struct {
+	Timestamp          time.Time                        `json:"timestamp"`
+	Success            bool                             `json:"success"`
+	TemplatesGathered  int                              `json:"templatesGathered"`
+	TemplatesProcessed int                              `json:"templatesProcessed"`
+	TemplatesSkipped   int                              `json:"templatesSkipped"`
+	ExtraOutputs       int                              `json:"extraOutputs"`
+	Errors             int                              `json:"errors"`
+	GatherDuration     float64                          `json:"gatherDurationSeconds"`
+	RenderDuration     float64                          `json:"renderDurationSeconds"`
+	Templates          map[string]float64               `json:"templateRenderDurationSeconds"`
+	Datasources        map[string]datasourceMetricsJSON `json:"datasources"`
+}
+
+type datasourceMetricsJSON struct {
+	Reads        int     `json:"reads"`
+	Errors       int     `json:"errors"`
+	CacheHits    int     `json:"cacheHits"`
+	Bytes        int     `json:"bytes"`
+	ReadDuration float64 `json:"readDurationSeconds"`
+}
+
+// writeMetricsFile - write the metrics for a run which ended with the given
+// error (if any) to the given file, replacing it atomically. The metrics are
+// written in Prometheus text format (for node_exporter's textfile collector)
+// when the file name ends with ".prom", otherwise as JSON.
+func writeMetricsFile(fs afero.Fs, path string, m *MetricsType, runErr error) error {
+	f, err := createOutFile(fs, path, 0644, false, false)
+	if err != nil {
+		return err
 	}
+	if strings.HasSuffix(path, ".prom") {
+		err = writeMetricsProm(f, m, runErr, time.Now())
+	} else {
+		err = writeMetricsJSON(f, m, runErr, time.Now())
+	}
+	return closeTarget(f, err)
+}
+
+func writeMetricsJSON(w io.Writer, m *MetricsType, runErr error, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := metricsJSON{
+		Timestamp:          now.UTC(),
+		Success:            runErr == nil,
+		TemplatesGathered:  m.TemplatesGathered,
+		TemplatesProcessed: m.TemplatesProcessed,
+		TemplatesSkipped:   m.TemplatesSkipped,
+		ExtraOutputs:       m.ExtraOutputs,
+		Errors:             m.Errors,
+		GatherDuration:     m.GatherDuration.Seconds(),
+		RenderDuration:     m.TotalRenderDuration.Seconds(),
+		Templates:          map[string]float64{},
+		Datasources:        map[string]datasourceMetricsJSON{},
+	}
+	for name, d := range m.RenderDuration {
+		out.Templates[name] = d.Seconds()
+	}
+	for alias, ds := range m.Datasources {
+		out.Datasources[alias] = datasourceMetricsJSON{
+			Reads:        ds.Reads,
+			Errors:       ds.Errors,
+			CacheHits:    ds.CacheHits,
+			Bytes:        ds.Bytes,
+			ReadDuration: ds.ReadDuration.Seconds(),
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// writeMetricsProm - write the metrics in the Prometheus text exposition
+// format. Every metric is a gauge, since each run's metrics replace the last.
+func writeMetricsProm(w io.Writer, m *MetricsType, runErr error, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buf := &bytes.Buffer{}
+	gauge := func(name, help string) {
+		fmt.Fprintf(buf, "# HELP gomplate_%s %s\n# TYPE gomplate_%s gauge\n", name, help, name)
+	}
+	value := func(name, labels string, v interface{}) {
+		fmt.Fprintf(buf, "gomplate_%s%s %v\n", name, labels, v)
+	}
+
+	success := 0
+	if runErr == nil {
+		success = 1
+	}
+	gauge("success", "Whether the last run succeeded (1) or failed (0)")
+	value("success", "", success)
+	gauge("last_run_timestamp_seconds", "When the last run finished, as a Unix timestamp")
+	value("last_run_timestamp_seconds", "", now.Unix())
+	gauge("templates_gathered", "Number of templates gathered")
+	value("templates_gathered", "", m.TemplatesGathered)
+	gauge("templates_processed", "Number of templates rendered successfully")
+	value("templates_processed", "", m.TemplatesProcessed)
+	gauge("templates_skipped", "Number of templates not rendered, since their inputs were unchanged")
+	value("templates_skipped", "", m.TemplatesSkipped)
+	gauge("extra_outputs", "Number of additional outputs written by templates, with out.Write")
+	value("extra_outputs", "", m.ExtraOutputs)
+	gauge("errors", "Number of errors gathering or rendering templates")
+	value("errors", "", m.Errors)
+	gauge("gather_duration_seconds", "Time spent gathering templates")
+	value("gather_duration_seconds", "", m.GatherDuration.Seconds())
+	gauge("render_duration_seconds", "Time spent rendering all templates")
+	value("render_duration_seconds", "", m.TotalRenderDuration.Seconds())
+
+	names := make([]string, 0, len(m.RenderDuration))
+	for name := range m.RenderDuration {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		gauge("template_render_duration_seconds", "Time spent rendering each template")
+		for _, name := range names {
+			value("template_render_duration_seconds", promLabel("template", name), m.RenderDuration[name].Seconds())
+		}
+	}
+
+	aliases := make([]string, 0, len(m.Datasources))
+	for alias := range m.Datasources {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	dsMetrics := []struct {
+		name, help string
+		value      func(*DatasourceMetrics) interface{}
+	}{
+		{"datasource_reads", "Number of reads from each datasource (not including cache hits)",
+			func(ds *DatasourceMetrics) interface{} { return ds.Reads }},
+		{"datasource_read_errors", "Number of failed reads from each datasource",
+			func(ds *DatasourceMetrics) interface{} { return ds.Errors }},
+		{"datasource_cache_hits", "Number of reads from each datasource served from the cache",
+			func(ds *DatasourceMetrics) interface{} { return ds.CacheHits }},
+		{"datasource_read_bytes", "Number of bytes read from each datasource (not including cache hits)",
+			func(ds *DatasourceMetrics) interface{} { return ds.Bytes }},
+		{"datasource_read_duration_seconds", "Time spent reading from each datasource",
+			func(ds *DatasourceMetrics) interface{} { return ds.ReadDuration.Seconds() }},
+	}
+	if len(aliases) > 0 {
+		for _, dm := range dsMetrics {
+			gauge(dm.name, dm.help)
+			for _, alias := range aliases {
+				value(dm.name, promLabel("datasource", alias), dm.value(m.Datasources[alias]))
+			}
+		}
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// promLabel - a Prometheus label set with a single label
+func promLabel(name, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return fmt.Sprintf(`{%s="%s"}`, name, value)
 }
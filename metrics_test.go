@@ -0,0 +1,170 @@
+package gomplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hairyhenderson/gomplate/data"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordDatasourceRead(t *testing.T) {
+	m := newMetrics()
+	m.recordDatasourceRead(data.SourceRead{Alias: "foo", Duration: time.Second, Bytes: 10})
+	m.recordDatasourceRead(data.SourceRead{Alias: "foo", Bytes: 10, Cached: true})
+	m.recordDatasourceRead(data.SourceRead{Alias: "foo", Duration: time.Second, Err: errors.New("oops")})
+	m.recordDatasourceRead(data.SourceRead{Alias: "bar", Duration: time.Millisecond, Bytes: 3})
+
+	assert.Equal(t, map[string]*DatasourceMetrics{
+		"foo": {Reads: 1, Errors: 1, CacheHits: 1, Bytes: 10, ReadDuration: 2 * time.Second},
+		"bar": {Reads: 1, Bytes: 3, ReadDuration: time.Millisecond},
+	}, m.Datasources)
+}
+
+func testMetrics() *MetricsType {
+	m := newMetrics()
+	m.TemplatesGathered = 2
+	m.TemplatesProcessed = 1
+	m.Errors = 1
+	m.GatherDuration = 250 * time.Millisecond
+	m.TotalRenderDuration = 2 * time.Second
+	m.RenderDuration["in/b"] = time.Second
+	m.RenderDuration["in/a"] = 500 * time.Millisecond
+	m.Datasources["config"] = &DatasourceMetrics{Reads: 1, CacheHits: 2, Bytes: 42, ReadDuration: 5 * time.Millisecond}
+	return m
+}
+
+func TestWriteMetricsJSON(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	out := &bytes.Buffer{}
+	err := writeMetricsJSON(out, testMetrics(), errors.New("oops"), now)
+	assert.NoError(t, err)
+
+	expected := `{
+  "timestamp": "2020-01-02T03:04:05Z",
+  "success": false,
+  "templatesGathered": 2,
+  "templatesProcessed": 1,
+  "templatesSkipped": 0,
+  "extraOutputs": 0,
+  "errors": 1,
+  "gatherDurationSeconds": 0.25,
+  "renderDurationSeconds": 2,
+  "templateRenderDurationSeconds": {
+    "in/a": 0.5,
+    "in/b": 1
+  },
+  "datasources": {
+    "config": {
+      "reads": 1,
+      "errors": 0,
+      "cacheHits": 2,
+      "bytes": 42,
+      "readDurationSeconds": 0.005
+    }
+  }
+}
+`
+	assert.Equal(t, expected, out.String())
+}
+
+func TestWriteMetricsProm(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	out := &bytes.Buffer{}
+	err := writeMetricsProm(out, testMetrics(), nil, now)
+	assert.NoError(t, err)
+
+	expected := `# HELP gomplate_success Whether the last run succeeded (1) or failed (0)
+# TYPE gomplate_success gauge
+gomplate_success 1
+# HELP gomplate_last_run_timestamp_seconds When the last run finished, as a Unix timestamp
+# TYPE gomplate_last_run_timestamp_seconds gauge
+gomplate_last_run_timestamp_seconds 1577934245
+# HELP gomplate_templates_gathered Number of templates gathered
+# TYPE gomplate_templates_gathered gauge
+gomplate_templates_gathered 2
+# HELP gomplate_templates_processed Number of templates rendered successfully
+# TYPE gomplate_templates_processed gauge
+gomplate_templates_processed 1
+# HELP gomplate_templates_skipped Number of templates not rendered, since their inputs were unchanged
+# TYPE gomplate_templates_skipped gauge
+gomplate_templates_skipped 0
+# HELP gomplate_extra_outputs Number of additional outputs written by templates, with out.Write
+# TYPE gomplate_extra_outputs gauge
+gomplate_extra_outputs 0
+# HELP gomplate_errors Number of errors gathering or rendering templates
+# TYPE gomplate_errors gauge
+gomplate_errors 1
+# HELP gomplate_gather_duration_seconds Time spent gathering templates
+# TYPE gomplate_gather_duration_seconds gauge
+gomplate_gather_duration_seconds 0.25
+# HELP gomplate_render_duration_seconds Time spent rendering all templates
+# TYPE gomplate_render_duration_seconds gauge
+gomplate_render_duration_seconds 2
+# HELP gomplate_template_render_duration_seconds Time spent rendering each template
+# TYPE gomplate_template_render_duration_seconds gauge
+gomplate_template_render_duration_seconds{template="in/a"} 0.5
+gomplate_template_render_duration_seconds{template="in/b"} 1
+# HELP gomplate_datasource_reads Number of reads from each datasource (not including cache hits)
+# TYPE gomplate_datasource_reads gauge
+gomplate_datasource_reads{datasource="config"} 1
+# HELP gomplate_datasource_read_errors Number of failed reads from each datasource
+# TYPE gomplate_datasource_read_errors gauge
+gomplate_datasource_read_errors{datasource="config"} 0
+# HELP gomplate_datasource_cache_hits Number of reads from each datasource served from the cache
+# TYPE gomplate_datasource_cache_hits gauge
+gomplate_datasource_cache_hits{datasource="config"} 2
+# HELP gomplate_datasource_read_bytes Number of bytes read from each datasource (not including cache hits)
+# TYPE gomplate_datasource_read_bytes gauge
+gomplate_datasource_read_bytes{datasource="config"} 42
+# HELP gomplate_datasource_read_duration_seconds Time spent reading from each datasource
+# TYPE gomplate_datasource_read_duration_seconds gauge
+gomplate_datasource_read_duration_seconds{datasource="config"} 0.005
+`
+	assert.Equal(t, expected, out.String())
+
+	assert.Equal(t, `{template="a\"b\\c\nd"}`, promLabel("template", "a\"b\\c\nd"))
+}
+
+func TestRunTemplatesMetricsFile(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	_ = afero.WriteFile(fs, "/data.json", []byte(`{"a": "a"}`), 0644)
+
+	err := RunTemplates(&Config{
+		Input:       `{{ (ds "data").a }}{{ (ds "data").a }}`,
+		OutputFiles: []string{"out"},
+		DataSources: []string{"data=file:///data.json"},
+		MetricsFile: "metrics.json",
+	})
+	assert.NoError(t, err)
+	assertFile(t, "out", "aa")
+
+	b, err := afero.ReadFile(fs, "metrics.json")
+	assert.NoError(t, err)
+	m := metricsJSON{}
+	assert.NoError(t, json.Unmarshal(b, &m))
+	assert.True(t, m.Success)
+	assert.Equal(t, 1, m.TemplatesProcessed)
+	assert.Equal(t, 1, m.Datasources["data"].Reads)
+	assert.Equal(t, 1, m.Datasources["data"].CacheHits)
+	assert.Equal(t, 10, m.Datasources["data"].Bytes)
+
+	// metrics are written when rendering fails, too
+	err = RunTemplates(&Config{
+		Input:       `{{ fail }}`,
+		OutputFiles: []string{"out"},
+		MetricsFile: "metrics.prom",
+	})
+	assert.Error(t, err)
+	b, err = afero.ReadFile(fs, "metrics.prom")
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "gomplate_success 0\n")
+	assert.Contains(t, string(b), "gomplate_errors 1\n")
+}
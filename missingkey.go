@@ -0,0 +1,222 @@
+package gomplate
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+// The ways missing map keys (and nil values) can be handled (see
+// RenderOptions.MissingKey)
+const (
+	// missingKeyError - fail on missing keys (the default)
+	missingKeyError = "error"
+	// missingKeyZero - missing keys, and nil values, render as empty
+	missingKeyZero = "zero"
+	// missingKeyDefault - missing keys, and nil values, render as "<no value>"
+	// (text/template's default)
+	missingKeyDefault = "default"
+	// missingKeyStrict - fail on missing keys and nil values, reporting the
+	// full path of the missing key, and the datasource it came from
+	missingKeyStrict = "strict"
+)
+
+// nilValueFunc - the function added to the end of each output action's
+// pipeline in the "zero" and "strict" modes, to handle nil values
+const nilValueFunc = "__gomplate_nilValue"
+
+// missingKeyOption - the text/template missingkey option for the mode
+func missingKeyOption(mode string) (string, error) {
+	switch mode {
+	case "", missingKeyError, missingKeyStrict:
+		return "missingkey=error", nil
+	case missingKeyZero, missingKeyDefault:
+		// with "zero", missing values are rendered as empty by nilValueFunc
+		return "missingkey=default", nil
+	default:
+		return "", errors.Errorf("unsupported missing key mode %q - must be error, zero, default, or strict", mode)
+	}
+}
+
+// nilValueFuncFor - the nilValueFunc for the mode, if it needs one. It's
+// called with a description of the pipeline (and the datasource alias it reads
+// from, if any) and the pipeline's value.
+func (g *gomplate) nilValueFuncFor(mode string) interface{} {
+	switch mode {
+	case missingKeyZero:
+		return func(_, _ string, v interface{}) interface{} {
+			if v == nil {
+				return ""
+			}
+			return v
+		}
+	case missingKeyStrict:
+		return func(pipe, alias string, v interface{}) (interface{}, error) {
+			if v != nil {
+				return v, nil
+			}
+			if alias != "" && g.isDatasource(alias) {
+				return nil, errors.Errorf("%s is nil (from datasource '%s')", pipe, alias)
+			}
+			return nil, errors.Errorf("%s is nil", pipe)
+		}
+	}
+	return nil
+}
+
+// isDatasource - whether alias is a defined datasource (context aliases are)
+func (g *gomplate) isDatasource(alias string) bool {
+	return g.data != nil && g.data.DatasourceExists(alias)
+}
+
+// handleNilValues - add a nilValueFunc call to the end of each output action
+// in the given trees, so that nil values are handled by the missing key mode
+func handleNilValues(trees ...*parse.Tree) {
+	for _, tree := range trees {
+		walkTree(tree, func(n parse.Node) {
+			a, ok := n.(*parse.ActionNode)
+			if !ok || a.Pipe == nil || len(a.Pipe.Decl) > 0 || len(a.Pipe.Cmds) == 0 {
+				return
+			}
+			pos := a.Pipe.Position()
+			cmd := &parse.CommandNode{NodeType: parse.NodeCommand, Pos: pos}
+			cmd.Args = []parse.Node{
+				parse.NewIdentifier(nilValueFunc).SetTree(tree).SetPos(pos),
+				stringNode(pos, a.Pipe.String()),
+				stringNode(pos, pipeAlias(a.Pipe)),
+			}
+			a.Pipe.Cmds = append(a.Pipe.Cmds, cmd)
+		})
+	}
+}
+
+func stringNode(pos parse.Pos, s string) *parse.StringNode {
+	return &parse.StringNode{NodeType: parse.NodeString, Pos: pos, Quoted: strconv.Quote(s), Text: s}
+}
+
+// newTrees - the parse trees of the templates in tmpl's set which aren't in
+// base's (i.e. those just parsed)
+func newTrees(base, tmpl *template.Template) []*parse.Tree {
+	existing := map[*parse.Tree]bool{}
+	for _, t := range base.Templates() {
+		existing[t.Tree] = true
+	}
+	trees := []*parse.Tree{}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && !existing[t.Tree] {
+			trees = append(trees, t.Tree)
+		}
+	}
+	return trees
+}
+
+// pipeAlias - the datasource alias the pipeline reads from, if it can be
+// determined: the first field of a context value (like .config.foo), or the
+// argument of a ds/datasource call (like (ds "config").foo)
+func pipeAlias(p *parse.PipeNode) string {
+	if len(p.Cmds) == 0 || len(p.Cmds[0].Args) == 0 {
+		return ""
+	}
+	return nodeAlias(p.Cmds[0].Args[0])
+}
+
+func nodeAlias(n parse.Node) string {
+	switch n := n.(type) {
+	case *parse.FieldNode:
+		return n.Ident[0]
+	case *parse.ChainNode:
+		if p, ok := n.Node.(*parse.PipeNode); ok && len(p.Cmds) == 1 {
+			for _, f := range []string{"ds", "datasource"} {
+				if alias, _, ok := funcStringArg(p.Cmds[0], f); ok {
+					return alias
+				}
+			}
+		}
+	}
+	return ""
+}
+
+var (
+	missingKeyErrRe = regexp.MustCompile(`^template: (.+?):(\d+):(\d+): executing ".*?" at <.*?>: map has no entry for key "(.*?)"$`)
+	nilValueErrRe   = regexp.MustCompile(`(?s)^(template: .*? at <)` + nilValueFunc + ` .*>: error calling ` + nilValueFunc + `: ((.*) is nil.*)$`)
+)
+
+// explainMissingKey - in strict mode, add the full path of a missing key
+// (which text/template truncates), and the datasource it came from (when
+// known), to a missing key error. Errors from nil values are reworded, to
+// hide the nilValueFunc call.
+func (g *gomplate) explainMissingKey(tmpl *template.Template, err error) error {
+	if m := nilValueErrRe.FindStringSubmatch(err.Error()); m != nil {
+		return errors.New(m[1] + m[3] + ">: " + m[2])
+	}
+	m := missingKeyErrRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	t := tmpl.Lookup(m[1])
+	if t == nil || t.Tree == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(m[2])
+	col, _ := strconv.Atoi(m[3])
+	at := func(n parse.Node) bool {
+		l, c := nodePosition(t.Tree, n)
+		return l == line && c == col+1
+	}
+
+	var path, alias string
+	walkTree(t.Tree, func(n parse.Node) {
+		if path != "" {
+			return
+		}
+		// errors in a chain (like (ds "foo").bar) are reported at the
+		// last node evaluated within it
+		found := at(n)
+		if c, ok := n.(*parse.ChainNode); ok && !found {
+			walkNode(c.Node, func(n parse.Node) { found = found || at(n) })
+		}
+		if found {
+			path, alias = missingKeyPath(n, m[4])
+		}
+	})
+	switch {
+	case path == "":
+		return err
+	case alias != "" && g.isDatasource(alias):
+		return errors.Errorf("%s (missing %s, from datasource '%s')", err, path, alias)
+	default:
+		return errors.Errorf("%s (missing %s)", err, path)
+	}
+}
+
+// missingKeyPath - the path to the missing key in the (field, chain, or
+// variable) node, and the datasource alias it's from
+func missingKeyPath(n parse.Node, key string) (path, alias string) {
+	var prefix string
+	var fields []string
+	switch n := n.(type) {
+	case *parse.FieldNode:
+		fields = n.Ident
+	case *parse.ChainNode:
+		prefix = n.Node.String()
+		if _, ok := n.Node.(*parse.PipeNode); ok {
+			prefix = "(" + prefix + ")"
+		}
+		fields = n.Field
+	case *parse.VariableNode:
+		prefix = n.Ident[0]
+		fields = n.Ident[1:]
+	default:
+		return "", ""
+	}
+	for i, f := range fields {
+		if f == key {
+			return prefix + "." + strings.Join(fields[:i+1], "."), nodeAlias(n)
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,112 @@
+package gomplate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissingKeyOption(t *testing.T) {
+	for mode, expected := range map[string]string{
+		"":        "missingkey=error",
+		"error":   "missingkey=error",
+		"strict":  "missingkey=error",
+		"zero":    "missingkey=default",
+		"default": "missingkey=default",
+	} {
+		opt, err := missingKeyOption(mode)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, opt, mode)
+	}
+
+	_, err := missingKeyOption("bogus")
+	assert.Error(t, err)
+
+	_, err = NewRenderer(RenderOptions{Fs: afero.NewMemMapFs(), MissingKey: "bogus"})
+	assert.Error(t, err)
+}
+
+func TestMissingKeyModes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/config.json", []byte(`{"db": {"host": "db1", "port": null}}`), 0644)
+	_ = afero.WriteFile(fs, "/t.tmpl", []byte(`{{ define "port" }}{{ .config.db.port }}{{ end }}`), 0644)
+
+	render := func(mode, in string) (string, error) {
+		r, err := NewRenderer(RenderOptions{
+			Contexts:    []string{"config=file:///config.json"},
+			Datasources: []string{"other=file:///config.json"},
+			Templates:   []string{"t=/t.tmpl"},
+			Fs:          fs,
+			MissingKey:  mode,
+		})
+		assert.NoError(t, err)
+		defer r.Close()
+		out := &bytes.Buffer{}
+		_, err = r.Render(context.Background(), "in", strings.NewReader(in), out)
+		return out.String(), err
+	}
+
+	testdata := []struct {
+		mode, in, out, err string
+	}{
+		{"error", `{{ .config.db.host }}`, "db1", ""},
+		{"error", `{{ .config.db.user }}`, "", `map has no entry for key "user"`},
+		{"error", `{{ .config.db.port }}`, "<no value>", ""},
+
+		{"zero", `{{ .config.db.host }}`, "db1", ""},
+		{"zero", `[{{ .config.db.user }}]`, "[]", ""},
+		{"zero", `[{{ .config.nope.user }}]`, "[]", ""},
+		{"zero", `[{{ (ds "other").nope.user }}]`, "[]", ""},
+		{"zero", `[{{ .config.db.port }}]`, "[]", ""},
+		{"zero", `[{{ template "port" . }}]`, "[]", ""},
+		{"zero", `{{ $u := .config.db.user }}[{{ $u }}]`, "[]", ""},
+		{"zero", `{{ if .config.db.user }}yes{{ else }}no{{ end }}`, "no", ""},
+
+		{"default", `{{ .config.db.user }}`, "<no value>", ""},
+		{"default", `{{ .config.db.port }}`, "<no value>", ""},
+
+		{"strict", `{{ .config.db.host }}`, "db1", ""},
+		{"strict", `{{ .config.nope.user }}`, "",
+			`template: in:1:10: executing "in" at <.config.nope.user>: map has no entry for key "nope" (missing .config.nope, from datasource 'config')`},
+		{"strict", `{{ (ds "other").db.user.name }}`, "",
+			`map has no entry for key "user" (missing (ds "other").db.user, from datasource 'other')`},
+		{"strict", `{{ $db := .config.db }}{{ $db.user }}`, "",
+			`map has no entry for key "user" (missing $db.user)`},
+		{"strict", `{{ .config.db.port }}`, "",
+			`template: in:1:3: executing "in" at <.config.db.port>: .config.db.port is nil (from datasource 'config')`},
+		{"strict", `{{ template "port" . }}`, "",
+			`.config.db.port is nil (from datasource 'config')`},
+		{"strict", `{{ $p := .config.db.port }}{{ if $p }}{{ end }}ok`, "ok", ""},
+	}
+	for _, d := range testdata {
+		out, err := render(d.mode, d.in)
+		if d.err == "" {
+			assert.NoError(t, err, d.mode+": "+d.in)
+			assert.Equal(t, d.out, out, d.mode+": "+d.in)
+		} else if assert.Error(t, err, d.mode+": "+d.in) {
+			assert.Contains(t, err.Error(), d.err, d.mode+": "+d.in)
+		}
+	}
+}
+
+func TestMissingKeyErrorReport(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/config.json", []byte(`{"db": {}}`), 0644)
+	r, err := NewRenderer(RenderOptions{
+		Contexts:   []string{"config=file:///config.json"},
+		Fs:         fs,
+		MissingKey: "strict",
+	})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	_, err = r.Render(context.Background(), "in", strings.NewReader("{{ .config.db.host }}"), &bytes.Buffer{})
+	assert.Error(t, err)
+	reports := ErrorReports(err)
+	assert.Len(t, reports, 1)
+	assert.Equal(t, "config", reports[0].Datasource)
+}
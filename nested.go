@@ -0,0 +1,163 @@
+package gomplate
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hairyhenderson/gomplate/data"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// isNestedTemplateURL - whether the nested template path is a URL, to be read
+// like a datasource (single-letter schemes are Windows drive letters)
+func isNestedTemplateURL(p string) bool {
+	u, err := url.Parse(p)
+	return err == nil && len(u.Scheme) > 1
+}
+
+// isGitURL - whether the nested template URL names a git repository, which
+// nested templates can't be read from (there's no git datasource)
+func isGitURL(p string) bool {
+	u, err := url.Parse(p)
+	return err == nil && (u.Scheme == "git" || strings.HasPrefix(u.Scheme, "git+"))
+}
+
+// readNestedTemplate - read a nested template from a file, or (for URLs)
+// with d
+func readNestedTemplate(fs afero.Fs, d *data.Data, p string) (string, error) {
+	if !isNestedTemplateURL(p) {
+		b, err := afero.ReadFile(fs, p)
+		return string(b), err
+	}
+	if d == nil {
+		return "", errors.Errorf("can't read nested template %s", p)
+	}
+	s, err := d.Include(p)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read nested template %s", p)
+	}
+	return s, nil
+}
+
+// nestedTemplateFiles - the paths of the nested templates which are files
+// (and not URLs)
+func nestedTemplateFiles(ta templateAliases) []string {
+	files := []string{}
+	for _, p := range ta {
+		if !isNestedTemplateURL(p) {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// addNestedFiles - add files (relative to dir) to ta, named by their
+// slash-separated relative path prefixed by alias (or by dir, when there's no
+// alias)
+func addNestedFiles(ta templateAliases, alias, dir string, files []string) {
+	prefix := alias
+	if prefix == "" {
+		prefix = dir
+	}
+	for _, f := range files {
+		ta[path.Join(filepath.ToSlash(prefix), f)] = filepath.Join(dir, filepath.FromSlash(f))
+	}
+}
+
+// isGlob - whether the path contains glob wildcards
+func isGlob(p string) bool {
+	return strings.ContainsAny(filepath.ToSlash(p), "*?[")
+}
+
+// globFiles - the files matching the glob pattern, relative to the pattern's
+// fixed (non-wildcard) leading directories, which are returned as base.
+// Besides the usual path.Match syntax, a "**" path segment matches any number
+// of directories. Leading directories which exist are fixed, even if their
+// names contain wildcard characters.
+func globFiles(fs afero.Fs, pattern string) (base string, files []string, err error) {
+	segs := strings.Split(filepath.ToSlash(pattern), "/")
+	i := 0
+	for i < len(segs)-1 && (!isGlob(segs[i]) || isDir(fs, strings.Join(segs[:i+1], "/"))) {
+		i++
+	}
+	base = strings.Join(segs[:i], "/")
+	switch {
+	case base == "" && strings.HasPrefix(filepath.ToSlash(pattern), "/"):
+		base = "/"
+	case base == "":
+		base = "."
+	}
+	for _, s := range segs[i:] {
+		if _, err := path.Match(s, ""); err != nil {
+			return "", nil, errors.Wrapf(err, "invalid template pattern %s", pattern)
+		}
+	}
+	base = filepath.FromSlash(base)
+	if _, err := fs.Stat(base); os.IsNotExist(err) {
+		return base, nil, nil
+	}
+	files, err = walkFiles(fs, base, func(rel string) bool {
+		return matchSegments(segs[i:], strings.Split(rel, "/"))
+	})
+	return base, files, err
+}
+
+// isDir - whether the slash-separated path is an existing directory
+func isDir(fs afero.Fs, p string) bool {
+	if p == "" {
+		p = "/"
+	}
+	fi, err := fs.Stat(filepath.FromSlash(p))
+	return err == nil && fi.IsDir()
+}
+
+// matchSegments - whether the path segments match the pattern segments
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// walkFiles - the files in dir (recursively), as slash-separated paths
+// relative to dir, in lexical order. When match isn't nil, only files it
+// matches are included.
+func walkFiles(fs afero.Fs, dir string, match func(rel string) bool) ([]string, error) {
+	files := []string{}
+	err := afero.Walk(fs, dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if match == nil || match(rel) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,60 @@
+package gomplate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsNestedTemplateURL(t *testing.T) {
+	assert.True(t, isNestedTemplateURL("https://example.com/foo.t"))
+	assert.True(t, isNestedTemplateURL("git+https://example.com/repo//foo.t"))
+	assert.True(t, isNestedTemplateURL("s3://bucket/foo.t"))
+	assert.False(t, isNestedTemplateURL("foo.t"))
+	assert.False(t, isNestedTemplateURL("/tmp/foo.t"))
+	assert.False(t, isNestedTemplateURL(`C:\foo.t`))
+}
+
+func TestMatchSegments(t *testing.T) {
+	testdata := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"*.t", "foo.t", true},
+		{"*.t", "a/foo.t", false},
+		{"**/*.t", "foo.t", true},
+		{"**/*.t", "a/b/foo.t", true},
+		{"a/**/b/*.t", "a/b/foo.t", true},
+		{"a/**/b/*.t", "a/x/y/b/foo.t", true},
+		{"a/**/b/*.t", "a/x/y/c/foo.t", false},
+		{"**", "a/b/c", true},
+		{"a/*", "a/b/c", false},
+	}
+	for _, d := range testdata {
+		assert.Equal(t, d.match, matchSegments(strings.Split(d.pattern, "/"), strings.Split(d.name, "/")), "%s ~ %s", d.pattern, d.name)
+	}
+}
+
+func TestURLNestedTemplates(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(`Hello, {{ . }}!`))
+	}))
+	defer srv.Close()
+
+	err := RunTemplates(&Config{
+		Input:       `{{ template "hello" "world" }}`,
+		OutputFiles: []string{"/out"},
+		Templates:   []string{"hello=" + srv.URL + "/hello.tmpl"},
+	})
+	assert.NoError(t, err)
+	assertFile(t, "/out", "Hello, world!")
+}
@@ -0,0 +1,197 @@
+package gomplate
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/hairyhenderson/gomplate/conv"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// extraOutputs - the additional outputs a template writes with out.Write.
+// These are written like the template's own output (with the same mode, and
+// suppressed when empty with GOMPLATE_SUPPRESS_EMPTY), and only replace any
+// existing files once the template has rendered successfully.
+type extraOutputs struct {
+	fs  afero.Fs
+	dir string
+	t   *tplate
+
+	// the outputs claimed by all templates in the run, so that an extra
+	// output can't overwrite another template's output - nil when there are
+	// no other templates
+	claims *outputClaims
+
+	// whether an output needs to be backed up before it's replaced, so that
+	// it can be restored if a post-render hook fails - nil when there are no
+	// hooks
+	needsBackup func(path string) bool
+
+	mu      sync.Mutex
+	paths   []string
+	targets map[string]io.WriteCloser
+	backups map[string]*outputBackup
+}
+
+func newExtraOutputs(fs afero.Fs, dir string, t *tplate) *extraOutputs {
+	return &extraOutputs{
+		fs:      fs,
+		dir:     dir,
+		t:       t,
+		targets: map[string]io.WriteCloser{},
+		backups: map[string]*outputBackup{},
+	}
+}
+
+// outNS - the "out" namespace, bound to a template's extra outputs. These are
+// nil for templates which aren't rendered to an output (like --output-map
+// templates), so out.Write fails.
+type outNS struct {
+	o *extraOutputs
+}
+
+// addOutFuncs - add the "out" namespace to f, for writing extra outputs
+func addOutFuncs(f template.FuncMap, o *extraOutputs) {
+	ns := &outNS{o}
+	f["out"] = func() *outNS { return ns }
+}
+
+// Write - write content to the output file at the given path, relative to the
+// output directory. Returns an empty string, so it can be used in an action.
+func (f *outNS) Write(path string, content interface{}) (string, error) {
+	if f.o == nil {
+		return "", errors.New("out.Write can only be used in templates rendered to an output")
+	}
+	return "", f.o.write(path, conv.ToString(content))
+}
+
+// write - write the output, to a temporary file until the template has
+// rendered (see commit)
+func (o *extraOutputs) write(path, content string) error {
+	target, err := o.resolve(path)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.targets[target]; ok {
+		return errors.Errorf("out.Write: output %s was already written by this template", target)
+	}
+	if o.t.targetPath != "-" && filepath.Clean(o.t.targetPath) == target {
+		return errors.Errorf("out.Write: output %s is the template's own output", target)
+	}
+	if owner, ok := o.claims.claim(target, o.t.name); !ok {
+		return errors.Errorf("out.Write: output %s is also written by %s", target, owner)
+	}
+	if o.needsBackup != nil && o.needsBackup(target) {
+		b, err := backupOutput(o.fs, target)
+		if err != nil {
+			return err
+		}
+		o.backups[target] = b
+	}
+	if err = o.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	w, err := openOutFile(o.fs, target, nil, o.t.mode, o.t.modeOverride, o.t.skipUnchanged)
+	if err != nil {
+		return err
+	}
+	o.targets[target] = w
+	o.paths = append(o.paths, target)
+	_, err = io.WriteString(w, content)
+	return err
+}
+
+// backup - the output's contents before it was written, if it was backed up
+func (o *extraOutputs) backup(path string) *outputBackup {
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.backups[path]
+}
+
+// resolve - the path of the output, which must be in the output directory
+func (o *extraOutputs) resolve(path string) (string, error) {
+	if o.dir == "" {
+		return "", errors.New("out.Write: there is no output directory to write to")
+	}
+	if path == "" || filepath.IsAbs(path) {
+		return "", errors.Errorf("out.Write: invalid output path %q - must be relative to the output directory", path)
+	}
+	target := filepath.Join(o.dir, path)
+	rel, err := filepath.Rel(filepath.Clean(o.dir), target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("out.Write: invalid output path %q - must be in the output directory", path)
+	}
+	return target, nil
+}
+
+// commit - once the template has rendered, replace the outputs' files, or
+// (when rendering failed) discard them. Returns the paths written.
+func (o *extraOutputs) commit(renderErr error) ([]string, error) {
+	if o == nil {
+		return nil, renderErr
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	err := renderErr
+	written := []string{}
+	for _, p := range o.paths {
+		if err != nil {
+			// nolint: errcheck
+			closeTarget(o.targets[p], err)
+			continue
+		}
+		err = closeTarget(o.targets[p], nil)
+		// empty outputs may have been suppressed
+		if suppressed(o.targets[p]) {
+			continue
+		}
+		if err == nil {
+			written = append(written, p)
+		}
+	}
+	return written, err
+}
+
+// outputClaims - the output paths claimed by the templates rendered in a run
+// (their own outputs, and the extra outputs they write with out.Write), so
+// that no two templates write the same file
+type outputClaims struct {
+	mu sync.Mutex
+	// the name of the template which claimed each output, by absolute path
+	by map[string]string
+}
+
+func newOutputClaims() *outputClaims {
+	return &outputClaims{by: map[string]string{}}
+}
+
+// claim - claim the output for the named template. When another template
+// already claimed it, that template's name is returned, and ok is false.
+// Output to stdout ("-") is never claimed, and a nil outputClaims claims
+// nothing.
+func (c *outputClaims) claim(path, tmpl string) (owner string, ok bool) {
+	if c == nil || path == "-" {
+		return "", true
+	}
+	p, err := filepath.Abs(path)
+	if err != nil {
+		p = filepath.Clean(path)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if owner, claimed := c.by[p]; claimed {
+		return owner, false
+	}
+	c.by[p] = tmpl
+	return "", true
+}
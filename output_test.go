@@ -0,0 +1,133 @@
+package gomplate
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtraOutputsResolve(t *testing.T) {
+	o := newExtraOutputs(fs, "out", &tplate{})
+	p, err := o.resolve("envs/prod.tf")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("out", "envs", "prod.tf"), p)
+
+	p, err = o.resolve("a/../b.tf")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("out", "b.tf"), p)
+
+	for _, bad := range []string{"", ".", "..", "../x", "a/../../x", "/abs/x"} {
+		_, err = o.resolve(bad)
+		assert.Error(t, err, bad)
+	}
+
+	_, err = newExtraOutputs(fs, "", &tplate{}).resolve("x")
+	assert.Error(t, err)
+}
+
+func TestOutWrite(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	g := newGomplate(fs, Funcs(nil), "{{", "}}", nil, nil)
+	g.outputDir = "out"
+
+	out := &bytes.Buffer{}
+	tmpl := &tplate{name: "t", targetPath: "out/main", target: out, mode: 0640,
+		contents: `main{{ out.Write "a.txt" "a" }}{{ define "b" }}b{{ . }}{{ end }}{{ tmpl.Exec "b" 1 | out.Write "sub/b.txt" }}`}
+	assert.NoError(t, g.runTemplate(tmpl))
+	assert.Equal(t, "main", out.String())
+	assertFile(t, "out/a.txt", "a")
+	assertFile(t, "out/sub/b.txt", "b1")
+	assert.Equal(t, []string{filepath.Join("out", "a.txt"), filepath.Join("out", "sub", "b.txt")}, tmpl.extraPaths)
+	fi, err := fs.Stat("out/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+
+	// nothing is written when the template fails
+	_ = afero.WriteFile(fs, "out/a.txt", []byte("old"), 0644)
+	tmpl = &tplate{name: "t", targetPath: "out/main", target: &bytes.Buffer{},
+		contents: `{{ out.Write "a.txt" "new" }}{{ out.Write "c.txt" "c" }}{{ fail "oops" }}`}
+	assert.Error(t, g.runTemplate(tmpl))
+	assertFile(t, "out/a.txt", "old")
+	_, err = fs.Stat("out/c.txt")
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, tmpl.extraPaths)
+
+	for _, contents := range []string{
+		`{{ out.Write "x" "1" }}{{ out.Write "x" "2" }}`,
+		`{{ out.Write "main" "1" }}`,
+		`{{ out.Write "../x" "1" }}`,
+	} {
+		tmpl = &tplate{name: "t", targetPath: "out/main", target: &bytes.Buffer{}, contents: contents}
+		assert.Error(t, g.runTemplate(tmpl), contents)
+	}
+
+	// empty outputs are suppressed, like any other output
+	os.Setenv("GOMPLATE_SUPPRESS_EMPTY", "true")
+	defer os.Unsetenv("GOMPLATE_SUPPRESS_EMPTY")
+	tmpl = &tplate{name: "t", targetPath: "out/main", target: &bytes.Buffer{},
+		contents: `{{ out.Write "empty.txt" "  \n" }}{{ out.Write "full.txt" "full" }}`}
+	assert.NoError(t, g.runTemplate(tmpl))
+	_, err = fs.Stat("out/empty.txt")
+	assert.True(t, os.IsNotExist(err))
+	assertFile(t, "out/full.txt", "full")
+	assert.Equal(t, []string{filepath.Join("out", "full.txt")}, tmpl.extraPaths)
+}
+
+func TestOutWriteWithoutOutput(t *testing.T) {
+	r, err := NewRenderer(RenderOptions{})
+	assert.NoError(t, err)
+	defer r.Close()
+	_, err = r.Render(context.Background(), "t", strings.NewReader(`{{ out.Write "x" "y" }}`), &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestOutputCollisions(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	g := newGomplate(fs, Funcs(nil), "{{", "}}", nil, nil)
+	g.outputDir = "out"
+	g.metrics = newMetrics()
+
+	// an extra output can't be another template's own output
+	err := g.renderTemplates([]*tplate{
+		{name: "t1", targetPath: "out/a", target: &bytes.Buffer{}, contents: `{{ out.Write "b" "x" }}`},
+		{name: "t2", targetPath: "out/b", target: &bytes.Buffer{}, contents: `b`},
+	}, 1)
+	assert.EqualError(t, err, `template: t1:1:6: executing "t1" at <out.Write>: error calling Write: out.Write: output `+filepath.Join("out", "b")+` is also written by t2`)
+
+	// nor another template's extra output
+	err = g.renderTemplates([]*tplate{
+		{name: "t1", targetPath: "out/a", target: &bytes.Buffer{}, contents: `{{ out.Write "c" "1" }}`},
+		{name: "t2", targetPath: "out/b", target: &bytes.Buffer{}, contents: `{{ out.Write "c" "2" }}`},
+	}, 1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is also written by t1")
+	assertFile(t, "out/c", "1")
+
+	// two templates with the same output aren't rendered at all
+	err = g.renderTemplates([]*tplate{
+		{name: "t1", targetPath: "out/d", target: &bytes.Buffer{}, contents: `{{ out.Write "e" "1" }}`},
+		{name: "t2", targetPath: "./out/d", target: &bytes.Buffer{}, contents: `2`},
+	}, 1)
+	assert.EqualError(t, err, "output ./out/d is written by both t1 and t2")
+	_, err = fs.Stat("out/e")
+	assert.True(t, os.IsNotExist(err))
+
+	// stdout isn't an output file
+	err = g.renderTemplates([]*tplate{
+		{name: "t1", targetPath: "-", target: &bytes.Buffer{}, contents: `1`},
+		{name: "t2", targetPath: "-", target: &bytes.Buffer{}, contents: `2`},
+	}, 1)
+	assert.NoError(t, err)
+}
@@ -3,38 +3,125 @@ package gomplate
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/hairyhenderson/gomplate/conv"
 	"github.com/hairyhenderson/gomplate/env"
+	yaml "gopkg.in/yaml.v3"
 )
 
-func bindPlugins(plugins []string, funcMap template.FuncMap) error {
+// bindPlugins - add the plugins to the function map. The plugins are returned
+// so they can be closed once rendering is done.
+func bindPlugins(plugins []string, funcMap template.FuncMap) ([]*plugin, error) {
+	bound := make([]*plugin, 0, len(plugins))
 	for _, p := range plugins {
 		plugin, err := newPlugin(p)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if _, ok := funcMap[plugin.name]; ok {
-			return fmt.Errorf("function %q is already bound, and can not be overridden", plugin.name)
+			return nil, fmt.Errorf("function %q is already bound, and can not be overridden", plugin.name)
 		}
-		funcMap[plugin.name] = plugin.run
+		funcMap[plugin.name] = plugin.funcFor(context.Background())
+		bound = append(bound, plugin)
+	}
+	return bound, nil
+}
+
+// closePlugins - shut down any plugins which are still running
+func closePlugins(plugins []*plugin) {
+	for _, p := range plugins {
+		p.close()
 	}
-	return nil
 }
 
+// plugin protocols
+const (
+	// pluginProtocolExec - the plugin is run once for each call, with the
+	// arguments as commandline arguments, and its output is the result (the
+	// default)
+	pluginProtocolExec = "exec"
+	// pluginProtocolJSONRPC - the plugin is started once, and each call is a
+	// JSON-RPC request written to its standard input, with the response read
+	// from its standard output (see call)
+	pluginProtocolJSONRPC = "jsonrpc"
+)
+
+// plugin output formats
+const (
+	// pluginOutputText - the output is returned as a string (the default)
+	pluginOutputText = "text"
+	// pluginOutputJSON, pluginOutputYAML - the output is parsed into
+	// structured data
+	pluginOutputJSON = "json"
+	pluginOutputYAML = "yaml"
+)
+
 // plugin represents a custom function that binds to an external process to be executed
 type plugin struct {
 	name, path string
+	protocol   string
+
+	// how long a call may take - GOMPLATE_PLUGIN_TIMEOUT is used when zero
+	callTimeout time.Duration
+	// extra environment variables, in KEY=value form
+	env []string
+	// the working directory - gomplate's when empty
+	dir string
+	// whether the last argument is written to the plugin's standard input,
+	// instead of being given as a commandline argument
+	pipe bool
+	// the output format - text, json, or yaml
+	output string
+
+	// the running process, with the jsonrpc protocol
+	mu   sync.Mutex
+	proc *rpcProcess
+}
+
+// newPlugin - parse a plugin in name=path form. Options can be given in a
+// query string following the path, like "name=path?protocol=jsonrpc":
+//   - protocol - exec (the default) or jsonrpc
+//   - timeout - how long a call may take (overrides GOMPLATE_PLUGIN_TIMEOUT)
+//   - env - an extra environment variable, in KEY=value form (may be repeated)
+//   - dir - the working directory
+//   - pipe - if true, the last argument is written to standard input (exec only)
+//   - output - text (the default), json, or yaml (exec only)
+// pluginOptionsIndex - the index of the "?" the plugin's options follow, or
+// -1 if there are none. A "?" can also be part of the plugin's path, so the
+// options start after the longest prefix which names an existing command, or
+// else after the first "?".
+func pluginOptionsIndex(path string) int {
+	if !strings.Contains(path, "?") || pluginPathExists(path) {
+		return -1
+	}
+	for i := strings.LastIndex(path, "?"); i > 0; i = strings.LastIndex(path[:i], "?") {
+		if pluginPathExists(path[:i]) {
+			return i
+		}
+	}
+	return strings.Index(path, "?")
+}
+
+// pluginPathExists - whether the path names a command that exists, either
+// as a path or in the PATH
+func pluginPathExists(path string) bool {
+	_, err := exec.LookPath(path)
+	return err == nil
 }
 
 func newPlugin(value string) (*plugin, error) {
@@ -47,9 +134,81 @@ func newPlugin(value string) (*plugin, error) {
 		name: parts[0],
 		path: parts[1],
 	}
+	if i := pluginOptionsIndex(p.path); i >= 0 {
+		opts, err := url.ParseQuery(p.path[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid options for plugin %s: %w", p.name, err)
+		}
+		p.path = p.path[:i]
+		for k := range opts {
+			if err = p.setOption(k, opts[k]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch p.protocol {
+	case "", pluginProtocolExec:
+	case pluginProtocolJSONRPC:
+		if p.pipe || p.output != "" {
+			return nil, fmt.Errorf("the pipe and output options can't be used with the jsonrpc protocol (plugin %s)", p.name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q for plugin %s - must be exec or jsonrpc", p.protocol, p.name)
+	}
 	return p, nil
 }
 
+// setOption - set one of the options given in the plugin's query string
+func (p *plugin) setOption(k string, values []string) (err error) {
+	v := values[len(values)-1]
+	switch k {
+	case "protocol":
+		p.protocol = v
+	case "timeout":
+		p.callTimeout, err = time.ParseDuration(v)
+		if err == nil && p.callTimeout <= 0 {
+			err = errors.New("must be positive")
+		}
+	case "env":
+		for _, e := range values {
+			if !strings.Contains(e, "=") {
+				return fmt.Errorf("invalid env option %q for plugin %s - must be in KEY=value form", e, p.name)
+			}
+		}
+		p.env = values
+	case "dir":
+		p.dir = v
+	case "pipe":
+		p.pipe, err = strconv.ParseBool(v)
+	case "output":
+		switch v {
+		case pluginOutputText, pluginOutputJSON, pluginOutputYAML:
+			p.output = v
+		default:
+			return fmt.Errorf("unsupported output format %q for plugin %s - must be text, json, or yaml", v, p.name)
+		}
+	default:
+		return fmt.Errorf("unknown option %q for plugin %s", k, p.name)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s option %q for plugin %s: %w", k, v, p.name, err)
+	}
+	return nil
+}
+
+// command - the command to run the plugin with the given arguments, with the
+// plugin's environment and working directory
+func (p *plugin) command(ctx context.Context, args []string) *exec.Cmd {
+	name, args := p.buildCommand(args)
+	// nolint: gosec
+	c := exec.CommandContext(ctx, name, args...)
+	if len(p.env) > 0 {
+		c.Env = append(os.Environ(), p.env...)
+	}
+	c.Dir = p.dir
+	return c
+}
+
 // builds a command that's appropriate for running scripts
 // nolint: gosec
 func (p *plugin) buildCommand(a []string) (name string, args []string) {
@@ -80,20 +239,49 @@ func findPowershell() string {
 	return "pwsh"
 }
 
+// timeout - how long a single call to a plugin may take
+func (p *plugin) timeout() (time.Duration, error) {
+	if p.callTimeout > 0 {
+		return p.callTimeout, nil
+	}
+	return time.ParseDuration(env.Getenv("GOMPLATE_PLUGIN_TIMEOUT", "5s"))
+}
+
+// funcFor - the plugin's function, whose calls are abandoned (and the plugin
+// stopped) once ctx is done
+func (p *plugin) funcFor(ctx context.Context) func(...interface{}) (interface{}, error) {
+	if p.protocol == pluginProtocolJSONRPC {
+		return func(args ...interface{}) (interface{}, error) {
+			return p.callContext(ctx, args...)
+		}
+	}
+	return func(args ...interface{}) (interface{}, error) {
+		return p.runContext(ctx, args...)
+	}
+}
+
 func (p *plugin) run(args ...interface{}) (interface{}, error) {
-	a := conv.ToStrings(args...)
+	return p.runContext(context.Background(), args...)
+}
 
-	name, a := p.buildCommand(a)
+// runContext - like run, but the plugin is killed once ctx is done
+func (p *plugin) runContext(pctx context.Context, args ...interface{}) (interface{}, error) {
+	var stdin io.Reader
+	if p.pipe && len(args) > 0 {
+		stdin = strings.NewReader(conv.ToString(args[len(args)-1]))
+		args = args[:len(args)-1]
+	}
+	a := conv.ToStrings(args...)
 
-	t, err := time.ParseDuration(env.Getenv("GOMPLATE_PLUGIN_TIMEOUT", "5s"))
+	t, err := p.timeout()
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), t)
+	ctx, cancel := context.WithTimeout(pctx, t)
 	defer cancel()
-	c := exec.CommandContext(ctx, name, a...)
-	c.Stdin = nil
+	c := p.command(ctx, a)
+	c.Stdin = stdin
 	c.Stderr = os.Stderr
 	outBuf := &bytes.Buffer{}
 	c.Stdout = outBuf
@@ -113,9 +301,31 @@ func (p *plugin) run(args ...interface{}) (interface{}, error) {
 	err = c.Run()
 	elapsed := time.Since(start)
 
-	if ctx.Err() != nil {
+	switch {
+	case pctx.Err() != nil:
+		err = fmt.Errorf("plugin %s call abandoned: %w", p.name, pctx.Err())
+	case ctx.Err() != nil:
 		err = fmt.Errorf("plugin timed out after %v: %w", elapsed, ctx.Err())
 	}
+	if err != nil {
+		return outBuf.String(), err
+	}
+
+	return p.parseOutput(outBuf.String())
+}
 
-	return outBuf.String(), err
+// parseOutput - parse the plugin's output, in its output format
+func (p *plugin) parseOutput(out string) (interface{}, error) {
+	if p.output != pluginOutputJSON && p.output != pluginOutputYAML {
+		return out, nil
+	}
+	var v interface{}
+	unmarshal := yaml.Unmarshal
+	if p.output == pluginOutputJSON {
+		unmarshal = json.Unmarshal
+	}
+	if err := unmarshal([]byte(out), &v); err != nil {
+		return nil, fmt.Errorf("plugin %s output is not valid %s: %w", p.name, strings.ToUpper(p.output), err)
+	}
+	return v, nil
 }
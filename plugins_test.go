@@ -3,6 +3,7 @@ package gomplate
 import (
 	"testing"
 	"text/template"
+	"time"
 
 	"gotest.tools/v3/assert"
 	"gotest.tools/v3/assert/cmp"
@@ -18,21 +19,90 @@ func TestNewPlugin(t *testing.T) {
 	assert.NilError(t, err)
 	assert.Equal(t, "foo", out.name)
 	assert.Equal(t, "/bin/bar", out.path)
+	assert.Equal(t, "", out.protocol)
+
+	out, err = newPlugin("foo=/bin/bar?protocol=jsonrpc")
+	assert.NilError(t, err)
+	assert.Equal(t, "/bin/bar", out.path)
+	assert.Equal(t, pluginProtocolJSONRPC, out.protocol)
+
+	_, err = newPlugin("foo=/bin/bar?protocol=grpc")
+	assert.ErrorContains(t, err, `unsupported protocol "grpc"`)
+
+	_, err = newPlugin("foo=/bin/bar?color=blue")
+	assert.ErrorContains(t, err, `unknown option "color"`)
+}
+
+func TestNewPluginOptions(t *testing.T) {
+	out, err := newPlugin("foo=/bin/bar?timeout=10s&env=A%3D1&env=B=2&dir=/tmp&pipe=true&output=json")
+	assert.NilError(t, err)
+	assert.Equal(t, "/bin/bar", out.path)
+	assert.Equal(t, 10*time.Second, out.callTimeout)
+	assert.DeepEqual(t, []string{"A=1", "B=2"}, out.env)
+	assert.Equal(t, "/tmp", out.dir)
+	assert.Equal(t, true, out.pipe)
+	assert.Equal(t, pluginOutputJSON, out.output)
+
+	d, err := out.timeout()
+	assert.NilError(t, err)
+	assert.Equal(t, 10*time.Second, d)
+
+	testdata := []struct {
+		in, err string
+	}{
+		{"foo=bar?timeout=soon", `invalid timeout option "soon" for plugin foo`},
+		{"foo=bar?timeout=-1s", `invalid timeout option "-1s" for plugin foo: must be positive`},
+		{"foo=bar?env=A", `invalid env option "A" for plugin foo`},
+		{"foo=bar?pipe=maybe", `invalid pipe option "maybe" for plugin foo`},
+		{"foo=bar?output=xml", `unsupported output format "xml"`},
+		{"foo=bar?protocol=jsonrpc&pipe=true", "can't be used with the jsonrpc protocol"},
+		{"foo=bar?protocol=jsonrpc&output=json", "can't be used with the jsonrpc protocol"},
+	}
+	for _, d := range testdata {
+		_, err := newPlugin(d.in)
+		assert.ErrorContains(t, err, d.err, d.in)
+	}
+}
+
+func TestPluginParseOutput(t *testing.T) {
+	p := &plugin{name: "foo"}
+	out, err := p.parseOutput(`{"a": 1}`)
+	assert.NilError(t, err)
+	assert.Equal(t, `{"a": 1}`, out)
+
+	p.output = pluginOutputJSON
+	out, err = p.parseOutput(`{"a": [1, "b"]}`)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, map[string]interface{}{"a": []interface{}{float64(1), "b"}}, out)
+
+	_, err = p.parseOutput(`{"a": `)
+	assert.ErrorContains(t, err, "plugin foo output is not valid JSON")
+	// JSON output is parsed strictly, not as YAML
+	_, err = p.parseOutput("a: b\n")
+	assert.ErrorContains(t, err, "plugin foo output is not valid JSON")
+
+	p.output = pluginOutputYAML
+	out, err = p.parseOutput("- a\n- b: true\n")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []interface{}{"a", map[string]interface{}{"b": true}}, out)
 }
 
 func TestBindPlugins(t *testing.T) {
 	fm := template.FuncMap{}
 	in := []string{}
-	err := bindPlugins(in, fm)
+	plugins, err := bindPlugins(in, fm)
 	assert.NilError(t, err)
+	assert.Equal(t, 0, len(plugins))
 	assert.DeepEqual(t, template.FuncMap{}, fm)
 
-	in = []string{"foo=bar"}
-	err = bindPlugins(in, fm)
+	in = []string{"foo=bar", "baz=qux?protocol=jsonrpc"}
+	plugins, err = bindPlugins(in, fm)
 	assert.NilError(t, err)
+	assert.Equal(t, 2, len(plugins))
 	assert.Check(t, cmp.Contains(fm, "foo"))
+	assert.Check(t, cmp.Contains(fm, "baz"))
 
-	err = bindPlugins(in, fm)
+	_, err = bindPlugins(in, fm)
 	assert.ErrorContains(t, err, "already bound")
 }
 
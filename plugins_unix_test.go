@@ -0,0 +1,205 @@
+// +build !windows
+
+package gomplate
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// rpcTestPlugin - a jsonrpc plugin which runs this test binary as
+// TestPluginHelperProcess. The returned func stops the plugin and cleans up.
+func rpcTestPlugin(t *testing.T, name string) (*plugin, func()) {
+	content := fmt.Sprintf("#!/bin/sh\nGOMPLATE_TEST_PLUGIN=1 exec %q -test.run=TestPluginHelperProcess\n", os.Args[0])
+	dir, err := ioutil.TempDir("", "gomplate-plugin")
+	assert.NilError(t, err)
+	script := filepath.Join(dir, "plugin.sh")
+	err = ioutil.WriteFile(script, []byte(content), 0755)
+	assert.NilError(t, err)
+
+	p, err := newPlugin(name + "=" + script + "?protocol=jsonrpc")
+	assert.NilError(t, err)
+	return p, func() {
+		p.close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPluginOptions(t *testing.T) {
+	p, err := newPlugin("cat=cat?pipe=true&output=json")
+	assert.NilError(t, err)
+	out, err := p.run(`{"foo": ["bar", 42]}`)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, map[string]interface{}{"foo": []interface{}{"bar", float64(42)}}, out)
+
+	p, err = newPlugin("head=head?pipe=true")
+	assert.NilError(t, err)
+	out, err = p.run("-n", "1", "a\nb\n")
+	assert.NilError(t, err)
+	assert.Equal(t, "a\n", out)
+
+	p, err = newPlugin("sh=/bin/sh?env=FOO%3Dbar&dir=/")
+	assert.NilError(t, err)
+	out, err = p.run("-c", `echo "$FOO $(pwd)"`)
+	assert.NilError(t, err)
+	assert.Equal(t, "bar /\n", out)
+
+	os.Setenv("GOMPLATE_PLUGIN_TIMEOUT", "1ms")
+	defer os.Unsetenv("GOMPLATE_PLUGIN_TIMEOUT")
+	p, err = newPlugin("sleep=sleep?timeout=5s")
+	assert.NilError(t, err)
+	_, err = p.run("0.1")
+	assert.NilError(t, err)
+
+	p, err = newPlugin("sleep=sleep?timeout=100ms")
+	assert.NilError(t, err)
+	_, err = p.run("5")
+	assert.ErrorContains(t, err, "plugin timed out")
+
+	// calls are abandoned once the render's context is done
+	p, err = newPlugin("sleep=sleep?timeout=5s")
+	assert.NilError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = p.funcFor(ctx)("5")
+	assert.ErrorContains(t, err, "plugin sleep call abandoned")
+	assert.Assert(t, time.Since(start) < 5*time.Second)
+}
+
+func TestRPCPlugin(t *testing.T) {
+	p, cleanup := rpcTestPlugin(t, "echo")
+	defer cleanup()
+
+	out, err := p.call("foo", 42, []string{"a", "b"}, map[string]interface{}{"bar": true})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []interface{}{
+		"foo", float64(42), []interface{}{"a", "b"}, map[string]interface{}{"bar": true},
+	}, out)
+
+	out, err = p.call()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []interface{}{}, out)
+}
+
+func TestRPCPluginPersistent(t *testing.T) {
+	p, cleanup := rpcTestPlugin(t, "count")
+	defer cleanup()
+
+	for i := 1; i <= 3; i++ {
+		out, err := p.call()
+		assert.NilError(t, err)
+		assert.Equal(t, float64(i), out)
+	}
+
+	proc := p.proc
+	p.close()
+	assert.Assert(t, p.proc == nil)
+	assert.Assert(t, proc.cmd.ProcessState != nil)
+	assert.Assert(t, proc.cmd.ProcessState.Exited())
+
+	// started again on the next call
+	out, err := p.call()
+	assert.NilError(t, err)
+	assert.Equal(t, float64(1), out)
+}
+
+func TestRPCPluginErrors(t *testing.T) {
+	p, cleanup := rpcTestPlugin(t, "fail")
+	defer cleanup()
+	_, err := p.call("oops")
+	assert.ErrorContains(t, err, "plugin fail failed: oops (code 42)")
+
+	p, cleanup2 := rpcTestPlugin(t, "exit")
+	defer cleanup2()
+	_, err = p.call()
+	assert.ErrorContains(t, err, "plugin exit stopped responding")
+	assert.Assert(t, p.proc == nil)
+}
+
+func TestRPCPluginTimeout(t *testing.T) {
+	os.Setenv("GOMPLATE_PLUGIN_TIMEOUT", "500ms")
+	defer os.Unsetenv("GOMPLATE_PLUGIN_TIMEOUT")
+
+	p, cleanup := rpcTestPlugin(t, "sleep")
+	defer cleanup()
+	_, err := p.call("5s")
+	assert.ErrorContains(t, err, "plugin timed out after 500ms")
+	assert.Assert(t, p.proc == nil)
+
+	// the plugin is restarted for the next call
+	out, err := p.call("0s")
+	assert.NilError(t, err)
+	assert.Equal(t, "0s", out)
+}
+
+// TestPluginHelperProcess - not a real test: a jsonrpc plugin, run by the
+// tests above. Its behaviour depends on the method (the plugin's name).
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Getenv("GOMPLATE_TEST_PLUGIN") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	count := 0
+	in := bufio.NewScanner(os.Stdin)
+	out := json.NewEncoder(os.Stdout)
+	for in.Scan() {
+		req := rpcRequest{}
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil {
+			os.Exit(1)
+		}
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		switch req.Method {
+		case "echo":
+			resp["result"] = req.Params
+		case "count":
+			count++
+			resp["result"] = count
+		case "fail":
+			resp["error"] = rpcError{Code: 42, Message: fmt.Sprint(req.Params...)}
+		case "exit":
+			os.Exit(1)
+		case "sleep":
+			d, _ := time.ParseDuration(req.Params[0].(string))
+			time.Sleep(d)
+			resp["result"] = req.Params[0]
+		}
+		// nolint: errcheck
+		out.Encode(resp)
+	}
+}
+
+func TestNewPluginPathWithQuestionMark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomplate-plugin")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "what?output=json")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\necho '{not json'\n"), 0755)
+	assert.NilError(t, err)
+
+	// the whole path names the plugin, so there are no options
+	p, err := newPlugin("what=" + script)
+	assert.NilError(t, err)
+	assert.Equal(t, script, p.path)
+	assert.Equal(t, "", p.output)
+	out, err := p.run()
+	assert.NilError(t, err)
+	assert.Equal(t, "{not json\n", out)
+
+	// but options can still be given after it
+	p, err = newPlugin("what=" + script + "?timeout=5s")
+	assert.NilError(t, err)
+	assert.Equal(t, script, p.path)
+	assert.Equal(t, "", p.output)
+	assert.Equal(t, 5*time.Second, p.callTimeout)
+}
@@ -0,0 +1,258 @@
+package gomplate
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// postRenderHook - a command to run on each output file matching glob, once
+// it's been written. Each word of the command is a template, rendered with
+// the output's path (.path) and input name (.input), so paths containing
+// spaces are still a single argument.
+type postRenderHook struct {
+	glob  string
+	words []string
+}
+
+// parsePostRenderHooks - parse hooks in glob=command form (see
+// Config.PostRender)
+func parsePostRenderHooks(args []string, ldelim, rdelim string) ([]*postRenderHook, error) {
+	hooks := make([]*postRenderHook, 0, len(args))
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) < 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid post-render hook %q: must be in glob=command form", arg)
+		}
+		if _, err := path.Match(parts[0], ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid post-render hook %q", arg)
+		}
+		words, err := splitCommand(parts[1], ldelim, rdelim)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid post-render hook %q", arg)
+		}
+		if len(words) == 0 {
+			return nil, errors.Errorf("invalid post-render hook %q: no command given", arg)
+		}
+		hooks = append(hooks, &postRenderHook{glob: parts[0], words: words})
+	}
+	return hooks, nil
+}
+
+// splitCommand - split a command into words on whitespace, like a shell
+// would. Quotes group words (and are removed), and template actions are kept
+// intact, whatever they contain. There are no escapes, so that Windows paths
+// can be given as they are.
+func splitCommand(cmd, ldelim, rdelim string) ([]string, error) {
+	words := []string{}
+	word := &strings.Builder{}
+	inWord := false
+	for i := 0; i < len(cmd); {
+		c := cmd[i]
+		switch {
+		case strings.HasPrefix(cmd[i:], ldelim):
+			end := strings.Index(cmd[i+len(ldelim):], rdelim)
+			if end < 0 {
+				return nil, errors.Errorf("unclosed action in %q", cmd)
+			}
+			n := len(ldelim) + end + len(rdelim)
+			word.WriteString(cmd[i : i+n])
+			inWord = true
+			i += n
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(cmd[i+1:], c)
+			if end < 0 {
+				return nil, errors.Errorf("unterminated quote in %q", cmd)
+			}
+			word.WriteString(cmd[i+1 : i+1+end])
+			inWord = true
+			i += end + 2
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			i++
+		default:
+			word.WriteByte(c)
+			inWord = true
+			i++
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// matches - whether the hook applies to the output at the given path. Globs
+// without a '/' are matched against the file's name, like in .gitignore
+// files, and others against the whole path.
+func (h *postRenderHook) matches(p string) bool {
+	p = filepath.ToSlash(p)
+	if !strings.Contains(h.glob, "/") {
+		p = path.Base(p)
+	}
+	ok, _ := path.Match(h.glob, p)
+	return ok
+}
+
+// command - render the hook's command for the given template's output at path
+// p (its own output, or one it wrote with out.Write)
+func (h *postRenderHook) command(g *gomplate, t *tplate, p string) (*exec.Cmd, error) {
+	values, _ := ctxValues(g.tmplctx)
+	hctx := tmplctx{}
+	for k, v := range values {
+		hctx[k] = v
+	}
+	hctx["ctx"] = g.tmplctx
+	hctx["path"] = p
+	hctx["input"] = t.name
+	tctx := g.sandbox.context(&hctx)
+
+	args := make([]string, len(h.words))
+	for i, word := range h.words {
+		w := &tplate{name: "<PostRender>", contents: word}
+		tpl, err := w.toGoTemplate(g, tctx)
+		if err != nil {
+			return nil, err
+		}
+		out := &bytes.Buffer{}
+		if err = tpl.Execute(out, tctx); err != nil {
+			return nil, errors.Wrapf(err, "failed to render post-render hook for %s", p)
+		}
+		args[i] = out.String()
+	}
+	// nolint: gosec
+	c := exec.Command(args[0], args[1:]...)
+	// standard output may be where templates are written, so the hook's
+	// output goes to standard error
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	return c, nil
+}
+
+// hooksFor - the post-render hooks which apply to the given output path, in
+// the order they were given
+func (g *gomplate) hooksFor(p string) []*postRenderHook {
+	if p == "-" {
+		return nil
+	}
+	hooks := []*postRenderHook{}
+	for _, h := range g.postRender {
+		if h.matches(p) {
+			hooks = append(hooks, h)
+		}
+	}
+	return hooks
+}
+
+// runTemplateHooked - run the template, then the post-render hooks for each
+// of its outputs: its own output, then any extra outputs it wrote with
+// out.Write. If a hook fails, that output's previous contents are restored (or
+// it's removed, if it didn't exist), and the hook's error is returned.
+func (g *gomplate) runTemplateHooked(t *tplate) error {
+	if len(g.postRender) == 0 {
+		return g.runTemplate(t)
+	}
+	var prev *outputBackup
+	var err error
+	if len(g.hooksFor(t.targetPath)) > 0 {
+		prev, err = backupOutput(g.fs, t.targetPath)
+		if err != nil {
+			return err
+		}
+	}
+	err = g.runTemplate(t)
+	if err != nil {
+		return err
+	}
+	if t.written && prev != nil {
+		if err = g.runHooks(t, t.targetPath, prev); err != nil {
+			return err
+		}
+	}
+	// extra outputs are backed up as they're written (see extraOutputs.write)
+	for _, p := range t.extraPaths {
+		if b := t.extra.backup(p); b != nil {
+			if err = g.runHooks(t, p, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runHooks - run the hooks for the template's output at path p, restoring
+// the output from prev if one fails
+func (g *gomplate) runHooks(t *tplate, p string, prev *outputBackup) error {
+	for _, h := range g.hooksFor(p) {
+		c, err := h.command(g, t, p)
+		if err == nil {
+			err = c.Run()
+			if err != nil {
+				err = errors.Wrapf(err, "post-render hook %q failed for %s", strings.Join(c.Args, " "), p)
+			}
+		}
+		if err != nil {
+			if rerr := prev.restore(); rerr != nil {
+				return errors.Wrapf(err, "failed to restore %s (%v)", p, rerr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// outputBackup - the contents of an output file before it was rendered, so it
+// can be restored
+type outputBackup struct {
+	fs       afero.Fs
+	path     string
+	exists   bool
+	mode     os.FileMode
+	contents []byte
+}
+
+func backupOutput(fs afero.Fs, p string) (*outputBackup, error) {
+	b := &outputBackup{fs: fs, path: p}
+	fi, err := fs.Stat(p)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.contents, err = afero.ReadFile(fs, p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", p)
+	}
+	b.exists = true
+	b.mode = fi.Mode().Perm()
+	return b, nil
+}
+
+// restore - put the output file back the way it was, replacing it atomically
+// like any other output
+func (b *outputBackup) restore() error {
+	if !b.exists {
+		err := b.fs.Remove(b.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	f, err := createOutFile(b.fs, b.path, b.mode, true, false)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b.contents)
+	return closeTarget(f, err)
+}
@@ -0,0 +1,66 @@
+package gomplate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommand(t *testing.T) {
+	testdata := []struct {
+		in       string
+		expected []string
+	}{
+		{"", []string{}},
+		{"terraform fmt {{ .path }}", []string{"terraform", "fmt", "{{ .path }}"}},
+		{`  kubectl  apply -f "{{ .path }}" --dry-run=client `, []string{"kubectl", "apply", "-f", "{{ .path }}", "--dry-run=client"}},
+		{`sh -c 'echo "$0"' {{ print "a b" }}`, []string{"sh", "-c", `echo "$0"`, `{{ print "a b" }}`}},
+		{`x ""`, []string{"x", ""}},
+		{`C:\bin\check.exe --in={{ .path }}.bak`, []string{`C:\bin\check.exe`, "--in={{ .path }}.bak"}},
+	}
+	for _, d := range testdata {
+		words, err := splitCommand(d.in, "{{", "}}")
+		assert.NoError(t, err, d.in)
+		assert.Equal(t, d.expected, words, d.in)
+	}
+
+	words, err := splitCommand("fmt [[ .path ]]", "[[", "]]")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fmt", "[[ .path ]]"}, words)
+
+	for _, bad := range []string{`x "y`, "x 'y", "x {{ .path"} {
+		_, err = splitCommand(bad, "{{", "}}")
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestParsePostRenderHooks(t *testing.T) {
+	hooks, err := parsePostRenderHooks([]string{"*.tf=terraform fmt {{ .path }}", "conf/*.conf=nginx -t -c {{ .path }}"}, "{{", "}}")
+	assert.NoError(t, err)
+	assert.Equal(t, []*postRenderHook{
+		{glob: "*.tf", words: []string{"terraform", "fmt", "{{ .path }}"}},
+		{glob: "conf/*.conf", words: []string{"nginx", "-t", "-c", "{{ .path }}"}},
+	}, hooks)
+
+	for _, bad := range []string{"", "*.tf", "=fmt", "*.tf=", "*.tf=  ", "[=fmt", `*.tf=fmt "`} {
+		_, err = parsePostRenderHooks([]string{bad}, "{{", "}}")
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestPostRenderHookMatches(t *testing.T) {
+	h := &postRenderHook{glob: "*.tf"}
+	assert.True(t, h.matches("main.tf"))
+	assert.True(t, h.matches("out/envs/prod.tf"))
+	assert.False(t, h.matches("main.tf.bak"))
+
+	h = &postRenderHook{glob: "conf/*.conf"}
+	assert.True(t, h.matches("conf/nginx.conf"))
+	assert.False(t, h.matches("nginx.conf"))
+	assert.False(t, h.matches("out/conf/nginx.conf"))
+
+	g := &gomplate{postRender: []*postRenderHook{{glob: "*.tf"}, {glob: "*"}}}
+	assert.Len(t, g.hooksFor("main.tf"), 2)
+	assert.Len(t, g.hooksFor("main.yaml"), 1)
+	assert.Empty(t, g.hooksFor("-"))
+}
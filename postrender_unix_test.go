@@ -0,0 +1,91 @@
+// +build !windows
+
+package gomplate
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunTemplateHooked(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	g := newGomplate(fs, Funcs(nil), "{{", "}}", nil, nil)
+	hooked := func(hooks ...string) *tplate {
+		var err error
+		g.postRender, err = parsePostRenderHooks(hooks, "{{", "}}")
+		assert.NoError(t, err)
+		target, err := createOutFile(fs, "out/a.txt", 0644, false, false)
+		assert.NoError(t, err)
+		return &tplate{name: "in/a.txt", targetPath: "out/a.txt", target: target, contents: "new"}
+	}
+
+	// the output's path and input name are given to the hook
+	tmpl := hooked(`*.txt=sh -c 'test "$0 $1" = "out/a.txt in/a.txt"' {{ .path }} {{ .input }}`, "*.tf=false")
+	assert.NoError(t, g.runTemplateHooked(tmpl))
+	assertFile(t, "out/a.txt", "new")
+
+	// a failing hook restores the previous contents
+	_ = afero.WriteFile(fs, "out/a.txt", []byte("old"), 0600)
+	_ = fs.Chmod("out/a.txt", 0600)
+	tmpl = hooked("*.txt=true", "*=false")
+	err := g.runTemplateHooked(tmpl)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `post-render hook "false" failed for out/a.txt`)
+	assertFile(t, "out/a.txt", "old")
+	fi, err := fs.Stat("out/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// ...or removes the output, when there wasn't one
+	_ = fs.Remove("out/a.txt")
+	tmpl = hooked("*=false")
+	assert.Error(t, g.runTemplateHooked(tmpl))
+	_, err = fs.Stat("out/a.txt")
+	assert.True(t, os.IsNotExist(err))
+
+	tmpl = hooked("*=no-such-command-exists")
+	assert.Error(t, g.runTemplateHooked(tmpl))
+
+	tmpl = hooked("*=echo {{ .bogus.field }}")
+	assert.Error(t, g.runTemplateHooked(tmpl))
+
+	// hooks run on extra outputs too, which are restored when they fail
+	g.outputDir = "out"
+	_ = afero.WriteFile(fs, "out/b.conf", []byte("old b"), 0644)
+	tmpl = hooked(`*.conf=sh -c 'test "$0" = "out/b.conf"' {{ .path }}`)
+	tmpl.contents = `{{ out.Write "b.conf" "new b" }}new`
+	assert.NoError(t, g.runTemplateHooked(tmpl))
+	assertFile(t, "out/a.txt", "new")
+	assertFile(t, "out/b.conf", "new b")
+
+	_ = afero.WriteFile(fs, "out/b.conf", []byte("old b"), 0644)
+	tmpl = hooked("*.conf=false")
+	tmpl.contents = `{{ out.Write "b.conf" "new b" }}{{ out.Write "c.conf" "new c" }}new`
+	err = g.runTemplateHooked(tmpl)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `post-render hook "false" failed for out/b.conf`)
+	assertFile(t, "out/a.txt", "new")
+	assertFile(t, "out/b.conf", "old b")
+	// hooks for later outputs aren't run once one has failed
+	assertFile(t, "out/c.conf", "new c")
+	g.outputDir = ""
+
+	// hooks don't run on outputs which weren't written
+	g.postRender, _ = parsePostRenderHooks([]string{"*=false"}, "{{", "}}")
+	tmpl = &tplate{name: "in/a.txt", targetPath: "out/a.txt", target: &bytes.Buffer{}, contents: `{{ fail "oops" }}`}
+	err = g.runTemplateHooked(tmpl)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "post-render")
+	os.Setenv("GOMPLATE_SUPPRESS_EMPTY", "true")
+	defer os.Unsetenv("GOMPLATE_SUPPRESS_EMPTY")
+	target, _ := openOutFile(fs, "out/a.txt", nil, 0644, false, false)
+	tmpl = &tplate{name: "in/a.txt", targetPath: "out/a.txt", target: target, contents: "  "}
+	assert.NoError(t, g.runTemplateHooked(tmpl))
+}
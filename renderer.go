@@ -0,0 +1,266 @@
+package gomplate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/template"
+	"time"
+
+	"github.com/hairyhenderson/gomplate/data"
+	"github.com/hairyhenderson/gomplate/funcs"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// RenderOptions - options for a Renderer. Datasources, contexts, and nested
+// templates use the same formats as the corresponding commandline flags.
+type RenderOptions struct {
+	// Datasources - datasources, in "alias=URL" form (as with --datasource)
+	Datasources []string
+	// DatasourceHeaders - HTTP headers for datasources, in "alias=Name: value"
+	// form (as with --datasource-header)
+	DatasourceHeaders []string
+	// Contexts - datasources to add to the template context (as with --context)
+	Contexts []string
+	// Templates - nested templates (as with --template)
+	Templates []string
+
+	// Funcs - additional template functions. These can't override built-in
+	// functions.
+	Funcs template.FuncMap
+
+	// Plugins - external commands to bind as functions, in name=path form (as
+	// with --plugin). Plugins which keep running (with the jsonrpc protocol)
+	// are stopped by Close.
+	Plugins []string
+
+	// Fs - the filesystem nested templates, file: datasources, and the file
+	// functions use. The OS filesystem is used when nil.
+	Fs afero.Fs
+
+	// OnFileRead - if set, called with the path of each file (or directory)
+	// read by file: datasources (including includes), and by the file.Read
+	// and file.ReadDir functions
+	OnFileRead func(path string)
+
+	// OnSourceRead - if set, called for each read from a datasource
+	// (including reads of context datasources when the Renderer is created)
+	OnSourceRead func(data.SourceRead)
+
+	// CacheTTL - how long data read from datasources is cached for. Data is
+	// cached for the life of the Renderer when zero. Context datasources are
+	// only read when the Renderer is created.
+	CacheTTL time.Duration
+
+	// LDelim, RDelim - the template delimiters. "{{" and "}}" are used when
+	// empty.
+	LDelim string
+	RDelim string
+
+	// MissingKey - how references to missing map keys are handled:
+	//  - "error" (the default) - rendering fails
+	//  - "zero" - missing keys (and nil values) render as empty
+	//  - "default" - missing keys (and nil values) render as "<no value>"
+	//  - "strict" - rendering fails on missing keys and nil values, and the
+	//    error names the full path of the missing key, and the datasource it
+	//    came from
+	MissingKey string
+
+	// Sandbox - if set, the policy restricting what templates can do. Funcs
+	// must be named in its allow list to be used.
+	Sandbox *Sandbox
+}
+
+// Renderer - renders templates with its own datasources, functions, and
+// filesystem. A Renderer doesn't depend on any package-level state, so any
+// number of them can be used concurrently. A single Renderer is also safe for
+// concurrent use.
+type Renderer struct {
+	g       *gomplate
+	data    *data.Data
+	plugins []*plugin
+}
+
+// NewRenderer - create a Renderer. Context datasources and nested templates
+// are read immediately, other datasources are read as they're referenced.
+// Close must be called when the Renderer is no longer needed.
+func NewRenderer(opts RenderOptions) (*Renderer, error) {
+	fs := opts.Fs
+	if fs == nil {
+		fs = afero.NewOsFs()
+	}
+	ldelim := opts.LDelim
+	if ldelim == "" {
+		ldelim = "{{"
+	}
+	rdelim := opts.RDelim
+	if rdelim == "" {
+		rdelim = "}}"
+	}
+	if _, err := missingKeyOption(opts.MissingKey); err != nil {
+		return nil, err
+	}
+
+	ds := make([]string, 0, len(opts.Datasources)+len(opts.Contexts))
+	ds = append(ds, opts.Datasources...)
+	ds = append(ds, opts.Contexts...)
+	d, err := data.NewData(ds, opts.DatasourceHeaders)
+	if err != nil {
+		return nil, err
+	}
+	d.Fs = fs
+	d.OnFileRead = opts.OnFileRead
+	d.CacheTTL = opts.CacheTTL
+	metrics := newMetrics()
+	d.OnSourceRead = func(r data.SourceRead) {
+		metrics.recordDatasourceRead(r)
+		if opts.OnSourceRead != nil {
+			opts.OnSourceRead(r)
+		}
+	}
+
+	r := &Renderer{data: d}
+	// the datasources and plugins set up so far must be cleaned up when
+	// anything else fails
+	fail := func(err error) (*Renderer, error) {
+		// nolint: errcheck
+		r.Close()
+		return nil, err
+	}
+	funcMap, plugins, err := rendererFuncs(d, fs, opts)
+	r.plugins = plugins
+	if err != nil {
+		return fail(err)
+	}
+
+	nested, err := parseTemplateArgs(fs, opts.Templates)
+	if err != nil {
+		return fail(err)
+	}
+	c, err := createTmplContext(opts.Contexts, d)
+	if err != nil {
+		return fail(err)
+	}
+
+	g := newGomplate(fs, funcMap, ldelim, rdelim, nested, opts.Sandbox.context(c))
+	// includes reads from context datasources, made above
+	g.metrics = metrics
+	g.data = d
+	g.missingKey = opts.MissingKey
+	g.sandbox = opts.Sandbox
+	r.g = g
+	return r, nil
+}
+
+// rendererFuncs - the functions for a Renderer: the built-in functions (with
+// the file functions using fs), and any additional functions and plugins,
+// restricted by the sandbox (if any). Plugins are returned even when there's
+// an error, so that they can be closed.
+func rendererFuncs(d *data.Data, fs afero.Fs, opts RenderOptions) (funcMap template.FuncMap, plugins []*plugin, err error) {
+	s := opts.Sandbox
+	if s != nil {
+		if err = s.restrictData(d); err != nil {
+			return nil, nil, err
+		}
+		funcMap, err = s.funcs(d, fs, opts.OnFileRead)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		funcMap = Funcs(d)
+		funcs.AddFileFuncsFs(funcMap, fs, opts.OnFileRead)
+	}
+	extra := template.FuncMap{}
+	for name, f := range opts.Funcs {
+		extra[name] = f
+	}
+	plugins, err = bindPlugins(opts.Plugins, extra)
+	if err != nil {
+		return nil, plugins, err
+	}
+	for name, f := range extra {
+		if _, ok := funcMap[name]; ok {
+			return nil, plugins, fmt.Errorf("function %q is already bound, and can not be overridden", name)
+		}
+		if !s.allows("", name) {
+			f = s.deniedFunc("", name)
+		}
+		funcMap[name] = f
+	}
+	if s != nil {
+		err = s.checkAllow(funcMap)
+	}
+	return funcMap, plugins, err
+}
+
+// callFuncs - the functions bound to a single Render call, which replace the
+// Renderer's: the datasource functions read through a view of the
+// datasources for the call, and plugins are stopped once ctx is done
+func (r *Renderer) callFuncs(ctx context.Context, v *data.View) template.FuncMap {
+	f := template.FuncMap{}
+	funcs.AddDataFuncs(f, v)
+	r.g.sandbox.restrict("data", f)
+	for _, p := range r.plugins {
+		if r.g.sandbox.allows("", p.name) {
+			f[p.name] = p.funcFor(ctx)
+		}
+	}
+	return f
+}
+
+// Render - render the template read from in to out. The name identifies the
+// template in errors and metrics. Rendering stops (with an error) once ctx is
+// done, abandoning any datasource reads and plugin calls in progress. The
+// returned metrics cover only this call, including the datasource reads the
+// template made.
+func (r *Renderer) Render(ctx context.Context, name string, in io.Reader, out io.Writer) (*MetricsType, error) {
+	m := newMetrics()
+	if err := ctx.Err(); err != nil {
+		return m, err
+	}
+
+	start := time.Now()
+	b, err := ioutil.ReadAll(in)
+	m.GatherDuration = time.Since(start)
+	if err != nil {
+		m.Errors++
+		return m, errors.Wrapf(err, "failed to read template %s", name)
+	}
+	m.TemplatesGathered = 1
+
+	start = time.Now()
+	t := &tplate{
+		name:     name,
+		contents: string(b),
+		target:   &ctxWriter{ctx: ctx, w: out},
+		funcs:    r.callFuncs(ctx, r.data.View(ctx, m.recordDatasourceRead)),
+	}
+	err = r.g.runTemplate(t)
+	m.TotalRenderDuration = time.Since(start)
+	m.recordRender(name, m.TotalRenderDuration, err)
+	return m, err
+}
+
+// Close - clean up datasources (for example, revoking Vault tokens), and stop
+// any plugins that are still running
+func (r *Renderer) Close() error {
+	r.data.Cleanup()
+	closePlugins(r.plugins)
+	return nil
+}
+
+// ctxWriter - an io.Writer which fails once the context is done, so that
+// template execution stops at its next write
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
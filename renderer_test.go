@@ -0,0 +1,186 @@
+package gomplate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderer(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/data.json", []byte(`{"name": "world"}`), 0644)
+	_ = afero.WriteFile(fs, "/t.tmpl", []byte(`Hello, {{ . }}`), 0644)
+
+	r, err := NewRenderer(RenderOptions{
+		Datasources: []string{"cfg=file:///data.json"},
+		Contexts:    []string{"d=file:///data.json"},
+		Templates:   []string{"t=/t.tmpl"},
+		Funcs: template.FuncMap{
+			"shout": strings.ToUpper,
+		},
+		Fs: fs,
+	})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	out := &bytes.Buffer{}
+	m, err := r.Render(context.Background(), "in", strings.NewReader(`{{ template "t" (shout .d.name) }}!`), out)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, WORLD!", out.String())
+	assert.Equal(t, 1, m.TemplatesGathered)
+	assert.Equal(t, 1, m.TemplatesProcessed)
+	assert.Equal(t, 0, m.Errors)
+	assert.Contains(t, m.RenderDuration, "in")
+	// the context was read when the Renderer was created, not by this call
+	assert.Empty(t, m.Datasources)
+
+	// datasource reads made during the call are included in its metrics
+	out.Reset()
+	m, err = r.Render(context.Background(), "ds", strings.NewReader(`{{ (ds "cfg").name }}`), out)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", out.String())
+	assert.Equal(t, &DatasourceMetrics{Reads: 1, Bytes: 17, ReadDuration: m.Datasources["cfg"].ReadDuration}, m.Datasources["cfg"])
+	m, err = r.Render(context.Background(), "ds", strings.NewReader(`{{ (ds "cfg").name }}`), out)
+	assert.NoError(t, err)
+	assert.Equal(t, &DatasourceMetrics{CacheHits: 1}, m.Datasources["cfg"])
+
+	m, err = r.Render(context.Background(), "bad", strings.NewReader(`{{ bogus }}`), out)
+	assert.Error(t, err)
+	assert.Equal(t, 1, m.Errors)
+	assert.Equal(t, 0, m.TemplatesProcessed)
+
+	_, err = NewRenderer(RenderOptions{
+		Funcs: template.FuncMap{"toUpper": strings.ToUpper},
+		Fs:    fs,
+	})
+	assert.Error(t, err)
+
+	_, err = NewRenderer(RenderOptions{
+		Templates: []string{"/missing.tmpl"},
+		Fs:        fs,
+	})
+	assert.Error(t, err)
+
+	// the file functions read from the Renderer's filesystem too
+	out.Reset()
+	_, err = r.Render(context.Background(), "file", strings.NewReader(`{{ file.Read "/data.json" }} {{ file.Exists "/t.tmpl" }}`), out)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name": "world"} true`, out.String())
+}
+
+func TestRendererMetricsPerCall(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/a.json", []byte(`{"name": "a"}`), 0644)
+	_ = afero.WriteFile(fs, "/b.json", []byte(`{"name": "b"}`), 0644)
+
+	// both renders are in progress before either reads its datasource
+	started := &sync.WaitGroup{}
+	started.Add(2)
+	r, err := NewRenderer(RenderOptions{
+		Datasources: []string{"a=file:///a.json", "b=file:///b.json"},
+		Funcs: template.FuncMap{
+			"started": func() string {
+				started.Done()
+				started.Wait()
+				return ""
+			},
+		},
+		Fs: fs,
+	})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	wg := &sync.WaitGroup{}
+	for _, alias := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(alias string) {
+			defer wg.Done()
+			out := &bytes.Buffer{}
+			m, err := r.Render(context.Background(), alias, strings.NewReader(`{{ started }}{{ (ds "`+alias+`").name }}`), out)
+			assert.NoError(t, err)
+			assert.Equal(t, alias, out.String())
+			assert.Len(t, m.Datasources, 1, alias)
+			assert.Contains(t, m.Datasources, alias)
+		}(alias)
+	}
+	wg.Wait()
+}
+
+func TestRendererCanceledRead(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	r, err := NewRenderer(RenderOptions{
+		Datasources: []string{"slow=" + srv.URL + "/slow.json"},
+		Fs:          afero.NewMemMapFs(),
+	})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	// the read is abandoned once the render's context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = r.Render(ctx, "in", strings.NewReader(`{{ ds "slow" }}`), &bytes.Buffer{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "context deadline exceeded")
+}
+
+func TestRendererCanceled(t *testing.T) {
+	r, err := NewRenderer(RenderOptions{Fs: afero.NewMemMapFs()})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out := &bytes.Buffer{}
+	_, err = r.Render(ctx, "in", strings.NewReader(`hello`), out)
+	assert.Equal(t, context.Canceled, err)
+	assert.Empty(t, out.String())
+
+	// rendering stops at the next write once the context is done
+	ctx, cancel = context.WithCancel(context.Background())
+	r, err = NewRenderer(RenderOptions{
+		Funcs: template.FuncMap{"cancel": func() string { cancel(); return "" }},
+		Fs:    afero.NewMemMapFs(),
+	})
+	assert.NoError(t, err)
+	defer r.Close()
+	_, err = r.Render(ctx, "in", strings.NewReader(`before{{ cancel }}after`), out)
+	assert.Error(t, err)
+	assert.Equal(t, "before", out.String())
+}
+
+func TestRenderersConcurrently(t *testing.T) {
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fs := afero.NewMemMapFs()
+			_ = afero.WriteFile(fs, "/t.tmpl", []byte(fmt.Sprintf("t%d", i)), 0644)
+			r, err := NewRenderer(RenderOptions{Templates: []string{"t=/t.tmpl"}, Fs: fs})
+			assert.NoError(t, err)
+			defer r.Close()
+
+			for j := 0; j < 5; j++ {
+				out := &bytes.Buffer{}
+				_, err := r.Render(context.Background(), "in", strings.NewReader(`{{ template "t" }}`), out)
+				assert.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("t%d", i), out.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,215 @@
+package gomplate
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// The jsonrpc plugin protocol: the plugin is started on its first call, and
+// kept running until gomplate is done. Each call is written to the plugin's
+// standard input as a JSON-RPC 2.0 request on a single line, with the plugin's
+// name as the method and the arguments (of any type) as the params:
+//
+//	{"jsonrpc":"2.0","id":1,"method":"myplugin","params":["foo",{"bar":1}]}
+//
+// The plugin writes a single-line response to its standard output, with
+// either a result (of any type) or an error:
+//
+//	{"jsonrpc":"2.0","id":1,"result":["a","b"]}
+//	{"jsonrpc":"2.0","id":1,"error":{"code":1,"message":"failed"}}
+//
+// Calls are made one at a time. The plugin should exit when its standard input
+// is closed.
+
+// maxRPCLine - the longest response line a plugin can write
+const maxRPCLine = 64 * 1024 * 1024
+
+type rpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcProcess - a running jsonrpc plugin
+type rpcProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	nextID int
+
+	// responses read from the plugin - closed when it exits
+	responses chan rpcResponse
+	// why reading responses stopped, set before responses is closed
+	readErr error
+}
+
+// start - start the plugin process, and read responses from it
+func (p *plugin) start() (*rpcProcess, error) {
+	c := p.command(context.Background(), nil)
+	c.Stderr = os.Stderr
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = c.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", p.name, err)
+	}
+
+	proc := &rpcProcess{cmd: c, stdin: stdin, responses: make(chan rpcResponse)}
+	go proc.read(stdout)
+	return proc, nil
+}
+
+// read - read responses until the plugin's output is closed
+func (r *rpcProcess) read(stdout io.Reader) {
+	defer close(r.responses)
+	s := bufio.NewScanner(stdout)
+	s.Buffer(make([]byte, 64*1024), maxRPCLine)
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		resp := rpcResponse{}
+		if err := json.Unmarshal(s.Bytes(), &resp); err != nil {
+			r.readErr = fmt.Errorf("invalid response %q: %w", s.Text(), err)
+			return
+		}
+		r.responses <- resp
+	}
+	r.readErr = s.Err()
+	if r.readErr == nil {
+		r.readErr = io.ErrUnexpectedEOF
+	}
+}
+
+// stop - stop the plugin process, waiting up to the given time for it to exit
+// once its input is closed, before killing it
+func (r *rpcProcess) stop(wait time.Duration) {
+	// nolint: errcheck
+	r.stdin.Close()
+	done := make(chan struct{})
+	go func() {
+		// drain any unread responses, so the reader can finish
+		for range r.responses {
+		}
+		// nolint: errcheck
+		r.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(wait):
+		// nolint: errcheck
+		r.cmd.Process.Kill()
+		<-done
+	}
+}
+
+// call - call the plugin with the jsonrpc protocol, starting it if it isn't
+// running. When a call fails because the plugin timed out, exited, or wrote
+// something other than a response, the plugin is stopped, and it's started
+// again on the next call.
+func (p *plugin) call(args ...interface{}) (interface{}, error) {
+	return p.callContext(context.Background(), args...)
+}
+
+// callContext - like call, but the call is abandoned (and the plugin stopped)
+// once ctx is done
+func (p *plugin) callContext(ctx context.Context, args ...interface{}) (interface{}, error) {
+	t, err := p.timeout()
+	if err != nil {
+		return nil, err
+	}
+	if args == nil {
+		args = []interface{}{}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.proc == nil {
+		p.proc, err = p.start()
+		if err != nil {
+			return nil, err
+		}
+	}
+	proc := p.proc
+	proc.nextID++
+	req, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: proc.nextID, Method: p.name, Params: args})
+	if err != nil {
+		return nil, fmt.Errorf("can't send arguments to plugin %s: %w", p.name, err)
+	}
+
+	fail := func(err error) (interface{}, error) {
+		p.proc = nil
+		proc.stop(0)
+		return nil, err
+	}
+	if _, err = proc.stdin.Write(append(req, '\n')); err != nil {
+		return fail(fmt.Errorf("failed to call plugin %s: %w", p.name, err))
+	}
+
+	timer := time.NewTimer(t)
+	defer timer.Stop()
+	var resp rpcResponse
+	select {
+	case r, ok := <-proc.responses:
+		if !ok {
+			return fail(fmt.Errorf("plugin %s stopped responding: %w", p.name, proc.readErr))
+		}
+		resp = r
+	case <-timer.C:
+		return fail(fmt.Errorf("plugin timed out after %v", t))
+	case <-ctx.Done():
+		return fail(fmt.Errorf("plugin %s call abandoned: %w", p.name, ctx.Err()))
+	}
+
+	if resp.ID != proc.nextID {
+		return fail(fmt.Errorf("plugin %s responded to request %d, expected %d", p.name, resp.ID, proc.nextID))
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("plugin %s failed: %s (code %d)", p.name, resp.Error.Message, resp.Error.Code)
+	}
+	var result interface{}
+	if len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return nil, fmt.Errorf("invalid result from plugin %s: %w", p.name, err)
+		}
+	}
+	return result, nil
+}
+
+// close - stop the plugin, if it's running
+func (p *plugin) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.proc != nil {
+		t, err := p.timeout()
+		if err != nil {
+			t = 5 * time.Second
+		}
+		p.proc.stop(t)
+		p.proc = nil
+	}
+}
@@ -0,0 +1,238 @@
+package gomplate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/hairyhenderson/gomplate/data"
+	"github.com/hairyhenderson/gomplate/file"
+	"github.com/hairyhenderson/gomplate/funcs"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// Sandbox - a policy restricting what templates can do, for rendering
+// templates that aren't fully trusted. Functions the policy doesn't allow can
+// still be referred to, but fail when they're called.
+type Sandbox struct {
+	// Allow - the function namespaces (like "strings"), and individual
+	// functions (like "getenv", or a plugin's name), templates may use. When
+	// empty, the namespaces without side effects are allowed (see
+	// defaultSandboxAllow). defineDatasource must be allowed by name.
+	Allow []string
+
+	// FSRoot - the directory the file functions and file: datasources are
+	// confined to. The working directory is used when empty.
+	FSRoot string
+
+	// NoNetwork - deny network access: the aws and net functions fail, as do
+	// datasources which read over the network, even when they're allowed
+	NoNetwork bool
+}
+
+// defaultSandboxAllow - the namespaces a Sandbox with no Allow list allows:
+// those with no side effects, which don't reveal anything about the host
+var defaultSandboxAllow = []string{
+	"base64", "coll", "conv", "crypto", "data", "filepath", "math", "path",
+	"random", "regexp", "strings", "test", "time", "uuid",
+}
+
+// networkNamespaces - the namespaces whose functions access the network
+var networkNamespaces = map[string]bool{"aws": true, "net": true}
+
+// explicitFuncs - functions which must be allowed by name, even when their
+// namespace is allowed
+var explicitFuncs = map[string]bool{"defineDatasource": true}
+
+// parseSandbox - parse sandbox settings, as given with --sandbox:
+// "allow=<namespace or function>,...", "fs-root=<dir>", "no-network", or
+// "default" (for the default policy). Returns nil when there are no settings.
+func parseSandbox(settings []string) (*Sandbox, error) {
+	if len(settings) == 0 {
+		return nil, nil
+	}
+	s := &Sandbox{}
+	for _, setting := range settings {
+		parts := strings.SplitN(setting, "=", 2)
+		switch {
+		case setting == "default":
+		case setting == "no-network":
+			s.NoNetwork = true
+		case parts[0] == "allow" && len(parts) == 2:
+			for _, a := range strings.Split(parts[1], ",") {
+				if a = strings.TrimSpace(a); a != "" {
+					s.Allow = append(s.Allow, a)
+				}
+			}
+		case parts[0] == "fs-root" && len(parts) == 2:
+			s.FSRoot = parts[1]
+		default:
+			return nil, errors.Errorf("invalid sandbox setting %q - must be allow=<namespaces>, fs-root=<dir>, no-network, or default", setting)
+		}
+	}
+	return s, nil
+}
+
+// allows - whether the named function, from the given namespace, may be
+// used. Functions which aren't in a namespace (like plugins) have the
+// namespace "". A nil Sandbox allows everything.
+func (s *Sandbox) allows(ns, name string) bool {
+	if s == nil {
+		return true
+	}
+	if s.NoNetwork && networkNamespaces[ns] {
+		return false
+	}
+	allow := s.Allow
+	if len(allow) == 0 {
+		allow = defaultSandboxAllow
+	}
+	for _, a := range allow {
+		if a == name || (a == ns && ns != "" && !explicitFuncs[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedFunc - a function which fails with an explanation, in place of the
+// named function, which the sandbox doesn't allow
+func (s *Sandbox) deniedFunc(ns, name string) func(...interface{}) (interface{}, error) {
+	var err error
+	switch {
+	case s.NoNetwork && networkNamespaces[ns]:
+		err = errors.Errorf("%s is not allowed by the sandbox policy (no-network)", name)
+	case ns == "" || explicitFuncs[name]:
+		err = errors.Errorf("%s is not allowed by the sandbox policy - add %q to the allow list to use it", name, name)
+	default:
+		err = errors.Errorf("%s is not allowed by the sandbox policy - add %q to the allow list to use it", name, ns)
+	}
+	return func(...interface{}) (interface{}, error) {
+		return nil, err
+	}
+}
+
+// restrict - replace the functions (from the given namespace) that the
+// sandbox doesn't allow with functions which fail
+func (s *Sandbox) restrict(ns string, f template.FuncMap) {
+	for name := range f {
+		if !s.allows(ns, name) {
+			f[name] = s.deniedFunc(ns, name)
+		}
+	}
+}
+
+// root - the absolute path of the directory files are confined to
+func (s *Sandbox) root() (string, error) {
+	if s.FSRoot == "" {
+		return os.Getwd()
+	}
+	return filepath.Abs(s.FSRoot)
+}
+
+// funcs - the built-in functions, restricted by the sandbox. The file
+// functions use fs, confined to the root, and call onFileRead (if set) for
+// each file read.
+func (s *Sandbox) funcs(d *data.Data, fs afero.Fs, onFileRead func(string)) (template.FuncMap, error) {
+	root, err := s.root()
+	if err != nil {
+		return nil, err
+	}
+	f := template.FuncMap{}
+	for _, ns := range namespaces(d) {
+		nsf := template.FuncMap{}
+		ns.add(nsf)
+		if ns.name == "file" {
+			funcs.AddSandboxedFileFuncs(nsf, fs, root, onFileRead)
+		}
+		s.restrict(ns.name, nsf)
+		for name, fn := range nsf {
+			f[name] = fn
+		}
+	}
+	return f, nil
+}
+
+// restrictData - restrict what the datasources can read, and whether
+// templates can define datasources
+func (s *Sandbox) restrictData(d *data.Data) error {
+	root, err := s.root()
+	if err != nil {
+		return err
+	}
+	d.FSRoot = root
+	d.NoNetwork = s.NoNetwork
+	d.NoDefine = !s.allows("data", "defineDatasource")
+	return nil
+}
+
+// checkAllow - returns an error if the allow list names a namespace or
+// function that doesn't exist, since it's probably a typo
+func (s *Sandbox) checkAllow(funcMap template.FuncMap) error {
+	known := map[string]bool{"tmpl": true, "tpl": true, "out": true}
+	for _, ns := range namespaces(nil) {
+		known[ns.name] = true
+	}
+	for _, a := range s.Allow {
+		if _, ok := funcMap[a]; !ok && !known[a] {
+			return errors.Errorf("unknown namespace or function %q in the sandbox allow list", a)
+		}
+	}
+	return nil
+}
+
+// context - the template context to use under the sandbox policy. The
+// context's .Env reveals the environment like the env functions do, so it's
+// replaced with a restricted context (whose Env fails) unless they're allowed.
+func (s *Sandbox) context(tctx interface{}) interface{} {
+	if c, ok := tctx.(*tmplctx); ok && !s.allows("env", "Env") {
+		r := restrictedCtx(*c)
+		return &r
+	}
+	return tctx
+}
+
+// checkEnv - returns an error if any of the trees refer to the context's
+// .Env, so that templates are rejected before they're rendered. This can't
+// catch every reference (e.g. through a variable), so it's the restricted
+// context (see context) which actually prevents reading the environment.
+func (s *Sandbox) checkEnv(tctx interface{}, trees ...*parse.Tree) (err error) {
+	if s.allows("env", "Env") {
+		return nil
+	}
+	if _, ok := ctxValues(tctx); !ok {
+		return nil
+	}
+	for _, tree := range trees {
+		walkTree(tree, func(n parse.Node) {
+			var env bool
+			switch n := n.(type) {
+			case *parse.FieldNode:
+				env = n.Ident[0] == "Env"
+			case *parse.VariableNode:
+				env = n.Ident[0] == "$" && len(n.Ident) > 1 && n.Ident[1] == "Env"
+			}
+			if env && err == nil {
+				loc, _ := tree.ErrorContext(n)
+				err = errors.Errorf(`template: %s: .Env is not allowed by the sandbox policy - add "env" to the allow list to use it`, loc)
+			}
+		})
+	}
+	return err
+}
+
+// checkOut - returns an error if the template's front matter sets an output
+// path outside of the directory its output would otherwise be written to
+func (s *Sandbox) checkOut(t *tplate) error {
+	if s == nil || t.front == nil || t.front.Out == "" || t.origTargetPath == "" {
+		return nil
+	}
+	dir := filepath.Dir(t.origTargetPath)
+	if t.origTargetPath == "-" {
+		dir = "."
+	}
+	return errors.Wrapf(file.InRoot(dir, t.targetPath), "the output path set in front matter in %s isn't allowed by the sandbox policy", t.name)
+}
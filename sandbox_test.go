@@ -0,0 +1,167 @@
+package gomplate
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSandbox(t *testing.T) {
+	s, err := parseSandbox(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, s)
+
+	s, err = parseSandbox([]string{"default"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Sandbox{}, s)
+
+	s, err = parseSandbox([]string{"allow=strings, coll,data", "fs-root=./conf", "no-network"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Sandbox{Allow: []string{"strings", "coll", "data"}, FSRoot: "./conf", NoNetwork: true}, s)
+
+	for _, bad := range []string{"allow", "fs-root", "no-network=true", "bogus"} {
+		_, err = parseSandbox([]string{bad})
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestSandboxAllows(t *testing.T) {
+	var s *Sandbox
+	assert.True(t, s.allows("env", "getenv"))
+
+	s = &Sandbox{}
+	assert.True(t, s.allows("strings", "toUpper"))
+	assert.True(t, s.allows("data", "ds"))
+	assert.False(t, s.allows("data", "defineDatasource"))
+	assert.False(t, s.allows("env", "getenv"))
+	assert.False(t, s.allows("tmpl", "tpl"))
+	assert.False(t, s.allows("", "echo"))
+
+	s = &Sandbox{Allow: []string{"strings", "env", "tpl", "defineDatasource", "echo", "net"}, NoNetwork: true}
+	assert.False(t, s.allows("data", "ds"))
+	assert.True(t, s.allows("data", "defineDatasource"))
+	assert.True(t, s.allows("env", "getenv"))
+	assert.True(t, s.allows("tmpl", "tpl"))
+	assert.False(t, s.allows("tmpl", "tmpl"))
+	assert.True(t, s.allows("", "echo"))
+	assert.False(t, s.allows("net", "net"))
+}
+
+func TestSandboxedRenderer(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/conf/config.json", []byte(`{"name": "world"}`), 0644)
+	_ = afero.WriteFile(fs, "/secret.json", []byte(`{"password": "hunter2"}`), 0644)
+
+	newRenderer := func(s *Sandbox) *Renderer {
+		r, err := NewRenderer(RenderOptions{
+			Contexts:    []string{"config=file:///conf/config.json"},
+			Datasources: []string{"secret=file:///secret.json"},
+			Funcs:       template.FuncMap{"echo": func(s string) string { return s }},
+			Fs:          fs,
+			Sandbox:     s,
+		})
+		assert.NoError(t, err)
+		return r
+	}
+	render := func(r *Renderer, in string) (string, error) {
+		out := &bytes.Buffer{}
+		_, err := r.Render(context.Background(), "in", strings.NewReader(in), out)
+		return out.String(), err
+	}
+
+	r := newRenderer(&Sandbox{FSRoot: "/conf"})
+	defer r.Close()
+	testdata := []struct {
+		in, out, err string
+	}{
+		{`{{ .config.name | toUpper }}`, "WORLD", ""},
+		{`{{ (ds "config").name }}`, "world", ""},
+		{`{{ getenv "HOME" }}`, "", `getenv is not allowed by the sandbox policy - add "env" to the allow list to use it`},
+		{`{{ env.Getenv "HOME" }}`, "", `env is not allowed by the sandbox policy`},
+		{`{{ .Env.HOME }}`, "", `template: in:1:7: .Env is not allowed by the sandbox policy`},
+		{`{{ $.Env.HOME }}`, "", `.Env is not allowed by the sandbox policy`},
+		{`{{ $c := . }}{{ $c.Env.HOME }}`, "", `.Env is not allowed by the sandbox policy`},
+		{`{{ with $x := . }}{{ $x.Env.HOME }}{{ end }}`, "", `.Env is not allowed by the sandbox policy`},
+		{`{{ define "t" }}{{ .Env.HOME }}{{ end }}{{ template "t" . }}`, "", `.Env is not allowed by the sandbox policy`},
+		{`{{ range $k, $v := .config }}{{ end }}{{ (index (coll.Slice .) 0).Env.HOME }}`, "", `.Env is not allowed by the sandbox policy`},
+		{`{{ if false }}{{ getenv "HOME" }}{{ end }}ok`, "ok", ""},
+		{`{{ file.Read "/conf/config.json" }}`, "", `file is not allowed by the sandbox policy`},
+		{`{{ echo "hi" }}`, "", `echo is not allowed by the sandbox policy - add "echo" to the allow list`},
+		{`{{ tpl "{{ 1 }}" }}`, "", `tpl is not allowed by the sandbox policy - add "tmpl"`},
+		{`{{ defineDatasource "foo" "file:///secret.json" }}`, "", `add "defineDatasource" to the allow list`},
+		{`{{ (ds "file:///secret.json").password }}`, "", `Undefined datasource 'file:///secret.json'`},
+		{`{{ (ds "secret").password }}`, "", `is outside of /conf`},
+	}
+	for _, d := range testdata {
+		out, err := render(r, d.in)
+		if d.err == "" {
+			assert.NoError(t, err, d.in)
+			assert.Equal(t, d.out, out, d.in)
+		} else if assert.Error(t, err, d.in) {
+			assert.Contains(t, err.Error(), d.err, d.in)
+		}
+	}
+
+	r = newRenderer(&Sandbox{Allow: []string{"env", "file", "echo", "tmpl", "data", "defineDatasource"}, FSRoot: "/conf"})
+	defer r.Close()
+	out, err := render(r, `{{ echo "hi" }} {{ tpl "{{ 1 }}" }} {{ if .Env }}env{{ end }} {{ getenv "NO_SUCH_VAR" "x" }}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "hi 1 env x", out)
+	_, err = render(r, `{{ defineDatasource "foo" "file:///conf/config.json" }}{{ (ds "foo").name }}`)
+	assert.NoError(t, err)
+	_, err = render(r, `{{ strings.ToUpper "x" }}`)
+	assert.Error(t, err)
+
+	// the allow list is checked for typos
+	_, err = NewRenderer(RenderOptions{Fs: fs, Sandbox: &Sandbox{Allow: []string{"strnigs"}}})
+	assert.Error(t, err)
+
+	// contexts are confined to the root too
+	_, err = NewRenderer(RenderOptions{
+		Contexts: []string{"secret=file:///secret.json"},
+		Fs:       fs,
+		Sandbox:  &Sandbox{FSRoot: "/conf"},
+	})
+	assert.Error(t, err)
+}
+
+func TestSandboxNoNetwork(t *testing.T) {
+	r, err := NewRenderer(RenderOptions{
+		Datasources: []string{"api=https://example.com/api"},
+		Fs:          afero.NewMemMapFs(),
+		Sandbox:     &Sandbox{Allow: []string{"net", "data"}, NoNetwork: true},
+	})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	for in, expected := range map[string]string{
+		`{{ net.LookupIP "example.com" }}`: "net is not allowed by the sandbox policy (no-network)",
+		`{{ ds "api" }}`:                   "network access is not allowed",
+	} {
+		_, err = r.Render(context.Background(), "in", strings.NewReader(in), &bytes.Buffer{})
+		if assert.Error(t, err, in) {
+			assert.Contains(t, err.Error(), expected, in)
+		}
+	}
+}
+
+func TestSandboxFrontMatterOut(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/in/ok.tmpl", []byte("---gomplate\nout: sub/ok.txt\n---\nok"), 0644)
+	_ = afero.WriteFile(fs, "/in/z-escape.tmpl", []byte("---gomplate\nout: ../escaped.txt\n---\nnope"), 0644)
+
+	err := RunTemplates(&Config{InputDir: "/in", OutputDir: "/out", Sandbox: []string{"fs-root=/"}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "isn't allowed by the sandbox policy")
+	assertFile(t, "/out/sub/ok.txt", "ok")
+	_, err = fs.Stat("/escaped.txt")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,236 @@
+package gomplate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// the largest request body a Server accepts
+const maxServerRequestBytes = 10 << 20
+
+// ServerOptions - options for a Server
+type ServerOptions struct {
+	// CacheTTL - how long data read from datasources (including contexts) is
+	// cached for, across requests. Data is cached for the life of the Server
+	// when zero.
+	CacheTTL time.Duration
+	// Timeout - the maximum time to render a single request. Requests are also
+	// canceled when the client goes away. There's no limit when zero.
+	Timeout time.Duration
+	// MaxRenders - the maximum number of templates rendered at once. Renders
+	// which outlive their requests (because they timed out) still count until
+	// they finish, so that they can't pile up. Four per CPU when zero.
+	MaxRenders int
+	// Fs - the filesystem nested templates and file: datasources are read
+	// from. The OS filesystem is used when nil.
+	Fs afero.Fs
+	// NoSandbox - render templates without a sandbox when the config has no
+	// sandbox settings. Otherwise the default sandbox policy is used, since
+	// the templates come from anyone who can reach the server.
+	NoSandbox bool
+}
+
+// Server - an http.Handler which renders templates POSTed to /render, with
+// the datasources, contexts, plugins, and nested templates it was configured
+// with. Data read from datasources is shared by all requests.
+//
+// The request body is either the template itself, or (with a Content-Type of
+// application/json) an object with a "template" field, and an optional
+// "context" field with additional values to add to the template context.
+//
+// The rendered output is returned with a 200 status. When the template fails
+// to render, a 422 status is returned, with a JSON object with an "errors"
+// field holding the error reports (see ErrorReports).
+//
+// GET /healthz always responds with a 200 status, for health checks.
+type Server struct {
+	r        *Renderer
+	contexts []string
+	timeout  time.Duration
+	// a slot for each render that may be in progress
+	renders chan struct{}
+}
+
+// serverRequest - a JSON request to a Server
+type serverRequest struct {
+	Template string                 `json:"template"`
+	Context  map[string]interface{} `json:"context"`
+}
+
+// NewServer - create a Server which renders templates with the datasources,
+// contexts, plugins, nested templates, and delimiters from the given config.
+// Templates are restricted by the default sandbox policy unless the config
+// has sandbox settings, or opts.NoSandbox is set. Close must be called when
+// the Server is no longer needed.
+func NewServer(o *Config, opts ServerOptions) (*Server, error) {
+	o.defaults()
+	settings := o.Sandbox
+	switch {
+	case opts.NoSandbox && len(settings) > 0:
+		return nil, errors.New("sandbox settings can't be given when the sandbox is turned off")
+	case len(settings) == 0 && !opts.NoSandbox:
+		settings = []string{"default"}
+	}
+	sandbox, err := parseSandbox(settings)
+	if err != nil {
+		return nil, err
+	}
+	maxRenders := opts.MaxRenders
+	if maxRenders <= 0 {
+		maxRenders = 4 * runtime.NumCPU()
+	}
+	r, err := NewRenderer(RenderOptions{
+		Datasources:       o.DataSources,
+		DatasourceHeaders: o.DataSourceHeaders,
+		Contexts:          o.Contexts,
+		Templates:         o.Templates,
+		Plugins:           o.Plugins,
+		Fs:                opts.Fs,
+		CacheTTL:          opts.CacheTTL,
+		LDelim:            o.LDelim,
+		RDelim:            o.RDelim,
+		MissingKey:        o.MissingKey,
+		Sandbox:           sandbox,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		r:        r,
+		contexts: o.Contexts,
+		timeout:  opts.Timeout,
+		renders:  make(chan struct{}, maxRenders),
+	}, nil
+}
+
+// Close - clean up datasources (for example, revoking Vault tokens)
+func (s *Server) Close() error {
+	return s.r.Close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/healthz" {
+		// nolint: errcheck
+		io.WriteString(w, "ok\n")
+		return
+	}
+	if req.URL.Path != "/render" {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sr, err := parseServerRequest(req.Header.Get("Content-Type"), http.MaxBytesReader(w, req.Body, maxServerRequestBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := req.Context()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	// rendering happens in the background so that a render blocked on a slow
+	// datasource doesn't hold up the response once the context is done. It
+	// keeps its slot until it finishes, so the number of renders is bounded
+	// even when requests time out.
+	out := &bytes.Buffer{}
+	done := make(chan error, 1)
+	select {
+	case s.renders <- struct{}{}:
+		go func() {
+			defer func() { <-s.renders }()
+			done <- s.render(ctx, sr, out)
+		}()
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		// nolint: errcheck
+		w.Write(out.Bytes())
+	case ctx.Err() == context.DeadlineExceeded:
+		http.Error(w, "timed out rendering template", http.StatusServiceUnavailable)
+	case ctx.Err() == context.Canceled:
+		// the client went away, so there's no-one to respond to
+	default:
+		writeServerError(w, err)
+	}
+}
+
+// parseServerRequest - read a request body, which is either a JSON request or
+// the template itself
+func parseServerRequest(contentType string, body io.Reader) (*serverRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/json" {
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read template")
+		}
+		return &serverRequest{Template: string(b)}, nil
+	}
+
+	sr := &serverRequest{}
+	err := json.NewDecoder(body).Decode(sr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse request")
+	}
+	return sr, nil
+}
+
+// render - render the requested template. Contexts are re-read for each
+// request (usually from the cache), so they're refreshed once the cache TTL
+// expires.
+func (s *Server) render(ctx context.Context, sr *serverRequest, out io.Writer) error {
+	tctx, err := createTmplContext(s.contexts, s.r.data)
+	if err != nil {
+		return err
+	}
+	if len(sr.Context) > 0 {
+		if _, ok := ctxValues(tctx); !ok {
+			return errors.New("a request context can't be combined with a root context (\".\")")
+		}
+		tctx = ctxLike(tctx, sr.Context)
+	}
+	tctx = s.r.g.sandbox.context(tctx)
+
+	// each request is parsed separately, so templates defined by one request
+	// can't be seen by another
+	g := s.r.g.fork(tctx)
+	t := &tplate{name: "<request>", contents: sr.Template, target: &ctxWriter{ctx: ctx, w: out}}
+	return g.runTemplate(t)
+}
+
+// writeServerError - respond with reports for an error rendering a template
+func writeServerError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	// nolint: errcheck
+	json.NewEncoder(w).Encode(struct {
+		Errors []ErrorReport `json:"errors"`
+	}{ErrorReports(err)})
+}
@@ -0,0 +1,180 @@
+package gomplate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func serverRequestTo(s *Server, method, path, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestServer(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/config.json", []byte(`{"name": "world"}`), 0644)
+	_ = afero.WriteFile(fs, "/t.tmpl", []byte(`Hello, {{ . }}`), 0644)
+
+	s, err := NewServer(&Config{
+		Contexts:  []string{"config=file:///config.json"},
+		Templates: []string{"t=/t.tmpl"},
+		Sandbox:   []string{"fs-root=/"},
+	}, ServerOptions{Fs: fs})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	w := serverRequestTo(s, "POST", "/render", "text/plain", `{{ template "t" .config.name }}!`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Hello, world!", w.Body.String())
+
+	w = serverRequestTo(s, "POST", "/render", "application/json",
+		`{"template": "{{ .config.name }} {{ .extra.n }}", "context": {"extra": {"n": 42}}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "world 42", w.Body.String())
+
+	w = serverRequestTo(s, "POST", "/render", "text/plain", "one\n{{ ds \"nope\" }}")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	resp := struct{ Errors []ErrorReport }{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Errors, 1)
+	assert.Equal(t, 2, resp.Errors[0].Line)
+	assert.Equal(t, "nope", resp.Errors[0].Datasource)
+
+	w = serverRequestTo(s, "POST", "/render", "application/json", `{"template": `)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serverRequestTo(s, "GET", "/render", "", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = serverRequestTo(s, "POST", "/", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = serverRequestTo(s, "GET", "/healthz", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestServerSandbox(t *testing.T) {
+	s, err := NewServer(&Config{}, ServerOptions{})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	w := serverRequestTo(s, "POST", "/render", "", `{{ getenv "HOME" }}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "not allowed by the sandbox policy")
+
+	w = serverRequestTo(s, "POST", "/render", "", `{{ strings.ToUpper "ok" }}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "OK", w.Body.String())
+
+	u, err := NewServer(&Config{}, ServerOptions{NoSandbox: true})
+	assert.NoError(t, err)
+	defer u.Close()
+
+	w = serverRequestTo(u, "POST", "/render", "", `{{ getenv "NOPE_NOT_SET" "unsandboxed" }}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "unsandboxed", w.Body.String())
+
+	_, err = NewServer(&Config{Sandbox: []string{"no-network"}}, ServerOptions{NoSandbox: true})
+	assert.Error(t, err)
+}
+
+func TestServerRequestsAreIsolated(t *testing.T) {
+	s, err := NewServer(&Config{}, ServerOptions{})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	wg := &sync.WaitGroup{}
+	for _, v := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+		go func(v string) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				w := serverRequestTo(s, "POST", "/render", "",
+					`{{ define "x" }}`+v+`{{ end }}{{ template "x" }}`)
+				assert.Equal(t, v, w.Body.String())
+			}
+		}(v)
+	}
+	wg.Wait()
+}
+
+func TestServerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	s, err := NewServer(&Config{
+		DataSources: []string{"slow=" + slow.URL},
+	}, ServerOptions{Timeout: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	w := serverRequestTo(s, "POST", "/render", "", `{{ ds "slow" }}done`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestServerMaxRenders(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	s, err := NewServer(&Config{
+		DataSources: []string{"slow=" + slow.URL},
+	}, ServerOptions{Timeout: 10 * time.Millisecond, MaxRenders: 1})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	// the first render times out, but is still waiting for the datasource,
+	// so there's no room for the second, even though it's quick
+	w := serverRequestTo(s, "POST", "/render", "", `{{ ds "slow" }}done`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = serverRequestTo(s, "POST", "/render", "", `quick`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestServerCacheTTL(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/config.json", []byte(`{"v": 1}`), 0644)
+
+	s, err := NewServer(&Config{
+		Contexts: []string{"config=file:///config.json"},
+		Sandbox:  []string{"fs-root=/"},
+	}, ServerOptions{CacheTTL: 20 * time.Millisecond, Fs: fs})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	_ = afero.WriteFile(fs, "/config.json", []byte(`{"v": 2}`), 0644)
+	w := serverRequestTo(s, "POST", "/render", "", `{{ .config.v }}`)
+	assert.Equal(t, "1", w.Body.String())
+
+	time.Sleep(30 * time.Millisecond)
+	w = serverRequestTo(s, "POST", "/render", "", `{{ .config.v }}`)
+	assert.Equal(t, "2", w.Body.String())
+}
@@ -7,6 +7,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"text/template"
 
 	"github.com/hairyhenderson/gomplate/tmpl"
@@ -37,6 +40,37 @@ type tplate struct {
 	contents     string
 	mode         os.FileMode
 	modeOverride bool
+
+	// skipUnchanged - leave the target untouched when the output is identical
+	skipUnchanged bool
+
+	// stdout - where output to "-" is written (Stdout when nil)
+	stdout io.WriteCloser
+
+	// item - the --foreach item this template is rendered for, when hasItem
+	item    interface{}
+	hasItem bool
+
+	// front - the settings from the template's front matter, if any
+	front *frontMatter
+	// origTargetPath - the output path, before front matter changed it
+	origTargetPath string
+	// frontMatterLines - the number of lines of front matter stripped from
+	// the start of the template, for reporting error positions
+	frontMatterLines int
+
+	// extra - the additional outputs written with out.Write while rendering,
+	// and extraPaths, the paths of those written once rendering succeeded
+	extra      *extraOutputs
+	extraPaths []string
+
+	// written - whether the output was written, once rendered. It isn't when
+	// the template is skipped, or empty output is suppressed.
+	written bool
+
+	// funcs - functions bound to this render only, which replace the
+	// gomplate's functions of the same names (see Renderer.Render)
+	funcs template.FuncMap
 }
 
 func addTmplFuncs(f template.FuncMap, root *template.Template, ctx interface{}) {
@@ -46,68 +80,209 @@ func addTmplFuncs(f template.FuncMap, root *template.Template, ctx interface{})
 	f["tpl"] = t.Inline
 }
 
-func (t *tplate) toGoTemplate(g *gomplate) (tmpl *template.Template, err error) {
-	if g.rootTemplate != nil {
-		tmpl = g.rootTemplate.New(t.name)
-	} else {
-		tmpl = template.New(t.name)
-		g.rootTemplate = tmpl
-	}
-	tmpl.Option("missingkey=error")
-	// the "tmpl" funcs get added here because they need access to the root template and context
-	addTmplFuncs(g.funcMap, g.rootTemplate, g.tmplctx)
-	tmpl.Funcs(g.funcMap)
-	tmpl.Delims(g.leftDelim, g.rightDelim)
-	_, err = tmpl.Parse(t.contents)
+// toGoTemplate - parse the template, with the "tmpl" functions bound to the
+// given context. Each template is parsed into a clone of the base template,
+// so the nested templates are only parsed once, and templates defined by one
+// template can't be seen by others. The returned template is the one to
+// execute - for a template which extends a layout, that's the layout.
+func (t *tplate) toGoTemplate(g *gomplate, tctx interface{}) (*template.Template, error) {
+	base, sources, err := g.baseTemplate()
 	if err != nil {
 		return nil, err
 	}
-	for alias, path := range g.nestedTemplates {
-		// nolint: gosec
-		b, err := ioutil.ReadFile(path)
+	root, err := base.Clone()
+	if err != nil {
+		return nil, err
+	}
+	tmpl := root.New(t.name)
+	// the "tmpl" funcs get added here because they need access to the template and context
+	funcs := template.FuncMap{}
+	addTmplFuncs(funcs, tmpl, tctx)
+	g.sandbox.restrict("tmpl", funcs)
+	outFuncs := template.FuncMap{}
+	addOutFuncs(outFuncs, t.extra)
+	g.sandbox.restrict("out", outFuncs)
+	for k, v := range outFuncs {
+		funcs[k] = v
+	}
+	for k, v := range t.funcs {
+		funcs[k] = v
+	}
+	// as are the user-defined functions, from the nested templates and this one
+	ldelim, rdelim := t.delims(g)
+	names := append(definedUserFuncs(base), userFuncNames(t.contents, ldelim)...)
+	if err = g.addUserFuncs(funcs, tmpl, tctx, unique(names)...); err != nil {
+		return nil, err
+	}
+	tmpl.Funcs(funcs)
+	// the layouts are parsed first, so that this template's blocks override
+	// theirs
+	exec := t.name
+	if layout, ok := templateExtends(t.contents, ldelim, rdelim); ok {
+		exec, err = g.assembleLayout(tmpl, sources, t.name, layout)
 		if err != nil {
 			return nil, err
 		}
-		_, err = tmpl.New(alias).Parse(string(b))
+	}
+	tmpl.Delims(ldelim, rdelim)
+	_, err = tmpl.Parse(t.contents)
+	if err != nil {
+		return nil, err
+	}
+	trees := newTrees(base, tmpl)
+	if err = g.sandbox.checkEnv(tctx, trees...); err != nil {
+		return nil, err
+	}
+	if g.nilValueFuncFor(g.missingKey) != nil {
+		handleNilValues(trees...)
+	}
+	return tmpl.Lookup(exec), nil
+}
+
+// baseTemplate - the template all others are cloned from, holding the nested
+// templates, and the nested templates' sources. It's parsed on first use.
+func (g *gomplate) baseTemplate() (*template.Template, map[string]string, error) {
+	g.baseMu.Lock()
+	defer g.baseMu.Unlock()
+	if g.base == nil && g.baseErr == nil {
+		g.base, g.baseSources, g.baseErr = g.parseNestedTemplates()
+	}
+	return g.base, g.baseSources, g.baseErr
+}
+
+// resetBaseTemplate - discard the parsed nested templates, so they're parsed
+// again (i.e. when they've changed)
+func (g *gomplate) resetBaseTemplate() {
+	g.baseMu.Lock()
+	defer g.baseMu.Unlock()
+	g.base, g.baseSources, g.baseErr = nil, nil, nil
+}
+
+// parseNestedTemplates - parse the nested templates (with the configured
+// delimiters) into a new base template. Their sources are returned too, by
+// alias.
+func (g *gomplate) parseNestedTemplates() (*template.Template, map[string]string, error) {
+	funcMap := make(template.FuncMap, len(g.funcMap)+3)
+	for k, v := range g.funcMap {
+		funcMap[k] = v
+	}
+	base := template.New("")
+	// the "tmpl" funcs are bound to each clone, but they need to be known
+	// when nested templates are parsed
+	addTmplFuncs(funcMap, base, nil)
+	addOutFuncs(funcMap, nil)
+	opt, err := missingKeyOption(g.missingKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	nilValue := g.nilValueFuncFor(g.missingKey)
+	if nilValue != nil {
+		funcMap[nilValueFunc] = nilValue
+	}
+	base.Option(opt)
+	base.Delims(g.leftDelim, g.rightDelim)
+
+	aliases := make([]string, 0, len(g.nestedTemplates))
+	for alias := range g.nestedTemplates {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	// the nested templates are all read before any are parsed, since the
+	// functions they define must be known first
+	sources := make(map[string]string, len(aliases))
+	userFuncs := []string{}
+	for _, alias := range aliases {
+		s, err := readNestedTemplate(g.fs, g.data, g.nestedTemplates[alias])
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		sources[alias] = s
+		if name, ok := userFuncName(alias); ok {
+			userFuncs = append(userFuncs, name)
+		}
+		userFuncs = append(userFuncs, userFuncNames(s, g.leftDelim)...)
 	}
-	return tmpl, nil
+	if err = g.addUserFuncs(funcMap, base, nil, unique(userFuncs)...); err != nil {
+		return nil, nil, err
+	}
+	base.Funcs(funcMap)
+	// templates extending layouts are parsed before the layouts, so that the
+	// blocks they override keep the layouts' definitions here
+	depths := make(map[string]int, len(aliases))
+	for _, alias := range aliases {
+		depths[alias] = g.layoutDepth(sources, alias)
+	}
+	sort.SliceStable(aliases, func(i, j int) bool {
+		return depths[aliases[i]] > depths[aliases[j]]
+	})
+	for _, alias := range aliases {
+		_, err = base.New(alias).Parse(sources[alias])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if nilValue != nil {
+		handleNilValues(newTrees(template.New(""), base)...)
+	}
+	return base, sources, nil
 }
 
 // loadContents - reads the template in _once_ if it hasn't yet been read. Uses the name!
-func (t *tplate) loadContents() (err error) {
-	if t.contents == "" {
-		t.contents, err = readInput(t.name)
+// Front matter is stripped from the contents, and applied to the template.
+func (t *tplate) loadContents(fs afero.Fs) (err error) {
+	if t.contents != "" {
+		return nil
 	}
-	return err
+	t.contents, err = readInput(fs, t.name)
+	if err != nil {
+		return err
+	}
+	fm, body, err := parseFrontMatter(t.name, t.contents)
+	if err != nil || fm == nil {
+		return err
+	}
+	t.frontMatterLines = strings.Count(t.contents[:len(t.contents)-len(body)], "\n")
+	t.contents = body
+	return t.applyFrontMatter(fs, fm)
 }
 
-func (t *tplate) addTarget() (err error) {
+func (t *tplate) addTarget(fs afero.Fs) (err error) {
 	if t.name == "<arg>" && t.targetPath == "" {
 		t.targetPath = "-"
 	}
 	if t.target == nil {
-		t.target, err = openOutFile(t.targetPath, t.mode, t.modeOverride)
+		stdout := t.stdout
+		if stdout == nil {
+			stdout = Stdout
+		}
+		t.target, err = openOutFile(fs, t.targetPath, stdout, t.mode, t.modeOverride, t.skipUnchanged)
 	}
 	return err
 }
 
-// gatherTemplates - gather and prepare input template(s) and output file(s) for rendering
+// reset - prepare the template to be rendered again, by re-reading its
+// contents (unless it was given inline or on stdin) and re-opening its target
+func (t *tplate) reset(fs afero.Fs) error {
+	if t.name != "<arg>" && t.name != "-" {
+		t.contents = ""
+	}
+	t.target = nil
+	if err := t.loadContents(fs); err != nil {
+		return err
+	}
+	return t.addTarget(fs)
+}
+
+// gatherTemplates - gather and prepare input template(s) and output file(s) for
+// rendering. Output to "-" is written to stdout.
 // nolint: gocyclo
-func gatherTemplates(o *Config, outFileNamer func(string) (string, error)) (templates []*tplate, err error) {
+func gatherTemplates(fs afero.Fs, o *Config, stdout io.WriteCloser, outFileNamer func(string) (string, error)) (templates []*tplate, err error) {
 	o.defaults()
 	mode, modeOverride, err := o.getMode()
 	if err != nil {
 		return nil, err
 	}
 
-	// --exec-pipe redirects standard out to the out pipe
-	if o.Out != nil {
-		Stdout = &nopWCloser{o.Out}
-	}
-
 	switch {
 	// the arg-provided input string gets a special name
 	case o.Input != "":
@@ -120,30 +295,42 @@ func gatherTemplates(o *Config, outFileNamer func(string) (string, error)) (temp
 		}}
 	case o.InputDir != "":
 		// input dirs presume output dirs are set too
-		templates, err = walkDir(o.InputDir, outFileNamer, o.ExcludeGlob, mode, modeOverride)
+		templates, err = walkDir(fs, o.InputDir, outFileNamer, o.ExcludeGlob, mode, modeOverride)
 		if err != nil {
 			return nil, err
 		}
 	case o.Input == "":
+		if len(o.InputFiles) != len(o.OutputFiles) {
+			return nil, errors.Errorf("must provide same number of outputFiles (%d) as inputFiles (%d)", len(o.OutputFiles), len(o.InputFiles))
+		}
 		templates = make([]*tplate, len(o.InputFiles))
 		for i := range o.InputFiles {
-			templates[i], err = fileToTemplates(o.InputFiles[i], o.OutputFiles[i], mode, modeOverride)
+			templates[i], err = fileToTemplates(fs, o.InputFiles[i], o.OutputFiles[i], mode, modeOverride)
 			if err != nil {
 				return nil, err
 			}
 		}
 	}
 
-	return processTemplates(templates)
+	for _, t := range templates {
+		t.skipUnchanged = o.SkipUnchanged
+		t.stdout = stdout
+	}
+
+	return processTemplates(fs, templates)
 }
 
-func processTemplates(templates []*tplate) ([]*tplate, error) {
-	for _, t := range templates {
-		if err := t.loadContents(); err != nil {
-			return nil, err
+// processTemplates - load the templates' contents and open their targets.
+// When any fails, the targets opened so far are aborted, so no temporary
+// files are left behind.
+func processTemplates(fs afero.Fs, templates []*tplate) ([]*tplate, error) {
+	for i, t := range templates {
+		err := t.loadContents(fs)
+		if err == nil {
+			err = t.addTarget(fs)
 		}
-
-		if err := t.addTarget(); err != nil {
+		if err != nil {
+			abortTargets(templates[:i])
 			return nil, err
 		}
 	}
@@ -154,7 +341,7 @@ func processTemplates(templates []*tplate) ([]*tplate, error) {
 // walkDir - given an input dir `dir` and an output dir `outDir`, and a list
 // of .gomplateignore and exclude globs (if any), walk the input directory and create a list of
 // tplate objects, and an error, if any.
-func walkDir(dir string, outFileNamer func(string) (string, error), excludeGlob []string, mode os.FileMode, modeOverride bool) ([]*tplate, error) {
+func walkDir(fs afero.Fs, dir string, outFileNamer func(string) (string, error), excludeGlob []string, mode os.FileMode, modeOverride bool) ([]*tplate, error) {
 	dir = filepath.Clean(dir)
 
 	dirStat, err := fs.Stat(dir)
@@ -164,18 +351,10 @@ func walkDir(dir string, outFileNamer func(string) (string, error), excludeGlob
 	dirMode := dirStat.Mode()
 
 	templates := make([]*tplate, 0)
-	matcher := xignore.NewMatcher(fs)
-	matches, err := matcher.Matches(dir, &xignore.MatchesOptions{
-		Ignorefile:    gomplateignore,
-		Nested:        true, // allow nested ignorefile
-		AfterPatterns: excludeGlob,
-	})
+	files, err := listDir(fs, dir, excludeGlob)
 	if err != nil {
 		return nil, err
 	}
-
-	// Unmatched ignorefile rules's files
-	files := matches.UnmatchedFiles
 	for _, file := range files {
 		nextInPath := filepath.Join(dir, file)
 		nextOutPath, err := outFileNamer(file)
@@ -209,7 +388,24 @@ func walkDir(dir string, outFileNamer func(string) (string, error), excludeGlob
 	return templates, nil
 }
 
-func fileToTemplates(inFile, outFile string, mode os.FileMode, modeOverride bool) (*tplate, error) {
+// listDir - list the files in the input directory dir (relative to dir),
+// excluding those matched by .gomplateignore files or the exclude globs
+func listDir(fs afero.Fs, dir string, excludeGlob []string) ([]string, error) {
+	matcher := xignore.NewMatcher(fs)
+	matches, err := matcher.Matches(dir, &xignore.MatchesOptions{
+		Ignorefile:    gomplateignore,
+		Nested:        true, // allow nested ignorefile
+		AfterPatterns: excludeGlob,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmatched ignorefile rules's files
+	return matches.UnmatchedFiles, nil
+}
+
+func fileToTemplates(fs afero.Fs, inFile, outFile string, mode os.FileMode, modeOverride bool) (*tplate, error) {
 	if inFile != "-" {
 		si, err := fs.Stat(inFile)
 		if err != nil {
@@ -229,35 +425,178 @@ func fileToTemplates(inFile, outFile string, mode os.FileMode, modeOverride bool
 	return tmpl, nil
 }
 
-func openOutFile(filename string, mode os.FileMode, modeOverride bool) (out io.WriteCloser, err error) {
+// openOutFile - open the named output file, or stdout when the name is "-"
+func openOutFile(fs afero.Fs, filename string, stdout io.WriteCloser, mode os.FileMode, modeOverride, skipUnchanged bool) (out io.WriteCloser, err error) {
 	if conv.ToBool(env.Getenv("GOMPLATE_SUPPRESS_EMPTY", "false")) {
 		out = newEmptySkipper(func() (io.WriteCloser, error) {
 			if filename == "-" {
-				return Stdout, nil
+				return stdout, nil
 			}
-			return createOutFile(filename, mode, modeOverride)
+			return createOutFile(fs, filename, mode, modeOverride, skipUnchanged)
 		})
 		return out, nil
 	}
 
 	if filename == "-" {
-		return Stdout, nil
+		return stdout, nil
 	}
-	return createOutFile(filename, mode, modeOverride)
+	return createOutFile(fs, filename, mode, modeOverride, skipUnchanged)
 }
 
-func createOutFile(filename string, mode os.FileMode, modeOverride bool) (out io.WriteCloser, err error) {
-	out, err = fs.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode.Perm())
+// createOutFile - create a writer for the named output file. Output is written
+// to a temporary file in the same directory, which only replaces the named file
+// when the writer is closed, so a failed render never leaves a partially-written
+// file behind. Special files (like FIFOs and devices) are written in place
+// instead, since replacing them would turn them into regular files.
+func createOutFile(fs afero.Fs, filename string, mode os.FileMode, modeOverride, skipUnchanged bool) (out io.WriteCloser, err error) {
+	filename = resolveSymlink(fs, filename)
+
+	perm := mode.Perm()
+	// the new file must end up with the mode the existing file had, unless
+	// it's being overridden. New files are subject to the umask, as usual.
+	chmod := modeOverride
+	if fi, err := fs.Stat(filename); err == nil {
+		// special files (like FIFOs or /dev/null) can't be replaced, so
+		// they're written to directly
+		if !fi.Mode().IsRegular() && !fi.IsDir() {
+			return fs.OpenFile(filename, os.O_WRONLY|os.O_TRUNC, 0)
+		}
+		if !modeOverride {
+			perm = fi.Mode().Perm()
+		}
+		chmod = true
+	}
+
+	tmp, err := createTempFile(fs, filename, perm)
 	if err != nil {
-		return out, err
+		return nil, err
+	}
+	if chmod {
+		err = fs.Chmod(tmp.Name(), perm)
+		if err != nil {
+			// nolint: errcheck
+			tmp.Close()
+			// nolint: errcheck
+			fs.Remove(tmp.Name())
+			return nil, err
+		}
 	}
-	if modeOverride {
-		err = fs.Chmod(filename, mode.Perm())
+	return &atomicFile{
+		File:          tmp,
+		fs:            fs,
+		path:          filename,
+		perm:          perm,
+		modeOverride:  modeOverride,
+		skipUnchanged: skipUnchanged,
+	}, nil
+}
+
+// resolveSymlink - when the output file is a symlink, the file it links to
+// must be replaced, not the link itself
+func resolveSymlink(fs afero.Fs, filename string) string {
+	l, ok := fs.(afero.Lstater)
+	if !ok {
+		return filename
+	}
+	fi, lstat, err := l.LstatIfPossible(filename)
+	if err != nil || !lstat || fi.Mode()&os.ModeSymlink == 0 {
+		return filename
+	}
+	// symlinks are only reported by OS-backed filesystems
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		return resolved
+	}
+	return filename
+}
+
+// distinguishes temp files created by this process
+var tempFileCount uint64
+
+// createTempFile - create a new temporary file alongside filename, so that it
+// can be renamed atomically into place
+func createTempFile(fs afero.Fs, filename string, perm os.FileMode) (f afero.File, err error) {
+	dir, base := filepath.Split(filename)
+	for i := 0; i < 100; i++ {
+		n := atomic.AddUint64(&tempFileCount, 1)
+		name := filepath.Join(dir, fmt.Sprintf(".%s.%d.%d.tmp", base, os.Getpid(), n))
+		f, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) {
+			break
+		}
 	}
-	return out, err
+	return f, err
 }
 
-func readInput(filename string) (string, error) {
+// aborter is implemented by writers which can discard everything written so
+// far, instead of committing it on Close
+type aborter interface {
+	Abort() error
+}
+
+// atomicFile is an io.WriteCloser that writes to a temporary file, which
+// replaces the file at path only on Close. Abort discards the output instead.
+type atomicFile struct {
+	afero.File
+
+	// the filesystem the file is written to
+	fs            afero.Fs
+	path          string
+	perm          os.FileMode
+	modeOverride  bool
+	skipUnchanged bool
+}
+
+func (f *atomicFile) Close() error {
+	tmpName := f.File.Name()
+	err := f.File.Close()
+	if err != nil {
+		// nolint: errcheck
+		f.fs.Remove(tmpName)
+		return err
+	}
+
+	if f.skipUnchanged && sameContents(f.fs, tmpName, f.path) {
+		err = f.fs.Remove(tmpName)
+		if err != nil {
+			return err
+		}
+		if f.modeOverride {
+			return f.fs.Chmod(f.path, f.perm)
+		}
+		return nil
+	}
+
+	err = f.fs.Rename(tmpName, f.path)
+	if err != nil {
+		// nolint: errcheck
+		f.fs.Remove(tmpName)
+		return errors.Wrapf(err, "failed to write %s", f.path)
+	}
+	return nil
+}
+
+// Abort - discard the output, leaving the file at path untouched
+func (f *atomicFile) Abort() error {
+	// nolint: errcheck
+	f.File.Close()
+	return f.fs.Remove(f.File.Name())
+}
+
+// sameContents - whether the two files have identical contents. Missing or
+// unreadable files are never the same.
+func sameContents(fs afero.Fs, a, b string) bool {
+	ab, err := afero.ReadFile(fs, a)
+	if err != nil {
+		return false
+	}
+	bb, err := afero.ReadFile(fs, b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ab, bb)
+}
+
+func readInput(fs afero.Fs, filename string) (string, error) {
 	var err error
 	var inFile io.ReadCloser
 	if filename == "-" {
@@ -332,6 +671,21 @@ func (f *emptySkipper) Close() error {
 	return nil
 }
 
+// Abort - abort the wrapped writer, if it's been opened and can be aborted
+func (f *emptySkipper) Abort() error {
+	if a, ok := f.w.(aborter); ok {
+		return a.Abort()
+	}
+	return f.Close()
+}
+
+// suppressed - whether the output written to w was suppressed, because it was
+// empty
+func suppressed(w io.Writer) bool {
+	s, ok := w.(*emptySkipper)
+	return ok && s.w == nil
+}
+
 func allWhitespace(p []byte) bool {
 	for _, b := range p {
 		if b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' {
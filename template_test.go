@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 
@@ -23,18 +24,18 @@ func TestReadInput(t *testing.T) {
 	f, _ = fs.Create("/tmp/unreadable")
 	_, _ = f.Write([]byte("foo"))
 
-	actual, err := readInput("/tmp/foo")
+	actual, err := readInput(fs, "/tmp/foo")
 	assert.NoError(t, err)
 	assert.Equal(t, "foo", actual)
 
 	defer func() { stdin = os.Stdin }()
 	stdin = ioutil.NopCloser(bytes.NewBufferString("bar"))
 
-	actual, err = readInput("-")
+	actual, err = readInput(fs, "-")
 	assert.NoError(t, err)
 	assert.Equal(t, "bar", actual)
 
-	_, err = readInput("bogus")
+	_, err = readInput(fs, "bogus")
 	assert.Error(t, err)
 }
 
@@ -44,8 +45,12 @@ func TestOpenOutFile(t *testing.T) {
 	fs = afero.NewMemMapFs()
 	_ = fs.Mkdir("/tmp", 0777)
 
-	_, err := openOutFile("/tmp/foo", 0644, false)
+	f, err := openOutFile(fs, "/tmp/foo", Stdout, 0644, false, false)
 	assert.NoError(t, err)
+	// the file is only written once closed
+	_, err = fs.Stat("/tmp/foo")
+	assert.Error(t, err)
+	assert.NoError(t, f.Close())
 	i, err := fs.Stat("/tmp/foo")
 	assert.NoError(t, err)
 	assert.Equal(t, os.FileMode(0644), i.Mode())
@@ -53,7 +58,7 @@ func TestOpenOutFile(t *testing.T) {
 	defer func() { Stdout = os.Stdout }()
 	Stdout = &nopWCloser{&bytes.Buffer{}}
 
-	f, err := openOutFile("-", 0644, false)
+	f, err = openOutFile(fs, "-", Stdout, 0644, false, false)
 	assert.NoError(t, err)
 	assert.Equal(t, Stdout, f)
 }
@@ -66,7 +71,7 @@ func TestLoadContents(t *testing.T) {
 	afero.WriteFile(fs, "foo", []byte("contents"), 0644)
 
 	tmpl := &tplate{name: "foo"}
-	err := tmpl.loadContents()
+	err := tmpl.loadContents(fs)
 	assert.NoError(t, err)
 	assert.Equal(t, "contents", tmpl.contents)
 }
@@ -77,7 +82,7 @@ func TestAddTarget(t *testing.T) {
 	fs = afero.NewMemMapFs()
 
 	tmpl := &tplate{name: "foo", targetPath: "/out/outfile"}
-	err := tmpl.addTarget()
+	err := tmpl.addTarget(fs)
 	assert.NoError(t, err)
 	assert.NotNil(t, tmpl.target)
 }
@@ -92,64 +97,67 @@ func TestGatherTemplates(t *testing.T) {
 	afero.WriteFile(fs, "in/2", []byte("bar"), 0644)
 	afero.WriteFile(fs, "in/3", []byte("baz"), 0644)
 
-	templates, err := gatherTemplates(&Config{}, nil)
+	templates, err := gatherTemplates(fs, &Config{}, Stdout, nil)
 	assert.NoError(t, err)
 	assert.Len(t, templates, 1)
 
-	templates, err = gatherTemplates(&Config{
+	templates, err = gatherTemplates(fs, &Config{
 		Input: "foo",
-	}, nil)
+	}, Stdout, nil)
 	assert.NoError(t, err)
 	assert.Len(t, templates, 1)
 	assert.Equal(t, "foo", templates[0].contents)
 	assert.Equal(t, Stdout, templates[0].target)
 
-	templates, err = gatherTemplates(&Config{
+	templates, err = gatherTemplates(fs, &Config{
 		Input:       "foo",
 		OutputFiles: []string{"out"},
-	}, nil)
+	}, Stdout, nil)
 	assert.NoError(t, err)
 	assert.Len(t, templates, 1)
 	assert.Equal(t, "out", templates[0].targetPath)
 	assert.Equal(t, os.FileMode(0644), templates[0].mode)
+	closeTargets(templates)
 	info, err := fs.Stat("out")
 	assert.NoError(t, err)
 	assert.Equal(t, os.FileMode(0644), info.Mode())
 	fs.Remove("out")
 
-	templates, err = gatherTemplates(&Config{
+	templates, err = gatherTemplates(fs, &Config{
 		InputFiles:  []string{"foo"},
 		OutputFiles: []string{"out"},
-	}, nil)
+	}, Stdout, nil)
 	assert.NoError(t, err)
 	assert.Len(t, templates, 1)
 	assert.Equal(t, "bar", templates[0].contents)
 	assert.NotEqual(t, Stdout, templates[0].target)
 	assert.Equal(t, os.FileMode(0600), templates[0].mode)
+	closeTargets(templates)
 	info, err = fs.Stat("out")
 	assert.NoError(t, err)
 	assert.Equal(t, os.FileMode(0600), info.Mode())
 	fs.Remove("out")
 
-	templates, err = gatherTemplates(&Config{
+	templates, err = gatherTemplates(fs, &Config{
 		InputFiles:  []string{"foo"},
 		OutputFiles: []string{"out"},
 		OutMode:     "755",
-	}, nil)
+	}, Stdout, nil)
 	assert.NoError(t, err)
 	assert.Len(t, templates, 1)
 	assert.Equal(t, "bar", templates[0].contents)
 	assert.NotEqual(t, Stdout, templates[0].target)
 	assert.Equal(t, os.FileMode(0755), templates[0].mode)
+	closeTargets(templates)
 	info, err = fs.Stat("out")
 	assert.NoError(t, err)
 	assert.Equal(t, os.FileMode(0755), info.Mode())
 	fs.Remove("out")
 
-	templates, err = gatherTemplates(&Config{
+	templates, err = gatherTemplates(fs, &Config{
 		InputDir:  "in",
 		OutputDir: "out",
-	}, simpleNamer("out"))
+	}, Stdout, simpleNamer("out"))
 	assert.NoError(t, err)
 	assert.Len(t, templates, 3)
 	assert.Equal(t, "foo", templates[0].contents)
@@ -221,9 +229,10 @@ func TestProcessTemplates(t *testing.T) {
 		},
 	}
 	for _, in := range testdata {
-		actual, err := processTemplates(in.templates)
+		actual, err := processTemplates(fs, in.templates)
 		assert.NoError(t, err)
 		assert.Len(t, actual, len(in.templates))
+		closeTargets(actual)
 		for i, a := range actual {
 			assert.Equal(t, in.contents[i], a.contents)
 			assert.Equal(t, in.templates[i].mode, a.mode)
@@ -295,3 +304,135 @@ type bufferCloser struct {
 func (b *bufferCloser) Close() error {
 	return nil
 }
+
+// closeTargets - outputs are only written once their targets are closed
+func closeTargets(templates []*tplate) {
+	for _, t := range templates {
+		if c, ok := t.target.(io.Closer); ok && t.targetPath != "-" {
+			// nolint: errcheck
+			c.Close()
+		}
+	}
+}
+
+func TestCreateOutFile(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/out/existing", []byte("old"), 0640)
+
+	// output replaces the file only when closed
+	f, err := createOutFile(fs, "/out/existing", 0644, false, false)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("new"))
+	assert.NoError(t, err)
+	assertFile(t, "/out/existing", "old")
+	assert.NoError(t, f.Close())
+	assertFile(t, "/out/existing", "new")
+	fi, err := fs.Stat("/out/existing")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode())
+
+	// aborted output is discarded
+	f, err = createOutFile(fs, "/out/existing", 0644, false, false)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("partial"))
+	assert.NoError(t, err)
+	assert.NoError(t, f.(aborter).Abort())
+	assertFile(t, "/out/existing", "new")
+	files, err := afero.ReadDir(fs, "/out")
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// unchanged output leaves the file untouched, but the mode is still set
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	_ = fs.Chtimes("/out/existing", past, past)
+	f, err = createOutFile(fs, "/out/existing", 0600, true, true)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("new"))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	fi, err = fs.Stat("/out/existing")
+	assert.NoError(t, err)
+	assert.Equal(t, past, fi.ModTime())
+	assert.Equal(t, os.FileMode(0600), fi.Mode())
+	files, err = afero.ReadDir(fs, "/out")
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// changed output is still written
+	f, err = createOutFile(fs, "/out/existing", 0600, false, true)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("newer"))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assertFile(t, "/out/existing", "newer")
+}
+
+func TestNestedTemplatesParsedOnce(t *testing.T) {
+	memfs := afero.NewMemMapFs()
+	_ = afero.WriteFile(memfs, "/p.t", []byte(`{{ define "inner" }}inner{{ end }}partial {{ . }}`), 0644)
+	g := &gomplate{
+		fs:              memfs,
+		nestedTemplates: templateAliases{"p": "/p.t"},
+	}
+
+	out := &bytes.Buffer{}
+	err := g.runTemplate(&tplate{name: "a", contents: `{{ template "p" "a" }} {{ template "inner" }}`, target: out})
+	assert.NoError(t, err)
+	assert.Equal(t, "partial a inner", out.String())
+
+	// the partial was parsed already, so changes aren't seen...
+	_ = afero.WriteFile(memfs, "/p.t", []byte(`changed`), 0644)
+	out.Reset()
+	err = g.runTemplate(&tplate{name: "b", contents: `{{ template "p" "b" }}`, target: out})
+	assert.NoError(t, err)
+	assert.Equal(t, "partial b", out.String())
+
+	// ...until it's reset
+	g.resetBaseTemplate()
+	out.Reset()
+	err = g.runTemplate(&tplate{name: "b", contents: `{{ template "p" "b" }}`, target: out})
+	assert.NoError(t, err)
+	assert.Equal(t, "changed", out.String())
+}
+
+func TestTemplatesAreIsolated(t *testing.T) {
+	g := &gomplate{}
+
+	out := &bytes.Buffer{}
+	err := g.runTemplate(&tplate{name: "a", contents: `{{ define "x" }}a{{ end }}{{ template "x" }}`, target: out})
+	assert.NoError(t, err)
+	assert.Equal(t, "a", out.String())
+
+	// templates defined by one template aren't visible to others
+	err = g.runTemplate(&tplate{name: "b", contents: `{{ template "x" }}`, target: &bytes.Buffer{}})
+	assert.Error(t, err)
+}
+
+func TestNestedTemplateErrors(t *testing.T) {
+	memfs := afero.NewMemMapFs()
+	_ = afero.WriteFile(memfs, "/p.t", []byte("one\n{{ .foo.bar }}"), 0644)
+	_ = afero.WriteFile(memfs, "/bad.t", []byte("one\ntwo {{ bogus }}"), 0644)
+	g := &gomplate{
+		fs:              memfs,
+		nestedTemplates: templateAliases{"p": "/p.t"},
+	}
+
+	err := g.runTemplate(&tplate{name: "a", contents: `{{ template "p" . }}`, target: &bytes.Buffer{}})
+	assert.Error(t, err)
+	r := ErrorReports(err)[0]
+	assert.Equal(t, "p", r.Template)
+	assert.Equal(t, 2, r.Line)
+	assert.Equal(t, []SourceLine{{1, "one"}, {2, "{{ .foo.bar }}"}}, r.Source)
+
+	g = &gomplate{
+		fs:              memfs,
+		nestedTemplates: templateAliases{"bad": "/bad.t"},
+	}
+	err = g.runTemplate(&tplate{name: "a", contents: `hi`, target: &bytes.Buffer{}})
+	assert.Error(t, err)
+	r = ErrorReports(err)[0]
+	assert.Equal(t, "bad", r.Template)
+	assert.Equal(t, 2, r.Line)
+}
@@ -3,6 +3,10 @@
 package gomplate
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -15,7 +19,7 @@ func TestWalkDir(t *testing.T) {
 	defer func() { fs = origfs }()
 	fs = afero.NewMemMapFs()
 
-	_, err := walkDir("/indir", simpleNamer("/outdir"), nil, 0, false)
+	_, err := walkDir(fs, "/indir", simpleNamer("/outdir"), nil, 0, false)
 	assert.Error(t, err)
 
 	_ = fs.MkdirAll("/indir/one", 0777)
@@ -24,7 +28,7 @@ func TestWalkDir(t *testing.T) {
 	afero.WriteFile(fs, "/indir/one/bar", []byte("bar"), 0664)
 	afero.WriteFile(fs, "/indir/two/baz", []byte("baz"), 0644)
 
-	templates, err := walkDir("/indir", simpleNamer("/outdir"), []string{"*/two"}, 0, false)
+	templates, err := walkDir(fs, "/indir", simpleNamer("/outdir"), []string{"*/two"}, 0, false)
 
 	assert.NoError(t, err)
 	expected := []*tplate{
@@ -41,3 +45,31 @@ func TestWalkDir(t *testing.T) {
 	}
 	assert.EqualValues(t, expected, templates)
 }
+
+func TestCreateOutFileFIFO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gomplate-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fifo := filepath.Join(dir, "out")
+	assert.NoError(t, syscall.Mkfifo(fifo, 0600))
+
+	read := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadFile(fifo)
+		read <- string(b)
+	}()
+
+	// the FIFO is written to, not replaced
+	f, err := createOutFile(afero.NewOsFs(), fifo, 0644, true, false)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assert.Equal(t, "hello", <-read)
+
+	fi, err := os.Lstat(fifo)
+	assert.NoError(t, err)
+	assert.True(t, fi.Mode()&os.ModeNamedPipe != 0)
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+}
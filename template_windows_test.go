@@ -15,7 +15,7 @@ func TestWalkDir(t *testing.T) {
 	defer func() { fs = origfs }()
 	fs = afero.NewMemMapFs()
 
-	_, err := walkDir(`C:\indir`, simpleNamer(`C:\outdir`), nil, 0, false)
+	_, err := walkDir(fs, `C:\indir`, simpleNamer(`C:\outdir`), nil, 0, false)
 	assert.Error(t, err)
 
 	_ = fs.MkdirAll(`C:\indir\one`, 0777)
@@ -24,7 +24,7 @@ func TestWalkDir(t *testing.T) {
 	afero.WriteFile(fs, `C:\indir\one\bar`, []byte("bar"), 0644)
 	afero.WriteFile(fs, `C:\indir\two\baz`, []byte("baz"), 0644)
 
-	templates, err := walkDir(`C:\indir`, simpleNamer(`C:\outdir`), []string{`*\two`}, 0, false)
+	templates, err := walkDir(fs, `C:\indir`, simpleNamer(`C:\outdir`), []string{`*\two`}, 0, false)
 
 	assert.NoError(t, err)
 	expected := []*tplate{
@@ -0,0 +1,134 @@
+//+build integration
+
+package integration
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type ArchiveSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&ArchiveSuite{})
+
+func (s *ArchiveSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithFile("config.json", `{"name": "world"}`),
+	)
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, f := range []struct {
+		name, content string
+		mode          int64
+	}{
+		{"tmpl/.gomplateignore", "*.bak\n", 0644},
+		{"tmpl/hello.txt", `hello, {{ (ds "config").name }}`, 0644},
+		{"tmpl/bin/run.sh", `echo {{ "run" }}`, 0755},
+		{"tmpl/skip.bak", "skipped", 0644},
+	} {
+		err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: f.mode, Size: int64(len(f.content)), Typeflag: tar.TypeReg})
+		assert.NilError(c, err)
+		_, err = tw.Write([]byte(f.content))
+		assert.NilError(c, err)
+	}
+	assert.NilError(c, tw.Close())
+	err := ioutil.WriteFile(filepath.Join(s.tmpDir.Path(), "bundle.tar"), buf.Bytes(), 0644)
+	assert.NilError(c, err)
+}
+
+func (s *ArchiveSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+// readTarGz - the modes and contents of the files in the archive, by name
+func readTarGz(c *C, p string) map[string]string {
+	f, err := os.Open(p)
+	assert.NilError(c, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NilError(c, err)
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		assert.NilError(c, err)
+		b, err := ioutil.ReadAll(tr)
+		assert.NilError(c, err)
+		files[hdr.Name] = os.FileMode(hdr.Mode).String() + " " + string(b)
+	}
+}
+
+func (s *ArchiveSuite) TestArchiveToArchive(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"--input-dir", "bundle.tar", "--output-dir", "out.tar.gz",
+			"-d", "config.json",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Success)
+
+	assert.DeepEqual(c, map[string]string{
+		"tmpl/":                "-rwxr-xr-x ",
+		"tmpl/.gomplateignore": "-rw-r--r-- *.bak\n",
+		"tmpl/hello.txt":       "-rw-r--r-- hello, world",
+		"tmpl/bin/":            "-rwxr-xr-x ",
+		"tmpl/bin/run.sh":      "-rwxr-xr-x echo run",
+	}, readTarGz(c, filepath.Join(s.tmpDir.Path(), "out.tar.gz")))
+
+	// nothing was unpacked
+	assert.Assert(c, fs.Equal(s.tmpDir.Path(), fs.Expected(c,
+		fs.WithFile("config.json", `{"name": "world"}`, fs.MatchAnyFileMode),
+		fs.WithFile("bundle.tar", "", fs.MatchAnyFileContent, fs.MatchAnyFileMode),
+		fs.WithFile("out.tar.gz", "", fs.MatchAnyFileContent, fs.MatchAnyFileMode),
+		fs.MatchAnyFileMode,
+	)))
+}
+
+func (s *ArchiveSuite) TestArchiveToDir(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"--input-dir", "bundle.tar", "--output-dir", "out",
+			"-d", "config.json",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Success)
+
+	assert.Assert(c, fs.Equal(filepath.Join(s.tmpDir.Path(), "out"), fs.Expected(c,
+		fs.WithDir("tmpl",
+			fs.WithFile(".gomplateignore", "*.bak\n", fs.WithMode(0644)),
+			fs.WithFile("hello.txt", "hello, world", fs.WithMode(0644)),
+			fs.WithDir("bin",
+				fs.WithFile("run.sh", "echo run", fs.WithMode(0755)),
+				fs.MatchAnyFileMode,
+			),
+			fs.MatchAnyFileMode,
+		),
+		fs.MatchAnyFileMode,
+	)))
+}
+
+func (s *ArchiveSuite) TestMissingArchive(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "--input-dir", "missing.zip", "--output-dir", "out"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: "missing.zip"})
+}
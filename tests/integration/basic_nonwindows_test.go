@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"time"
 
 	. "gopkg.in/check.v1"
 
@@ -71,3 +72,61 @@ func (s *BasicSuite) TestOverridesOutputModeWithChmod(c *C) {
 		assert.Equal(c, v.content, string(content))
 	}
 }
+
+func (s *BasicSuite) TestFailedRenderKeepsOutput(c *C) {
+	out := s.tmpDir.Join("two")
+	result := icmd.RunCommand(GomplateBin,
+		"-i", `partial{{ fail "oops" }}`,
+		"-o", out)
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: "oops"})
+
+	content, err := ioutil.ReadFile(out)
+	assert.NilError(c, err)
+	assert.Equal(c, "hello\n", string(content))
+	files, err := ioutil.ReadDir(s.tmpDir.Path())
+	assert.NilError(c, err)
+	assert.Equal(c, 2, len(files))
+}
+
+func (s *BasicSuite) TestSkipUnchanged(c *C) {
+	out := s.tmpDir.Join("two")
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.NilError(c, os.Chtimes(out, past, past))
+
+	result := icmd.RunCommand(GomplateBin,
+		"-i", "hello\n",
+		"-o", out,
+		"--skip-unchanged",
+		"--chmod", "0600")
+	result.Assert(c, icmd.Success)
+
+	info, err := os.Stat(out)
+	assert.NilError(c, err)
+	assert.Equal(c, past, info.ModTime())
+	assert.Equal(c, os.FileMode(0600), info.Mode())
+
+	result = icmd.RunCommand(GomplateBin,
+		"-i", "changed\n",
+		"-o", out,
+		"--skip-unchanged")
+	result.Assert(c, icmd.Success)
+
+	content, err := ioutil.ReadFile(out)
+	assert.NilError(c, err)
+	assert.Equal(c, "changed\n", string(content))
+}
+
+func (s *BasicSuite) TestWritesThroughSymlink(c *C) {
+	link := s.tmpDir.Join("link")
+	assert.NilError(c, os.Symlink(s.tmpDir.Join("two"), link))
+
+	result := icmd.RunCommand(GomplateBin, "-i", "linked", "-o", link)
+	result.Assert(c, icmd.Success)
+
+	info, err := os.Lstat(link)
+	assert.NilError(c, err)
+	assert.Assert(c, info.Mode()&os.ModeSymlink != 0)
+	content, err := ioutil.ReadFile(s.tmpDir.Join("two"))
+	assert.NilError(c, err)
+	assert.Equal(c, "linked", string(content))
+}
@@ -0,0 +1,65 @@
+//+build integration
+
+package integration
+
+import (
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type ConfigSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&ConfigSuite{})
+
+func (s *ConfigSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithFile("config.json", `{"foo": "bar"}`),
+		fs.WithFile(".gomplate.yaml", `in: '{{ (ds "config").foo }} [[ .Env.USER ]]'
+datasources:
+  config:
+    url: config.json
+`),
+		fs.WithFile("other.yaml", `in: '[[ "other" ]]'
+leftDelim: '[['
+rightDelim: ']]'
+`),
+	)
+}
+
+func (s *ConfigSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *ConfigSuite) TestReadsDefaultConfigFile(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "bar [[ .Env.USER ]]"})
+}
+
+func (s *ConfigSuite) TestFlagsOverrideConfigFile(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "-i", `{{ (ds "config").foo | strings.ToUpper }}`},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "BAR"})
+}
+
+func (s *ConfigSuite) TestConfigFlag(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "--config", "other.yaml"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "other"})
+
+	result = icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "--config", "missing.yaml"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: "missing.yaml"})
+}
@@ -0,0 +1,54 @@
+//+build integration
+
+package integration
+
+import (
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type DepfileSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&DepfileSuite{})
+
+func (s *DepfileSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("in",
+			fs.WithFile("a.txt", `{{ template "t" }} {{ (ds "config").name }}`),
+			fs.WithFile("b.txt", `{{ file.Read "extra file.txt" }}`),
+		),
+		fs.WithFile("t.tmpl", "t"),
+		fs.WithFile("config.json", `{"name": "a"}`),
+		fs.WithFile("extra file.txt", "extra"),
+	)
+}
+
+func (s *DepfileSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *DepfileSuite) TestDepfile(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"--input-dir", "in", "--output-dir", "out",
+			"-t", "t=t.tmpl",
+			"-d", "config.json",
+			"--depfile", "out.d",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Success)
+
+	assert.Assert(c, fs.Equal(s.tmpDir.Path(), fs.Expected(c,
+		fs.WithFile("out.d", `out/a.txt: in/a.txt t.tmpl config.json
+out/b.txt: in/b.txt t.tmpl extra\ file.txt
+`, fs.MatchAnyFileMode),
+		fs.MatchExtraFiles,
+		fs.MatchAnyFileMode,
+	)))
+}
@@ -0,0 +1,97 @@
+//+build integration
+
+package integration
+
+import (
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type DiffSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&DiffSuite{})
+
+func (s *DiffSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("in",
+			fs.WithFile("same.txt", `{{ "same" }}`),
+			fs.WithFile("changed.txt", `{{ "new" }}`),
+		),
+		fs.WithDir("out",
+			fs.WithFile("same.txt", "same"),
+			fs.WithFile("changed.txt", "old"),
+		),
+	)
+}
+
+func (s *DiffSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *DiffSuite) TestDiff(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "--input-dir", "in", "--output-dir", "out", "--diff"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: `--- out/changed.txt	(current)
++++ out/changed.txt	(rendered)
+@@ -1 +1 @@
+-old
+\ No newline at end of file
++new
+\ No newline at end of file
+`})
+
+	assert.Assert(c, fs.Equal(s.tmpDir.Join("out"), fs.Expected(c,
+		fs.WithFile("same.txt", "same", fs.MatchAnyFileMode),
+		fs.WithFile("changed.txt", "old", fs.MatchAnyFileMode),
+		fs.MatchAnyFileMode,
+	)))
+}
+
+func (s *DiffSuite) TestCheck(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "--input-dir", "in", "--output-dir", "out", "--check"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: "1 output(s) would change: out/changed.txt"})
+
+	result = icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "-f", "in/same.txt", "-o", "out/same.txt", "--check"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Success)
+}
+
+func (s *DiffSuite) TestCheckMode(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "-f", "in/same.txt", "-o", "out/same.txt", "--chmod", "755", "--diff", "--check"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Out: "--- out/same.txt\t(current, mode 0644)\n+++ out/same.txt\t(rendered, mode 0755)\n"})
+}
+
+func (s *DiffSuite) TestCheckNoWrites(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "-i", `{{ file.Write "out/written.txt" "hi" }}`, "-o", "out/same.txt", "--check"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: "nothing can be written with --diff or --check"})
+
+	result = icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "--plugin", "echo=echo", "-i", `{{ echo "hi" }}`, "-o", "out/same.txt", "--check"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: "plugin echo can't be run with --diff or --check"})
+
+	assert.Assert(c, fs.Equal(s.tmpDir.Join("out"), fs.Expected(c,
+		fs.WithFile("same.txt", "same", fs.MatchAnyFileMode),
+		fs.WithFile("changed.txt", "old", fs.MatchAnyFileMode),
+		fs.MatchAnyFileMode,
+	)))
+}
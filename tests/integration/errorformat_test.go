@@ -0,0 +1,59 @@
+//+build integration
+
+package integration
+
+import (
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type ErrorFormatSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&ErrorFormatSuite{})
+
+func (s *ErrorFormatSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithFile("in.txt", "one\n{{ ds \"config\" }}\nthree\n"),
+	)
+}
+
+func (s *ErrorFormatSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *ErrorFormatSuite) TestTextErrors(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "-f", "in.txt", "-o", "out.txt"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: `template: in.txt:2:3: executing "in.txt" at <ds "config">: error calling ds: Undefined datasource 'config'
+ --> in.txt:2:4
+  |
+1 | one
+2 | {{ ds "config" }}
+  |    ^
+3 | three
+  = function: ds
+  = call: ds "config"
+  = datasource: config
+`})
+}
+
+func (s *ErrorFormatSuite) TestJSONErrors(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "-f", "in.txt", "-o", "out.txt", "--error-format", "json"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: `{"message":"template: in.txt:2:3: executing \"in.txt\" at <ds \"config\">: error calling ds: Undefined datasource 'config'","template":"in.txt","line":2,"column":4,"source":[{"line":1,"text":"one"},{"line":2,"text":"{{ ds \"config\" }}"},{"line":3,"text":"three"}],"function":"ds","call":"ds \"config\"","datasource":"config"}
+`})
+
+	result = icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "-i", "hi", "--error-format", "xml"},
+		Dir:     s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: `unsupported error format "xml"`})
+}
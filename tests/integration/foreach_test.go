@@ -0,0 +1,64 @@
+//+build integration
+
+package integration
+
+import (
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type ForEachSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&ForEachSuite{})
+
+func (s *ForEachSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithFile("tenants.yaml", "tenants:\n- name: acme\n  replicas: 3\n- name: initech\n  replicas: 1\n"),
+		fs.WithFile("deploy.yaml.tmpl", "name: {{ .item.name }}\nreplicas: {{ .item.replicas }}\n"),
+	)
+}
+
+func (s *ForEachSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *ForEachSuite) TestForEach(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"-d", "tenants.yaml",
+			"--foreach", "tenants:.tenants",
+			"-f", "deploy.yaml.tmpl",
+			"--output-map", "out/{{ .item.name }}/{{ .in | strings.TrimSuffix `.tmpl` }}",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Success)
+
+	for name, expected := range map[string]string{
+		"acme":    "name: acme\nreplicas: 3\n",
+		"initech": "name: initech\nreplicas: 1\n",
+	} {
+		out, err := ioutil.ReadFile(s.tmpDir.Join("out", name, "deploy.yaml"))
+		assert.NilError(c, err)
+		assert.Equal(c, expected, string(out))
+	}
+}
+
+func (s *ForEachSuite) TestForEachRequiresOutputMap(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"-d", "tenants.yaml",
+			"--foreach", "tenants:.tenants",
+			"-f", "deploy.yaml.tmpl",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: "--output-map must be set when --foreach is set"})
+}
@@ -0,0 +1,61 @@
+//+build integration
+
+package integration
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type FrontMatterSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&FrontMatterSuite{})
+
+func (s *FrontMatterSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("in",
+			fs.WithFile("plain.txt", `{{ "hello" | toUpper }}`),
+			fs.WithFile("chart.yaml", "---gomplate\nleftDelim: '[['\nrightDelim: ']]'\n---\nname: [[ .Env.USER ]]\nvalue: {{ .Values.foo }}\n"),
+			fs.WithFile("secret.tmpl", "---gomplate\nout: secret.txt\nchmod: 0600\n---\ns3cr3t\n"),
+			fs.WithFile("skipped.txt", "---gomplate\nskip: '{{ eq .Env.USER \"skipper\" }}'\n---\nnope\n"),
+		),
+	)
+}
+
+func (s *FrontMatterSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *FrontMatterSuite) TestFrontMatter(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "--input-dir", "in", "--output-dir", "out"},
+		Dir:     s.tmpDir.Path(),
+		Env:     []string{"USER=skipper"},
+	})
+	result.Assert(c, icmd.Success)
+
+	for name, expected := range map[string]string{
+		"plain.txt":  "HELLO",
+		"chart.yaml": "name: skipper\nvalue: {{ .Values.foo }}\n",
+		"secret.txt": "s3cr3t\n",
+	} {
+		out, err := ioutil.ReadFile(s.tmpDir.Join("out", name))
+		assert.NilError(c, err)
+		assert.Equal(c, expected, string(out))
+	}
+
+	fi, err := os.Stat(s.tmpDir.Join("out", "secret.txt"))
+	assert.NilError(c, err)
+	assert.Equal(c, os.FileMode(0600), fi.Mode().Perm())
+
+	_, err = os.Stat(s.tmpDir.Join("out", "skipped.txt"))
+	assert.Assert(c, os.IsNotExist(err))
+}
@@ -0,0 +1,91 @@
+//+build integration
+
+package integration
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type IncrementalSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&IncrementalSuite{})
+
+func (s *IncrementalSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("in",
+			fs.WithFile("a.txt", `{{ template "t" }} {{ (ds "config").name }}`),
+			fs.WithFile("b.txt", `{{ file.Read "extra.txt" }}`),
+		),
+		fs.WithFile("t.tmpl", "t"),
+		fs.WithFile("config.json", `{"name": "a"}`),
+		fs.WithFile("extra.txt", "extra"),
+	)
+}
+
+func (s *IncrementalSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *IncrementalSuite) run(c *C) *icmd.Result {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"--input-dir", "in", "--output-dir", "out",
+			"-t", "t=t.tmpl",
+			"-d", "config.json",
+			"--incremental", "--verbose",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Success)
+	return result
+}
+
+func (s *IncrementalSuite) TestIncremental(c *C) {
+	result := s.run(c)
+	assert.Assert(c, !strings.Contains(result.Stderr(), "skipped"), result.Stderr())
+	assert.Assert(c, fs.Equal(s.tmpDir.Path(), fs.Expected(c,
+		fs.WithDir("out",
+			fs.WithFile("a.txt", "t a", fs.MatchAnyFileMode),
+			fs.WithFile("b.txt", "extra", fs.MatchAnyFileMode),
+			fs.MatchAnyFileMode,
+		),
+		fs.MatchExtraFiles,
+		fs.MatchAnyFileMode,
+	)))
+	_, err := ioutil.ReadFile(filepath.Join(s.tmpDir.Path(), ".gomplate-cache.json"))
+	assert.NilError(c, err)
+
+	result = s.run(c)
+	assert.Assert(c, strings.Contains(result.Stderr(), "rendered 0 template(s)"), result.Stderr())
+	assert.Assert(c, strings.Contains(result.Stderr(), "skipped 2 unchanged template(s)"), result.Stderr())
+
+	err = ioutil.WriteFile(filepath.Join(s.tmpDir.Path(), "extra.txt"), []byte("changed"), 0644)
+	assert.NilError(c, err)
+	result = s.run(c)
+	assert.Assert(c, strings.Contains(result.Stderr(), "rendered 1 template(s)"), result.Stderr())
+	assert.Assert(c, strings.Contains(result.Stderr(), "skipped 1 unchanged template(s)"), result.Stderr())
+	out, err := ioutil.ReadFile(filepath.Join(s.tmpDir.Path(), "out", "b.txt"))
+	assert.NilError(c, err)
+	assert.Equal(c, "changed", string(out))
+}
+
+func (s *IncrementalSuite) TestIncrementalWithDiff(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"--input-dir", "in", "--output-dir", "out",
+			"--incremental", "--diff",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: "--incremental"})
+}
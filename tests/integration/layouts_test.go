@@ -0,0 +1,65 @@
+//+build integration
+
+package integration
+
+import (
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type LayoutsSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&LayoutsSuite{})
+
+func (s *LayoutsSuite) SetUpSuite(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("layouts",
+			fs.WithFile("base.tmpl", `title: {{ block "title" . }}untitled{{ end }}
+{{ block "content" . }}no content{{ end }}
+`),
+			fs.WithFile("page.tmpl", `{{/* extends "layouts/base.tmpl" */}}
+{{ define "content" }}-- {{ block "main" . }}page{{ end }} --{{ end }}`),
+		),
+		fs.WithDir("in",
+			fs.WithFile("a.txt", `{{/* extends "layouts/base.tmpl" */}}
+{{ define "title" }}A{{ end }}
+{{ define "content" }}content for {{ .Env.NAME }}{{ end }}`),
+			fs.WithFile("b.txt", `{{/* extends "layouts/page.tmpl" */}}
+{{ define "title" }}B{{ end }}
+{{ define "main" }}main for b{{ end }}`),
+			fs.WithFile("c.txt", `{{ define "title" }}C{{ end }}{{ template "title" }}`),
+		),
+	)
+}
+
+func (s *LayoutsSuite) TearDownSuite(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *LayoutsSuite) TestLayouts(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "-t", "layouts/", "--input-dir", "in", "--output-dir", "out"},
+		Dir:     s.tmpDir.Path(),
+		Env:     []string{"NAME=alice"},
+	})
+	result.Assert(c, icmd.Success)
+
+	assert.Assert(c, fs.Equal(s.tmpDir.Join("out"), fs.Expected(c,
+		fs.WithFile("a.txt", "title: A\ncontent for alice\n", fs.MatchAnyFileMode),
+		fs.WithFile("b.txt", "title: B\n-- main for b --\n", fs.MatchAnyFileMode),
+		fs.WithFile("c.txt", "C", fs.MatchAnyFileMode),
+		fs.MatchAnyFileMode,
+	)))
+}
+
+func (s *LayoutsSuite) TestMissingLayout(c *C) {
+	result := icmd.RunCommand(GomplateBin,
+		"-i", `{{/* extends "nope.tmpl" */}}`,
+	)
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: `layout "nope.tmpl" (extended by <arg>) not defined`})
+}
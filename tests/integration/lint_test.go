@@ -0,0 +1,62 @@
+//+build integration
+
+package integration
+
+import (
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type LintSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&LintSuite{})
+
+func (s *LintSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("in",
+			fs.WithFile("good.txt", `{{ template "t" }} {{ (ds "config").name }}`),
+			fs.WithFile("bad.txt", "{{ bogus }}\n{{ ds \"nope\" }}"),
+		),
+		fs.WithFile("t.tmpl", `{{ template "missing" }}`),
+		fs.WithFile("ok.tmpl", "ok"),
+		fs.WithFile("config.json", `{"name": "a"}`),
+	)
+}
+
+func (s *LintSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *LintSuite) TestLintProblems(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "lint",
+			"--input-dir", "in",
+			"-t", "t=t.tmpl",
+			"-d", "config.json",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{
+		ExitCode: 1,
+		Out: `in/bad.txt:1:4: function "bogus" not defined
+in/bad.txt:2:7: datasource "nope" not defined
+t.tmpl:1:13: template "missing" not defined`,
+		Err: "3 problem(s) found",
+	})
+}
+
+func (s *LintSuite) TestLintClean(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "lint",
+			"-f", "in/good.txt",
+			"-t", "t=ok.tmpl",
+			"-d", "config.json",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: ""})
+}
@@ -0,0 +1,71 @@
+//+build integration
+
+package integration
+
+import (
+	"io/ioutil"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type MetricsSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&MetricsSuite{})
+
+func (s *MetricsSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithFile("config.json", `{"name": "a"}`),
+	)
+}
+
+func (s *MetricsSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *MetricsSuite) TestPrometheusMetrics(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"-i", `{{ (ds "config").name }}{{ (ds "config").name }}`,
+			"-d", "config.json",
+			"--metrics-file", "metrics.prom",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "aa"})
+
+	b, err := ioutil.ReadFile(s.tmpDir.Join("metrics.prom"))
+	assert.NilError(c, err)
+	out := string(b)
+	for _, line := range []string{
+		"gomplate_success 1",
+		"gomplate_templates_processed 1",
+		`gomplate_datasource_reads{datasource="config"} 1`,
+		`gomplate_datasource_cache_hits{datasource="config"} 1`,
+		`gomplate_datasource_read_bytes{datasource="config"} 13`,
+	} {
+		assert.Assert(c, strings.Contains(out, line+"\n"), "missing %q in:\n%s", line, out)
+	}
+}
+
+func (s *MetricsSuite) TestJSONMetricsOnFailure(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"-i", `{{ fail "oops" }}`,
+			"--metrics-file", "metrics.json",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: "oops"})
+
+	b, err := ioutil.ReadFile(s.tmpDir.Join("metrics.json"))
+	assert.NilError(c, err)
+	assert.Assert(c, strings.Contains(string(b), `"success": false`), string(b))
+	assert.Assert(c, strings.Contains(string(b), `"errors": 1`), string(b))
+}
@@ -0,0 +1,42 @@
+//+build integration
+
+package integration
+
+import (
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type MissingKeySuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&MissingKeySuite{})
+
+func (s *MissingKeySuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithFile("config.json", `{"db": {"host": "db1", "port": null}}`),
+	)
+}
+
+func (s *MissingKeySuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *MissingKeySuite) TestMissingKeyModes(c *C) {
+	run := func(mode, in string) *icmd.Result {
+		return icmd.RunCmd(icmd.Cmd{
+			Command: []string{GomplateBin, "-c", "config=config.json", "--missing-key", mode, "-i", in},
+			Dir:     s.tmpDir.Path(),
+		})
+	}
+
+	run("error", `{{ .config.db.user }}`).Assert(c, icmd.Expected{ExitCode: 1, Err: `map has no entry for key "user"`})
+	run("zero", `[{{ .config.db.user }}][{{ .config.db.port }}]`).Assert(c, icmd.Expected{ExitCode: 0, Out: "[][]"})
+	run("default", `{{ .config.db.user }}`).Assert(c, icmd.Expected{ExitCode: 0, Out: "<no value>"})
+	run("strict", `{{ .config.nope.user }}`).Assert(c, icmd.Expected{ExitCode: 1, Err: `map has no entry for key "nope" (missing .config.nope, from datasource 'config')`})
+	run("strict", `{{ .config.db.port }}`).Assert(c, icmd.Expected{ExitCode: 1, Err: `.config.db.port is nil (from datasource 'config')`})
+	run("bogus", `hi`).Assert(c, icmd.Expected{ExitCode: 1, Err: `unsupported missing key mode "bogus"`})
+}
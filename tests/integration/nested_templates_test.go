@@ -22,6 +22,16 @@ func (s *NestedTemplatesSuite) SetUpSuite(c *C) {
 			fs.WithFile("one.t", `{{ . }}`),
 			fs.WithFile("two.t", `{{ range $n := (seq 2) }}{{ $n }}: {{ $ }} {{ end }}`),
 		),
+		fs.WithDir("lib",
+			fs.WithFile("base.tmpl", `base`),
+			fs.WithDir("forms",
+				fs.WithFile("form.tmpl", `form({{ template "lib/forms/inputs/text.tmpl" }})`),
+				fs.WithFile("README", `not a template`),
+				fs.WithDir("inputs",
+					fs.WithFile("text.tmpl", `text`),
+				),
+			),
+		),
 	)
 }
 
@@ -60,3 +70,37 @@ func (s *NestedTemplatesSuite) TestNestedTemplates(c *C) {
 	result.Assert(c, icmd.Expected{ExitCode: 0, Out: `one
 1: two 2: two`})
 }
+
+func (s *NestedTemplatesSuite) TestRecursiveNestedTemplates(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{
+			GomplateBin,
+			"-t", "lib/",
+			"-i", `{{ template "lib/base.tmpl" }} {{ template "lib/forms/form.tmpl" }}`,
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "base form(text)"})
+}
+
+func (s *NestedTemplatesSuite) TestGlobNestedTemplates(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{
+			GomplateBin,
+			"-t", "p=lib/**/*.tmpl",
+			"-i", `{{ template "p/forms/inputs/text.tmpl" }}`,
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "text"})
+
+	result = icmd.RunCmd(icmd.Cmd{
+		Command: []string{
+			GomplateBin,
+			"-t", "p=lib/**/*.tmpl",
+			"-i", `{{ template "p/forms/README" }}`,
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: `template "p/forms/README" not defined`})
+}
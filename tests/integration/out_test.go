@@ -0,0 +1,77 @@
+//+build integration
+
+package integration
+
+import (
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type OutSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&OutSuite{})
+
+func (s *OutSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("in",
+			fs.WithFile("envs.txt", `{{ define "env" }}env={{ . }}{{ end -}}
+{{ range (ds "config").envs }}{{ tmpl.Exec "env" . | out.Write (print "envs/" . ".txt") }}{{ end -}}
+done`),
+		),
+		fs.WithFile("config.json", `{"envs": ["dev", "prod"]}`),
+	)
+}
+
+func (s *OutSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *OutSuite) TestOutWrite(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"--input-dir", "in", "--output-dir", "out",
+			"-d", "config.json",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Success)
+
+	assert.Assert(c, fs.Equal(s.tmpDir.Path(), fs.Expected(c,
+		fs.WithDir("out",
+			fs.WithFile("envs.txt", "done", fs.MatchAnyFileMode),
+			fs.WithDir("envs",
+				fs.WithFile("dev.txt", "env=dev", fs.MatchAnyFileMode),
+				fs.WithFile("prod.txt", "env=prod", fs.MatchAnyFileMode),
+				fs.MatchAnyFileMode,
+			),
+			fs.MatchAnyFileMode,
+		),
+		fs.MatchExtraFiles,
+		fs.MatchAnyFileMode,
+	)))
+
+	result = icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"--input-dir", "in", "--output-dir", "out",
+			"-d", "config.json",
+			"--check",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Success)
+}
+
+func (s *OutSuite) TestOutWriteOutsideOutputDir(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"-i", `{{ out.Write "../escape.txt" "oops" }}`,
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: "must be in the output directory"})
+}
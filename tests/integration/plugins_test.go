@@ -4,8 +4,11 @@
 package integration
 
 import (
+	"os"
+
 	. "gopkg.in/check.v1"
 
+	"gotest.tools/v3/assert"
 	"gotest.tools/v3/fs"
 	"gotest.tools/v3/icmd"
 )
@@ -33,6 +36,16 @@ write-error $msg
 exit $code
 `, fs.WithMode(0755)),
 		fs.WithFile("sleep.sh", "#!/bin/sh\n\nexec sleep $1\n", fs.WithMode(0755)),
+		fs.WithFile("rpc.sh", `#!/bin/sh
+n=0
+while read -r line; do
+  n=$((n+1))
+  id=$(echo "$line" | sed 's/.*"id":\([0-9]*\).*/\1/')
+  params=$(echo "$line" | sed 's/.*"params":\(.*\)}$/\1/')
+  echo "{\"jsonrpc\":\"2.0\",\"id\":$id,\"result\":{\"call\":$n,\"args\":$params}}"
+done
+echo stopped > "$(dirname "$0")/rpc.stopped"
+`, fs.WithMode(0755)),
 	)
 }
 
@@ -58,6 +71,40 @@ func (s *PluginsSuite) TestPlugins(c *C) {
 	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "hello world"})
 }
 
+func (s *PluginsSuite) TestPluginOptions(c *C) {
+	result := icmd.RunCommand(GomplateBin,
+		"--plugin", "tr=tr?pipe=true",
+		"-i", `{{ "hello world" | tr "a-z" "A-Z" }}`,
+	)
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "HELLO WORLD"})
+
+	result = icmd.RunCommand(GomplateBin,
+		"--plugin", "hi="+s.tmpDir.Join("foo.sh")+"?output=json",
+		"-i", `{{ (hi "{\"a\":[1,2]}").a | len }}`,
+	)
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "2"})
+
+	result = icmd.RunCmd(icmd.Command(GomplateBin,
+		"--plugin", "sleep="+s.tmpDir.Join("sleep.sh")+"?timeout=200ms",
+		"-i", `{{ sleep 2 }}`,
+	), func(c *icmd.Cmd) {
+		c.Env = []string{"GOMPLATE_PLUGIN_TIMEOUT=10s"}
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: "plugin timed out"})
+}
+
+func (s *PluginsSuite) TestJSONRPCPlugin(c *C) {
+	result := icmd.RunCommand(GomplateBin,
+		"--plugin", "rpc="+s.tmpDir.Join("rpc.sh")+"?protocol=jsonrpc",
+		"-i", `{{ $r := rpc "a" (dict "b" 1) }}{{ $r.call }} {{ index $r.args 0 }} {{ (index $r.args 1).b }} {{ (rpc).call }}`,
+	)
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "1 a 1 2"})
+
+	// the plugin is stopped once gomplate is done
+	_, err := os.Stat(s.tmpDir.Join("rpc.stopped"))
+	assert.NilError(c, err)
+}
+
 func (s *PluginsSuite) TestPluginErrors(c *C) {
 	result := icmd.RunCmd(icmd.Command(GomplateBin,
 		"--plugin", "f=false",
@@ -0,0 +1,101 @@
+//+build integration
+//+build !windows
+
+package integration
+
+import (
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type PostRenderSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&PostRenderSuite{})
+
+func (s *PostRenderSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("in",
+			fs.WithFile("a.conf", `valid={{ "true" }}`),
+			fs.WithFile("b.txt", "b"),
+		),
+	)
+}
+
+func (s *PostRenderSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *PostRenderSuite) TestPostRender(c *C) {
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"--input-dir", "in", "--output-dir", "out",
+			"--post-render", `*.conf=sh -c 'grep -q valid=true "$0" && echo "checked $1" >&2' {{ .path }} {{ .input }}`,
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 0, Err: "checked in/a.conf"})
+	assert.Equal(c, "", result.Stdout())
+
+	assert.Assert(c, fs.Equal(s.tmpDir.Join("out"), fs.Expected(c,
+		fs.WithFile("a.conf", "valid=true", fs.MatchAnyFileMode),
+		fs.WithFile("b.txt", "b", fs.MatchAnyFileMode),
+		fs.MatchAnyFileMode,
+	)))
+}
+
+func (s *PostRenderSuite) TestPostRenderFailureRestores(c *C) {
+	s.tmpDir.Remove()
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("in",
+			fs.WithFile("a.conf", `valid={{ "false" }}`),
+		),
+		fs.WithDir("out",
+			fs.WithFile("a.conf", "valid=true"),
+		),
+	)
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"--input-dir", "in", "--output-dir", "out",
+			"--post-render", `*.conf=grep -q valid=true {{ .path }}`,
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: `post-render hook "grep -q valid=true out/a.conf" failed for out/a.conf`})
+
+	assert.Assert(c, fs.Equal(s.tmpDir.Join("out"), fs.Expected(c,
+		fs.WithFile("a.conf", "valid=true", fs.MatchAnyFileMode),
+		fs.MatchAnyFileMode,
+	)))
+}
+
+func (s *PostRenderSuite) TestPostRenderExtraOutputs(c *C) {
+	s.tmpDir.Remove()
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("in",
+			fs.WithFile("a.txt", `{{ out.Write "b.conf" "valid=true" }}{{ out.Write "c.conf" "valid=false" }}a`),
+		),
+		fs.WithDir("out",
+			fs.WithFile("c.conf", "valid=true"),
+		),
+	)
+	result := icmd.RunCmd(icmd.Cmd{
+		Command: []string{GomplateBin,
+			"--input-dir", "in", "--output-dir", "out",
+			"--post-render", `*.conf=grep -q valid=true {{ .path }}`,
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: `post-render hook "grep -q valid=true out/c.conf" failed for out/c.conf`})
+
+	assert.Assert(c, fs.Equal(s.tmpDir.Join("out"), fs.Expected(c,
+		fs.WithFile("a.txt", "a", fs.MatchAnyFileMode),
+		fs.WithFile("b.conf", "valid=true", fs.MatchAnyFileMode),
+		fs.WithFile("c.conf", "valid=true", fs.MatchAnyFileMode),
+		fs.MatchAnyFileMode,
+	)))
+}
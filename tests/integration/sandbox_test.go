@@ -0,0 +1,58 @@
+//+build integration
+
+package integration
+
+import (
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type SandboxSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&SandboxSuite{})
+
+func (s *SandboxSuite) SetUpTest(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("conf",
+			fs.WithFile("config.json", `{"name": "world"}`),
+		),
+		fs.WithFile("secret.json", `{"password": "hunter2"}`),
+		fs.WithFile(".gomplate.yaml", "sandbox:\n  allow: [strings]\n"),
+	)
+}
+
+func (s *SandboxSuite) TearDownTest(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *SandboxSuite) run(args ...string) *icmd.Result {
+	return icmd.RunCmd(icmd.Cmd{
+		Command: append([]string{GomplateBin}, args...),
+		Dir:     s.tmpDir.Path(),
+	})
+}
+
+func (s *SandboxSuite) TestSandbox(c *C) {
+	s.run("--sandbox", "fs-root=conf", "-c", "config=conf/config.json", "-i", `Hello, {{ .config.name | toUpper }}`).
+		Assert(c, icmd.Expected{ExitCode: 0, Out: "Hello, WORLD"})
+
+	s.run("--sandbox", "default", "-i", `{{ getenv "HOME" }}`).
+		Assert(c, icmd.Expected{ExitCode: 1, Err: `template: <arg>:1:3: executing "<arg>" at <getenv "HOME">: error calling getenv: getenv is not allowed by the sandbox policy - add "env" to the allow list to use it`})
+
+	s.run("--sandbox", "fs-root=conf", "-d", "secret=secret.json", "-i", `{{ (ds "secret").password }}`).
+		Assert(c, icmd.Expected{ExitCode: 1, Err: `secret.json is outside of `})
+
+	s.run("--sandbox", "default", "--sandbox", "no-network", "-i", `{{ ds "https://example.com" }}`).
+		Assert(c, icmd.Expected{ExitCode: 1, Err: `Undefined datasource 'https://example.com'`})
+
+	s.run("--sandbox", "allow=bogus", "-i", `hi`).
+		Assert(c, icmd.Expected{ExitCode: 1, Err: `unknown namespace or function "bogus" in the sandbox allow list`})
+
+	// from the config file
+	s.run("-i", `{{ "hi" | toUpper }}{{ (ds "secret").password }}`, "-d", "secret=secret.json").
+		Assert(c, icmd.Expected{ExitCode: 1, Err: `ds is not allowed by the sandbox policy - add "data" to the allow list to use it`})
+}
@@ -0,0 +1,81 @@
+//+build integration
+
+package integration
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type ServeSuite struct {
+	tmpDir *fs.Dir
+	addr   string
+	result *icmd.Result
+}
+
+var _ = Suite(&ServeSuite{})
+
+func (s *ServeSuite) SetUpSuite(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithFile("config.json", `{"name": "world"}`),
+		fs.WithFile("t.tmpl", `Hello, {{ . }}`),
+	)
+	_, s.addr = freeport()
+	s.result = icmd.StartCmd(icmd.Cmd{
+		Command: []string{GomplateBin, "serve",
+			"--listen", s.addr,
+			"-c", "config=config.json",
+			"-t", "t=t.tmpl",
+		},
+		Dir: s.tmpDir.Path(),
+	})
+	err := waitForURL(c, "http://"+s.addr+"/healthz")
+	handle(c, err)
+}
+
+func (s *ServeSuite) TearDownSuite(c *C) {
+	defer s.tmpDir.Remove()
+	err := s.result.Cmd.Process.Signal(os.Interrupt)
+	handle(c, err)
+	result := icmd.WaitOnCmd(0, s.result)
+	result.Assert(c, icmd.Success)
+}
+
+func (s *ServeSuite) post(c *C, contentType, body string) (int, string) {
+	resp, err := http.Post("http://"+s.addr+"/render", contentType, strings.NewReader(body))
+	assert.NilError(c, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NilError(c, err)
+	return resp.StatusCode, string(b)
+}
+
+func (s *ServeSuite) TestRender(c *C) {
+	code, body := s.post(c, "text/plain", `{{ template "t" .config.name }}!`)
+	assert.Equal(c, http.StatusOK, code)
+	assert.Equal(c, "Hello, world!", body)
+
+	code, body = s.post(c, "application/json", `{"template": "{{ .config.name }}, {{ .user }}", "context": {"user": "me"}}`)
+	assert.Equal(c, http.StatusOK, code)
+	assert.Equal(c, "world, me", body)
+}
+
+func (s *ServeSuite) TestRenderError(c *C) {
+	code, body := s.post(c, "text/plain", `{{ ds "nope" }}`)
+	assert.Equal(c, http.StatusUnprocessableEntity, code)
+	assert.Assert(c, strings.Contains(body, `"datasource":"nope"`), body)
+}
+
+func (s *ServeSuite) TestSandboxedByDefault(c *C) {
+	code, body := s.post(c, "text/plain", `{{ getenv "HOME" }}`)
+	assert.Equal(c, http.StatusUnprocessableEntity, code)
+	assert.Assert(c, strings.Contains(body, "not allowed by the sandbox policy"), body)
+}
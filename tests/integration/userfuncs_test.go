@@ -0,0 +1,53 @@
+//+build integration
+
+package integration
+
+import (
+	. "gopkg.in/check.v1"
+
+	"gotest.tools/v3/fs"
+	"gotest.tools/v3/icmd"
+)
+
+type UserFuncsSuite struct {
+	tmpDir *fs.Dir
+}
+
+var _ = Suite(&UserFuncsSuite{})
+
+func (s *UserFuncsSuite) SetUpSuite(c *C) {
+	s.tmpDir = fs.NewDir(c, "gomplate-inttests",
+		fs.WithDir("lib",
+			fs.WithFile("names.t", `{{ define "fn:fullName" -}}
+{{ index .Args 0 }} {{ index .Args 1 }}
+{{- end }}
+{{- define "fn:initials" -}}
+{{ $.Return (coll.Slice (index .Args 0 | strings.Trunc 1) (index .Args 1 | strings.Trunc 1)) }}
+{{- end }}`),
+		),
+		fs.WithFile("greet.t", `Hello, {{ index .Args 0 }}!`),
+	)
+}
+
+func (s *UserFuncsSuite) TearDownSuite(c *C) {
+	s.tmpDir.Remove()
+}
+
+func (s *UserFuncsSuite) TestUserFuncs(c *C) {
+	result := icmd.RunCommand(GomplateBin,
+		"-t", s.tmpDir.Join("lib")+"/",
+		"-t", "fn:greet="+s.tmpDir.Join("greet.t"),
+		"-i", `{{ greet (fullName "Jane" "Doe") }} {{ join (initials "Jane" "Doe") "." }}`,
+	)
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "Hello, Jane Doe! J.D"})
+
+	result = icmd.RunCommand(GomplateBin,
+		"-i", `{{ define "fn:sum" }}{{ $t := 0 }}{{ range .Args }}{{ $t = math.Add $t . }}{{ end }}{{ $.Return $t }}{{ end }}{{ sum 1 2 3 }}`,
+	)
+	result.Assert(c, icmd.Expected{ExitCode: 0, Out: "6"})
+
+	result = icmd.RunCommand(GomplateBin,
+		"-i", `{{ define "fn:env" }}{{ end }}`,
+	)
+	result.Assert(c, icmd.Expected{ExitCode: 1, Err: `function "env" (defined by template "fn:env") is already bound`})
+}
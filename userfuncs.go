@@ -0,0 +1,138 @@
+package gomplate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+)
+
+// userFuncPrefix - templates with names starting with this prefix (like
+// "fn:fullName") define functions, named by the rest of the template name
+const userFuncPrefix = "fn:"
+
+// maxUserFuncDepth - how deeply user-defined functions can call each other
+// (or themselves), to catch runaway recursion
+const maxUserFuncDepth = 1000
+
+var userFuncNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// builtinFuncs - text/template's built-in functions, which can't be
+// overridden by user-defined functions
+var builtinFuncs = map[string]bool{
+	"and": true, "call": true, "html": true, "index": true, "slice": true,
+	"js": true, "len": true, "not": true, "or": true, "print": true,
+	"printf": true, "println": true, "urlquery": true, "eq": true, "ge": true,
+	"gt": true, "le": true, "lt": true, "ne": true,
+}
+
+// errUserFuncDepth - returned (unwrapped) when user-defined functions recurse
+// too deeply
+var errUserFuncDepth = fmt.Errorf("exceeded the maximum call depth (%d) of user-defined functions", maxUserFuncDepth)
+
+// fnCall - the context a user-defined function's template is executed with
+type fnCall struct {
+	// Args - the arguments the function was called with
+	Args []interface{}
+	// Ctx - the context of the template the function was called from
+	Ctx interface{}
+
+	ret      interface{}
+	returned bool
+}
+
+// Return - set the value the function returns (instead of its output). Since
+// the context changes within range and with blocks, it's usually called as
+// $.Return.
+func (c *fnCall) Return(v interface{}) string {
+	c.ret = v
+	c.returned = true
+	return ""
+}
+
+// userFuncNames - the names of the functions defined in the template source
+// (with define or block actions), found before the template is parsed, since
+// functions must be known when templates calling them are parsed. The names
+// are in the order they're defined, without duplicates.
+func userFuncNames(src, ldelim string) []string {
+	re := regexp.MustCompile(regexp.QuoteMeta(ldelim) + `-?\s*(?:define|block)\s+["` + "`" + `]` +
+		regexp.QuoteMeta(userFuncPrefix) + `([^"` + "`" + `]*)["` + "`" + `]`)
+	names := []string{}
+	for _, m := range re.FindAllStringSubmatch(src, -1) {
+		names = append(names, m[1])
+	}
+	return unique(names)
+}
+
+// userFuncName - the function name for the template name, if it's a function
+func userFuncName(tmplName string) (string, bool) {
+	if !strings.HasPrefix(tmplName, userFuncPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(tmplName, userFuncPrefix), true
+}
+
+// definedUserFuncs - the names of the functions defined in the template's set
+func definedUserFuncs(tmpl *template.Template) []string {
+	names := []string{}
+	for _, t := range tmpl.Templates() {
+		if name, ok := userFuncName(t.Name()); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addUserFuncs - add the named user-defined functions to funcs. Each executes
+// its "fn:" template from root's set, with the given context available as
+// .Ctx. Functions can't override built-in functions (or plugins).
+func (g *gomplate) addUserFuncs(funcs template.FuncMap, root *template.Template, ctx interface{}, names ...string) error {
+	var depth int32
+	for _, name := range names {
+		if !userFuncNameRe.MatchString(name) {
+			return fmt.Errorf("invalid function name %q in template %q - must be a valid identifier", name, userFuncPrefix+name)
+		}
+		_, builtin := g.funcMap[name]
+		if builtin || builtinFuncs[name] || name == "tmpl" || name == "tpl" {
+			return fmt.Errorf("function %q (defined by template %q) is already bound, and can not be overridden", name, userFuncPrefix+name)
+		}
+		funcs[name] = userFunc(root, name, ctx, &depth)
+	}
+	return nil
+}
+
+// userFunc - the function which executes the template defining the named
+// function. The depth is shared by all of a template's functions.
+func userFunc(root *template.Template, name string, ctx interface{}, depth *int32) func(...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		t := root.Lookup(userFuncPrefix + name)
+		if t == nil {
+			return nil, fmt.Errorf("template %q not defined", userFuncPrefix+name)
+		}
+		defer atomic.AddInt32(depth, -1)
+		if atomic.AddInt32(depth, 1) > maxUserFuncDepth {
+			return nil, errUserFuncDepth
+		}
+		if args == nil {
+			args = []interface{}{}
+		}
+		call := &fnCall{Args: args, Ctx: ctx}
+		out := &bytes.Buffer{}
+		if err := t.Execute(out, call); err != nil {
+			// only report where the recursion started, not every level of it
+			if errors.Is(err, errUserFuncDepth) {
+				return nil, errUserFuncDepth
+			}
+			return nil, err
+		}
+		if call.returned {
+			return call.ret, nil
+		}
+		return out.String(), nil
+	}
+}
@@ -0,0 +1,89 @@
+package gomplate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserFuncNames(t *testing.T) {
+	in := `{{ define "fn:a" }}{{ end }}{{- define "b" }}{{ end }}
+{{- block ` + "`fn:c`" + ` . }}{{ end }}{{/* define "fn:d" */}}{{define "fn:a"}}{{end}}`
+	assert.Equal(t, []string{"a", "c"}, userFuncNames(in, "{{"))
+	assert.Equal(t, []string{"x"}, userFuncNames(`[[ define "fn:x" ]]{{ define "fn:y" }}`, "[["))
+	assert.Equal(t, []string{}, userFuncNames(`no functions`, "{{"))
+}
+
+func TestUserFuncs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "/lib.t", []byte(`{{ define "fn:fullName" }}{{ index .Args 0 }} {{ index .Args 1 }}{{ end }}`), 0644)
+	_ = afero.WriteFile(fs, "/greet.t", []byte(`Hello, {{ index .Args 0 }}`), 0644)
+	_ = afero.WriteFile(fs, "/ctx.json", []byte(`{"name": "world"}`), 0644)
+
+	r, err := NewRenderer(RenderOptions{
+		Fs:        fs,
+		Templates: []string{"lib=/lib.t", "fn:greet=/greet.t"},
+		Contexts:  []string{"ctx=file:///ctx.json"},
+	})
+	assert.NoError(t, err)
+	defer r.Close()
+	render := func(in string) (string, error) {
+		out := &bytes.Buffer{}
+		_, err := r.Render(context.Background(), "in", strings.NewReader(in), out)
+		return out.String(), err
+	}
+
+	testdata := []struct {
+		in, out string
+	}{
+		{`{{ fullName "Jane" "Doe" }}`, "Jane Doe"},
+		{`{{ greet "you" }}`, "Hello, you"},
+		{`{{ define "fn:pair" }}{{ $.Return (coll.Slice (index .Args 0) (index .Args 1)) }}{{ end }}` +
+			`{{ range pair 1 "b" }}<{{ . }}>{{ end }} {{ printf "%T" (index (pair 1 2) 0) }}`, "<1><b> int"},
+		{`{{ define "fn:nargs" }}{{ $.Return (coll.Dict "n" (len .Args)) }}{{ end }}{{ (nargs 1 2 3).n }}`, "3"},
+		{`{{ define "fn:fact" }}{{ $n := index .Args 0 }}{{ if le $n 1 }}{{ $.Return 1 }}` +
+			`{{ else }}{{ $.Return (math.Mul $n (fact (math.Sub $n 1))) }}{{ end }}{{ end }}{{ fact 5 }}`, "120"},
+		{`{{ define "fn:name" }}{{ .Ctx.ctx.name }}{{ end }}{{ name }}`, "world"},
+		{`{{ define "fn:count" }}{{ len .Args }}{{ end }}{{ count }}`, "0"},
+	}
+	for _, d := range testdata {
+		out, err := render(d.in)
+		assert.NoError(t, err, d.in)
+		assert.Equal(t, d.out, out, d.in)
+	}
+
+	// functions defined by one template aren't visible to others
+	_, err = render(`{{ pair 1 2 }}`)
+	assert.EqualError(t, err, `template: in:1: function "pair" not defined`)
+
+	_, err = render(`{{ define "fn:loop" }}{{ loop }}{{ end }}{{ loop }}`)
+	assert.Contains(t, err.Error(), "error calling loop: exceeded the maximum call depth (1000) of user-defined functions")
+
+	_, err = render(`{{ define "fn:print" }}{{ end }}`)
+	assert.EqualError(t, err, `function "print" (defined by template "fn:print") is already bound, and can not be overridden`)
+	_, err = render(`{{ define "fn:base64" }}{{ end }}`)
+	assert.EqualError(t, err, `function "base64" (defined by template "fn:base64") is already bound, and can not be overridden`)
+	_, err = render(`{{ define "fn:a.b" }}{{ end }}`)
+	assert.EqualError(t, err, `invalid function name "a.b" in template "fn:a.b" - must be a valid identifier`)
+}
+
+func TestLintUserFuncs(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+	_ = afero.WriteFile(fs, "lib.t", []byte(`{{ define "fn:hi" }}hi{{ end }}{{ local }}`), 0644)
+
+	problems, err := Lint(&Config{
+		Input:     `{{ define "fn:local" }}{{ end }}{{ local }}{{ hi }}{{ greet }}{{ nope }}`,
+		Templates: []string{"lib=lib.t", "fn:greet=lib.t"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []LintProblem{
+		{Template: "<arg>", Line: 1, Col: 66, Message: `function "nope" not defined`},
+		{Template: "lib.t", Line: 1, Col: 35, Message: `function "local" not defined`},
+	}, problems)
+}
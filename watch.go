@@ -0,0 +1,308 @@
+package gomplate
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hairyhenderson/gomplate/data"
+	"github.com/spf13/afero"
+)
+
+// how often watched files are checked for changes - for overriding in tests
+var watchInterval = time.Second
+
+// where errors are reported while watching, since they don't stop the watch
+var watchErrOut io.Writer = os.Stderr
+
+// interruptCh - returns a channel that's closed when the process is
+// interrupted or terminated
+func interruptCh() <-chan struct{} {
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		signal.Stop(sigs)
+		close(stop)
+	}()
+	return stop
+}
+
+// watcher tracks the files that rendered templates depend on: the input
+// templates themselves, nested templates, file datasources, and any other
+// files read while rendering
+type watcher struct {
+	g         *gomplate
+	o         *Config
+	d         *data.Data
+	templates []*tplate
+
+	// the last-seen state of each watched path
+	stamps map[string]string
+}
+
+// watch - render all templates, then keep re-rendering affected templates
+// whenever a watched file changes, until stop is closed.
+//
+// Outputs are only re-rendered when a file read while rendering them (as
+// recorded by g.deps) changes - outputs written to stdout, or rendered without
+// tracking, are re-rendered whenever anything but another input template
+// changes. New files in the input directory are rendered as they're found.
+// Errors are reported, but don't stop the watch.
+func (g *gomplate) watch(o *Config, d *data.Data, stop <-chan struct{}) error {
+	tmpl, err := g.gatherTemplates(o)
+	if err != nil {
+		return err
+	}
+
+	w := &watcher{g: g, o: o, d: d, templates: tmpl, stamps: map[string]string{}}
+	w.refresh()
+	w.render(tmpl)
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// paths - all watched paths. Templates read from stdin or given inline can't
+// be watched.
+func (w *watcher) paths() []string {
+	paths := []string{}
+	for _, t := range w.templates {
+		if t.name != "-" && t.name != "<arg>" {
+			paths = append(paths, t.name)
+		}
+	}
+	paths = append(paths, nestedTemplateFiles(w.g.nestedTemplates)...)
+	for p := range w.sourcePaths() {
+		paths = append(paths, p)
+	}
+	if w.g.deps != nil {
+		paths = append(paths, w.g.deps.files()...)
+	}
+	return paths
+}
+
+// sourcePaths - the paths of all file datasources, mapped to their aliases
+func (w *watcher) sourcePaths() map[string]string {
+	paths := map[string]string{}
+	for alias, s := range w.d.Sources {
+		if s.URL != nil && s.URL.Scheme == "file" {
+			paths[filepath.FromSlash(s.URL.Path)] = alias
+		}
+	}
+	return paths
+}
+
+// refresh - start tracking any paths not yet tracked, such as datasources
+// defined by a template with defineDatasource
+func (w *watcher) refresh() {
+	for _, p := range w.paths() {
+		if _, ok := w.stamps[p]; !ok {
+			w.stamps[p] = stamp(w.g.fs, p)
+		}
+	}
+}
+
+// changed - the watched paths that have changed since last checked
+func (w *watcher) changed() []string {
+	changed := []string{}
+	for p, old := range w.stamps {
+		s := stamp(w.g.fs, p)
+		if s != old {
+			w.stamps[p] = s
+			changed = append(changed, p)
+		}
+	}
+	return changed
+}
+
+// relist - list the input directory again, to pick up templates added to it
+// (and drop those removed) since it was last listed. Returns the templates
+// for new files, which haven't been rendered yet.
+func (w *watcher) relist() []*tplate {
+	if w.o.InputDir == "" {
+		return nil
+	}
+	files, err := listDir(w.g.fs, w.o.InputDir, w.o.ExcludeGlob)
+	if err != nil {
+		w.report(err)
+		return nil
+	}
+	inputs := map[string]bool{}
+	for _, t := range w.templates {
+		inputs[t.name] = true
+	}
+	same := len(files) == len(inputs)
+	for _, f := range files {
+		same = same && inputs[filepath.Join(w.o.InputDir, f)]
+	}
+	if same {
+		return nil
+	}
+
+	tmpl, err := w.g.gatherTemplates(w.o)
+	if err != nil {
+		w.report(err)
+		return nil
+	}
+	// templates already known are kept as they are, so that they're only
+	// re-rendered when affected by a change
+	known := map[string]*tplate{}
+	for _, t := range w.templates {
+		known[t.name+"\x00"+t.targetPath] = t
+	}
+	added := []*tplate{}
+	for i, t := range tmpl {
+		key := t.name + "\x00" + t.targetPath
+		prev, ok := known[key]
+		if !ok {
+			added = append(added, t)
+			continue
+		}
+		// nolint: errcheck
+		abortTarget(t.target)
+		tmpl[i] = prev
+		delete(known, key)
+	}
+	for _, t := range known {
+		delete(w.stamps, t.name)
+	}
+	w.templates = tmpl
+	return added
+}
+
+// check - re-render the templates affected by any changed files, and render
+// any new templates
+func (w *watcher) check() {
+	added := w.relist()
+	changed := w.changed()
+	if len(changed) == 0 && len(added) == 0 {
+		return
+	}
+
+	sources := w.sourcePaths()
+	nested := map[string]bool{}
+	for _, p := range nestedTemplateFiles(w.g.nestedTemplates) {
+		nested[p] = true
+	}
+	contexts := map[string]bool{}
+	for _, c := range w.o.Contexts {
+		contexts[parseAlias(c)] = true
+	}
+
+	reloadContext := false
+	reparse := false
+	changedFiles := map[string]bool{}
+	for _, p := range changed {
+		changedFiles[relToWd(p)] = true
+		reparse = reparse || nested[p]
+		if alias, ok := sources[p]; ok {
+			w.d.ClearCache(alias)
+			reloadContext = reloadContext || contexts[alias]
+		}
+	}
+
+	if reloadContext {
+		c, err := createTmplContext(w.o.Contexts, w.d)
+		if err != nil {
+			w.report(err)
+			return
+		}
+		w.g.tmplctx = w.g.sandbox.context(c)
+	}
+	if reparse {
+		w.g.resetBaseTemplate()
+	}
+
+	isNew := map[*tplate]bool{}
+	for _, t := range added {
+		isNew[t] = true
+	}
+	affected := []*tplate{}
+	for _, t := range w.templates {
+		if isNew[t] || w.affects(t, changedFiles) {
+			affected = append(affected, t)
+		}
+	}
+	w.render(affected)
+}
+
+// affects - whether any of the changed files may affect the template's
+// output: its own input, or any file read while it was last rendered. When
+// reads aren't tracked for the output, any file but another input template
+// may affect it.
+func (w *watcher) affects(t *tplate, changed map[string]bool) bool {
+	if changed[relToWd(t.name)] {
+		return true
+	}
+	if w.g.deps != nil && t.targetPath != "-" {
+		return w.g.deps.dependsOn(t.targetPath, changed)
+	}
+	inputs := map[string]bool{}
+	for _, t := range w.templates {
+		inputs[relToWd(t.name)] = true
+	}
+	for p := range changed {
+		if !inputs[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// render - (re-)render the given templates, reporting any errors
+func (w *watcher) render(tmpl []*tplate) {
+	ready := make([]*tplate, 0, len(tmpl))
+	for _, t := range tmpl {
+		// targets are opened when templates are gathered, so only re-renders
+		// need to reset
+		if t.target == nil {
+			if err := t.reset(w.g.fs); err != nil {
+				w.report(err)
+				continue
+			}
+		}
+		ready = append(ready, t)
+	}
+	if err := w.g.renderTemplates(ready, w.o.Parallelism); err != nil {
+		w.report(err)
+	}
+	for _, t := range ready {
+		t.target = nil
+	}
+	w.refresh()
+}
+
+func (w *watcher) report(err error) {
+	// nolint: errcheck
+	WriteErrorReports(watchErrOut, err, w.o.ErrorFormat)
+}
+
+// stamp - a string which changes whenever the file (or any file in the
+// directory) at the given path is modified, added, or removed
+func stamp(fs afero.Fs, p string) string {
+	sb := &strings.Builder{}
+	// nolint: errcheck
+	afero.Walk(fs, p, func(subpath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintf(sb, "%s:%d:%d\n", subpath, fi.ModTime().UnixNano(), fi.Size())
+		return nil
+	})
+	return sb.String()
+}
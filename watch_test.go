@@ -0,0 +1,134 @@
+package gomplate
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/hairyhenderson/gomplate/data"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStamp(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.Equal(t, "", stamp(fs, "missing"))
+
+	_ = afero.WriteFile(fs, "dir/foo", []byte("foo"), 0644)
+	s := stamp(fs, "dir")
+	assert.NotEqual(t, "", s)
+	assert.Equal(t, s, stamp(fs, "dir"))
+
+	_ = afero.WriteFile(fs, "dir/bar", []byte("bar"), 0644)
+	assert.NotEqual(t, s, stamp(fs, "dir"))
+
+	s = stamp(fs, "dir/foo")
+	later := time.Now().Add(time.Minute)
+	_ = fs.Chtimes("dir/foo", later, later)
+	assert.NotEqual(t, s, stamp(fs, "dir/foo"))
+}
+
+func TestWatcher(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	origErrOut := watchErrOut
+	defer func() { watchErrOut = origErrOut }()
+	errOut := &bytes.Buffer{}
+	watchErrOut = errOut
+
+	_ = afero.WriteFile(fs, "in/a", []byte(`a{{ template "t" }}`), 0644)
+	_ = afero.WriteFile(fs, "in/b", []byte(`b{{ template "t" }}`), 0644)
+	_ = afero.WriteFile(fs, "in/c", []byte(`c{{ (ds "data").v }}`), 0644)
+	_ = afero.WriteFile(fs, "t.tmpl", []byte(`1`), 0644)
+	_ = afero.WriteFile(fs, "data.json", []byte(`{"v": 1}`), 0644)
+
+	deps := newDepTracker()
+	d := &data.Data{
+		Sources: map[string]*data.Source{
+			"data": {Alias: "data", URL: &url.URL{Scheme: "file", Path: "data.json"}},
+		},
+		Fs:         fs,
+		OnFileRead: deps.record,
+	}
+	g := newGomplate(fs, template.FuncMap{"ds": d.Datasource}, "{{", "}}", templateAliases{"t": "t.tmpl"}, nil)
+	g.deps = deps
+	o := &Config{InputDir: "in", OutputDir: "out"}
+	tmpl, err := g.gatherTemplates(o)
+	assert.NoError(t, err)
+
+	w := &watcher{g: g, o: o, d: d, templates: tmpl, stamps: map[string]string{}}
+	w.refresh()
+	assert.Len(t, w.stamps, 5)
+	w.render(tmpl)
+	assertFile(t, "out/a", "a1")
+	assertFile(t, "out/b", "b1")
+	assertFile(t, "out/c", "c1")
+
+	// no changes, nothing re-rendered
+	_ = afero.WriteFile(fs, "out/a", []byte("untouched"), 0644)
+	w.check()
+	assertFile(t, "out/a", "untouched")
+
+	// only the changed input is re-rendered
+	_ = afero.WriteFile(fs, "in/b", []byte(`B{{ template "t" }}`), 0644)
+	w.check()
+	assertFile(t, "out/a", "untouched")
+	assertFile(t, "out/b", "B1")
+
+	// everything is re-rendered when a nested template changes
+	_ = afero.WriteFile(fs, "t.tmpl", []byte(`22`), 0644)
+	_ = afero.WriteFile(fs, "out/c", []byte("untouched"), 0644)
+	w.check()
+	assertFile(t, "out/a", "a22")
+	assertFile(t, "out/b", "B22")
+	assertFile(t, "out/c", "c1")
+
+	// only outputs which read a datasource are re-rendered when it changes
+	_ = afero.WriteFile(fs, "out/a", []byte("untouched"), 0644)
+	_ = afero.WriteFile(fs, "data.json", []byte(`{"v": 2}`), 0644)
+	w.check()
+	assertFile(t, "out/a", "untouched")
+	assertFile(t, "out/c", "c2")
+
+	// new files in the input directory are rendered
+	_ = afero.WriteFile(fs, "in/d", []byte(`d{{ template "t" }}`), 0644)
+	w.check()
+	assertFile(t, "out/a", "untouched")
+	assertFile(t, "out/d", "d22")
+	_ = afero.WriteFile(fs, "in/d", []byte(`D`), 0644)
+	w.check()
+	assertFile(t, "out/d", "D")
+
+	// errors are reported, and don't stop the watch
+	_ = afero.WriteFile(fs, "in/a", []byte(`{{ bogus }}`), 0644)
+	w.check()
+	assert.Contains(t, errOut.String(), "bogus")
+	assert.Equal(t, 1, g.metrics.Errors)
+}
+
+func TestWatchStops(t *testing.T) {
+	origfs := fs
+	defer func() { fs = origfs }()
+	fs = afero.NewMemMapFs()
+
+	_ = afero.WriteFile(fs, "in.tmpl", []byte(`hello`), 0644)
+	g := newGomplate(fs, template.FuncMap{}, "{{", "}}", nil, nil)
+	o := &Config{InputFiles: []string{"in.tmpl"}, OutputFiles: []string{"out"}}
+
+	stop := make(chan struct{})
+	close(stop)
+	err := g.watch(o, &data.Data{}, stop)
+	assert.NoError(t, err)
+	assertFile(t, "out", "hello")
+}
+
+func assertFile(t *testing.T, path, expected string) {
+	t.Helper()
+	b, err := afero.ReadFile(fs, path)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(b))
+}